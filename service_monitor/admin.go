@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Pagination limits of /admin/services
+const (
+	defaultServicesPerPage = 20
+	maxServicesPerPage     = 100
+)
+
+// serviceChangedAt records when each service last appeared or changed status
+// It is guarded by configMutex
+var serviceChangedAt = map[string]time.Time{}
+
+// recordStatusChanges updates serviceChangedAt for the transition from prev to next
+// The caller must hold configMutex for writing when other goroutines are running
+func recordStatusChanges(prev, next *Config, now time.Time) {
+	before := serviceStatuses(prev)
+	after := serviceStatuses(next)
+
+	for name, up := range after {
+		if wasUp, ok := before[name]; !ok || wasUp != up {
+			serviceChangedAt[name] = now
+		}
+	}
+	for name := range serviceChangedAt {
+		if _, ok := after[name]; !ok {
+			delete(serviceChangedAt, name)
+		}
+	}
+}
+
+// serviceStatuses maps each service in config to whether it is up
+func serviceStatuses(config *Config) map[string]bool {
+	statuses := make(map[string]bool)
+	if config == nil {
+		return statuses
+	}
+	for _, name := range config.DownServices {
+		statuses[name] = false
+	}
+	for _, name := range config.UpServices {
+		statuses[name] = true
+	}
+	return statuses
+}
+
+// adminService is a single entry of the /admin/services response
+type adminService struct {
+	Name          string    `json:"name"`
+	Status        string    `json:"status"`
+	Source        string    `json:"source"`
+	LastChangedAt time.Time `json:"last_changed_at"`
+}
+
+// adminServicesResponse is the JSON body returned by /admin/services
+type adminServicesResponse struct {
+	Services   []adminService `json:"services"`
+	Total      int            `json:"total"`
+	Page       int            `json:"page"`
+	PerPage    int            `json:"per_page"`
+	TotalPages int            `json:"total_pages"`
+}
+
+// basicAuthMiddleware rejects requests without the given credentials
+func basicAuthMiddleware(username, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+		passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+		if !ok || !userMatch || !passMatch {
+			w.Header().Set("WWW-Authenticate", `Basic realm="service_monitor"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminServicesHandler lists the current services with filtering, sorting and pagination
+func adminServicesHandler(cfg *ServerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+
+		status := query.Get("status")
+		if status != "" && status != "up" && status != "down" {
+			http.Error(w, "status must be up or down", http.StatusBadRequest)
+			return
+		}
+
+		sortBy := query.Get("sort")
+		if sortBy == "" {
+			sortBy = "name"
+		}
+		if sortBy != "name" && sortBy != "status" {
+			http.Error(w, "sort must be name or status", http.StatusBadRequest)
+			return
+		}
+
+		page, err := positiveQueryInt(query.Get("page"), 1)
+		if err != nil {
+			http.Error(w, "page must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		perPage, err := positiveQueryInt(query.Get("per_page"), defaultServicesPerPage)
+		if err != nil {
+			http.Error(w, "per_page must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		if perPage > maxServicesPerPage {
+			perPage = maxServicesPerPage
+		}
+
+		// serviceChangedAt is only consistent with the config it was recorded for
+		// while configMutex is held
+		configMutex.RLock()
+		var services []adminService
+		for name, up := range serviceStatuses(loadCurrentConfig()) {
+			entry := adminService{
+				Name:          name,
+				Status:        "down",
+				Source:        cfg.serviceSource(),
+				LastChangedAt: serviceChangedAt[name],
+			}
+			if up {
+				entry.Status = "up"
+			}
+			if status == "" || status == entry.Status {
+				services = append(services, entry)
+			}
+		}
+		configMutex.RUnlock()
+
+		sort.Slice(services, func(i, j int) bool {
+			if sortBy == "status" && services[i].Status != services[j].Status {
+				return services[i].Status < services[j].Status
+			}
+			return services[i].Name < services[j].Name
+		})
+
+		total := len(services)
+		totalPages := (total + perPage - 1) / perPage
+
+		// Pages past the end return the last page rather than an error
+		if totalPages > 0 && page > totalPages {
+			page = totalPages
+		}
+		start := (page - 1) * perPage
+		if start > total {
+			start = total
+		}
+		end := start + perPage
+		if end > total {
+			end = total
+		}
+
+		writeJSON(w, http.StatusOK, adminServicesResponse{
+			Services:   append([]adminService{}, services[start:end]...),
+			Total:      total,
+			Page:       page,
+			PerPage:    perPage,
+			TotalPages: totalPages,
+		})
+	}
+}
+
+// positiveQueryInt parses a positive integer query value, returning fallback when empty
+func positiveQueryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, strconv.ErrSyntax
+	}
+	return n, nil
+}
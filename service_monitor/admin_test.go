@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setTestServices applies a config with the given services for the duration of a test
+func setTestServices(t *testing.T, up, down []string) {
+	t.Helper()
+	origConfig, origChanged := loadCurrentConfig(), serviceChangedAt
+	serviceChangedAt = map[string]time.Time{}
+	t.Cleanup(func() {
+		currentConfig.Store(origConfig)
+		serviceChangedAt = origChanged
+	})
+
+	currentConfig.Store(nil)
+	updateServiceMetrics(newTestMetrics(), &Config{UpServices: up, DownServices: down})
+}
+
+func serviceNames(services []adminService) []string {
+	names := make([]string, len(services))
+	for i, svc := range services {
+		names[i] = svc.Name
+	}
+	return names
+}
+
+func TestAdminServicesHandler(t *testing.T) {
+	setTestServices(t, []string{"c-up", "a-up", "e-up"}, []string{"d-down", "b-down"})
+
+	tests := []struct {
+		name      string
+		query     string
+		wantNames []string
+		wantPage  int
+		wantPages int
+		wantTotal int
+	}{
+		{"defaults", "", []string{"a-up", "b-down", "c-up", "d-down", "e-up"}, 1, 1, 5},
+		{"up by name", "status=up&sort=name", []string{"a-up", "c-up", "e-up"}, 1, 1, 3},
+		{"down by name", "status=down&sort=name", []string{"b-down", "d-down"}, 1, 1, 2},
+		{"all by status", "sort=status", []string{"b-down", "d-down", "a-up", "c-up", "e-up"}, 1, 1, 5},
+		{"up by status", "status=up&sort=status", []string{"a-up", "c-up", "e-up"}, 1, 1, 3},
+		{"down by status", "status=down&sort=status", []string{"b-down", "d-down"}, 1, 1, 2},
+		{"first page", "per_page=2", []string{"a-up", "b-down"}, 1, 3, 5},
+		{"middle page", "per_page=2&page=2", []string{"c-up", "d-down"}, 2, 3, 5},
+		{"partial last page", "per_page=2&page=3", []string{"e-up"}, 3, 3, 5},
+		{"page past the end", "per_page=2&page=99", []string{"e-up"}, 3, 3, 5},
+		{"filtered page past the end", "status=down&per_page=1&page=5", []string{"d-down"}, 2, 2, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/services?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			adminServicesHandler(&ServerConfig{})(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+
+			var resp adminServicesResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if got := fmt.Sprint(serviceNames(resp.Services)); got != fmt.Sprint(tt.wantNames) {
+				t.Errorf("expected services %v, got %v", tt.wantNames, got)
+			}
+			if resp.Page != tt.wantPage || resp.TotalPages != tt.wantPages || resp.Total != tt.wantTotal {
+				t.Errorf("expected page %d of %d with %d total, got page %d of %d with %d total",
+					tt.wantPage, tt.wantPages, tt.wantTotal, resp.Page, resp.TotalPages, resp.Total)
+			}
+			for _, svc := range resp.Services {
+				if svc.Source != configSourceFile || svc.LastChangedAt.IsZero() {
+					t.Errorf("expected source and last change time for %s, got %+v", svc.Name, svc)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminServicesHandler_InvalidQuery(t *testing.T) {
+	setTestServices(t, []string{"api"}, nil)
+
+	for _, query := range []string{"status=sideways", "sort=age", "page=0", "page=abc", "per_page=-1"} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/services?"+query, nil)
+		rec := httptest.NewRecorder()
+		adminServicesHandler(&ServerConfig{})(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestAdminServicesHandler_Empty(t *testing.T) {
+	setTestServices(t, nil, nil)
+
+	rec := httptest.NewRecorder()
+	adminServicesHandler(&ServerConfig{})(rec, httptest.NewRequest(http.MethodGet, "/admin/services?page=3", nil))
+
+	var resp adminServicesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if resp.Services == nil || len(resp.Services) != 0 || resp.Total != 0 || resp.TotalPages != 0 {
+		t.Errorf("expected an empty list, got %+v", resp)
+	}
+}
+
+func TestAdminServicesHandler_ConfigSource(t *testing.T) {
+	setTestServices(t, []string{"api"}, nil)
+
+	rec := httptest.NewRecorder()
+	adminServicesHandler(&ServerConfig{ConfigSource: configSourceKubernetes})(rec, httptest.NewRequest(http.MethodGet, "/admin/services", nil))
+
+	var resp adminServicesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(resp.Services) != 1 || resp.Services[0].Source != configSourceKubernetes {
+		t.Errorf("expected api from the kubernetes source, got %+v", resp.Services)
+	}
+}
+
+func TestRecordStatusChanges(t *testing.T) {
+	setTestServices(t, nil, nil)
+
+	t0 := time.Unix(1000, 0)
+	recordStatusChanges(nil, &Config{UpServices: []string{"api", "db"}}, t0)
+
+	// Only services that changed status get a new timestamp
+	t1 := t0.Add(time.Minute)
+	recordStatusChanges(&Config{UpServices: []string{"api", "db"}},
+		&Config{UpServices: []string{"api"}, DownServices: []string{"db", "cache"}}, t1)
+
+	if got := serviceChangedAt["api"]; !got.Equal(t0) {
+		t.Errorf("expected api unchanged at %v, got %v", t0, got)
+	}
+	if got := serviceChangedAt["db"]; !got.Equal(t1) {
+		t.Errorf("expected db changed at %v, got %v", t1, got)
+	}
+	if got := serviceChangedAt["cache"]; !got.Equal(t1) {
+		t.Errorf("expected cache added at %v, got %v", t1, got)
+	}
+
+	recordStatusChanges(&Config{UpServices: []string{"api"}}, &Config{}, t1)
+	if len(serviceChangedAt) != 0 {
+		t.Errorf("expected removed services to be dropped, got %v", serviceChangedAt)
+	}
+}
+
+func TestAdminServices_BasicAuth(t *testing.T) {
+	setTestServices(t, []string{"api"}, nil)
+
+	cfg := &ServerConfig{AdminUsername: "admin", AdminPassword: "secret"}
+	mux := NewAppServeMux(newTestMetrics(), cfg)
+
+	tests := []struct {
+		name       string
+		user, pass string
+		setAuth    bool
+		want       int
+	}{
+		{"no credentials", "", "", false, http.StatusUnauthorized},
+		{"wrong password", "admin", "wrong", true, http.StatusUnauthorized},
+		{"wrong user", "root", "secret", true, http.StatusUnauthorized},
+		{"valid", "admin", "secret", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/admin/services", nil)
+		if tt.setAuth {
+			req.SetBasicAuth(tt.user, tt.pass)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, rec.Code)
+		}
+		if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate challenge", tt.name)
+		}
+	}
+
+	// Without credentials configured the path falls through to the root handler
+	noSleep(t)
+	rec := httptest.NewRecorder()
+	NewAppServeMux(newTestMetrics(), &ServerConfig{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/services", nil))
+	if rec.Header().Get("Content-Type") == "application/json" {
+		t.Error("expected /admin/services to be disabled without credentials")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// serviceAggregateCollector rolls the per-service status gauges up into
+// up and down counts, computed from the live GaugeVec on every collection
+type serviceAggregateCollector struct {
+	status   *prometheus.GaugeVec
+	upDesc   *prometheus.Desc
+	downDesc *prometheus.Desc
+}
+
+func newServiceAggregateCollector(status *prometheus.GaugeVec) *serviceAggregateCollector {
+	return &serviceAggregateCollector{
+		status: status,
+		upDesc: prometheus.NewDesc(
+			"service_monitor_services_up_count",
+			"Number of monitored services currently up",
+			nil, nil,
+		),
+		downDesc: prometheus.NewDesc(
+			"service_monitor_services_down_count",
+			"Number of monitored services currently down",
+			nil, nil,
+		),
+	}
+}
+
+func (c *serviceAggregateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.upDesc
+	ch <- c.downDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *serviceAggregateCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.status.Collect(metrics)
+		close(metrics)
+	}()
+
+	var up, down int
+	for metric := range metrics {
+		var d dto.Metric
+		if err := metric.Write(&d); err != nil {
+			continue
+		}
+		if d.GetGauge().GetValue() == 1 {
+			up++
+		} else {
+			down++
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, float64(up))
+	ch <- prometheus.MustNewConstMetric(c.downDesc, prometheus.GaugeValue, float64(down))
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestServiceAggregateCollector(t *testing.T) {
+	m := newTestMetrics()
+	updateServiceMetrics(m, &Config{
+		UpServices:   []string{"api-gateway", "auth-service", "user-service"},
+		DownServices: []string{"payment-service", "notification-service"},
+	})
+
+	if got := gaugeValue(t, m.Registry, "service_monitor_services_up_count"); got != 3 {
+		t.Errorf("expected 3 services up, got %v", got)
+	}
+	if got := gaugeValue(t, m.Registry, "service_monitor_services_down_count"); got != 2 {
+		t.Errorf("expected 2 services down, got %v", got)
+	}
+
+	// The counts follow the live gauges
+	m.ServiceStatus.WithLabelValues("payment-service").Set(1)
+	if got := gaugeValue(t, m.Registry, "service_monitor_services_up_count"); got != 4 {
+		t.Errorf("expected 4 services up after a change, got %v", got)
+	}
+	if got := gaugeValue(t, m.Registry, "service_monitor_services_down_count"); got != 1 {
+		t.Errorf("expected 1 service down after a change, got %v", got)
+	}
+}
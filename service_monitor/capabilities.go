@@ -0,0 +1,51 @@
+package main
+
+import "net/http"
+
+// capabilities reports which optional features are enabled, so clients can
+// tell a disabled endpoint from a missing one without probing for 404s
+func capabilities(cfg *ServerConfig) map[string]bool {
+	return map[string]bool{
+		// /admin/services and /probe
+		"admin": cfg.AdminUsername != "" && cfg.AdminPassword != "",
+		// /metrics/reset
+		"metrics_reset": cfg.EnableMetricsReset,
+		// /metrics/snapshot
+		"metrics_snapshot": cfg.SnapshotBaseDir != "",
+		// Availability in /status and /services/<name>/probe-history
+		"probing": cfg.SLOTracker != nil,
+		// /config/history is always served; this reports whether it is
+		// persisted to CONFIG_HISTORY_PATH and survives restarts
+		"config_history": cfg.ConfigHistory.persisted(),
+		"event_log":      cfg.EventLog != nil,
+		"state_export":   cfg.StateExportPath != "",
+		"statsd":         cfg.StatsD != nil,
+		// /mock/{service}/up and /mock/{service}/down
+		"mock_server": cfg.MockServer,
+	}
+}
+
+// recordCapabilities exposes the capabilities as one time series per feature,
+// so alerting rules can catch a feature disabled by mistake
+// Call it once cfg is fully set up; features don't change while running
+func recordCapabilities(m *Metrics, cfg *ServerConfig) {
+	for feature, enabled := range capabilities(cfg) {
+		value := 0.0
+		if enabled {
+			value = 1
+		}
+		m.FeatureEnabled.WithLabelValues(feature).Set(value)
+	}
+}
+
+// capabilitiesHandler lists the optional features of this instance
+func capabilitiesHandler(cfg *ServerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, capabilities(cfg))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCapabilitiesHandler(t *testing.T) {
+	cfg := &ServerConfig{
+		AdminUsername:   "admin",
+		AdminPassword:   "secret",
+		SnapshotBaseDir: t.TempDir(),
+		SLOTracker:      NewSLOTracker(10, 10),
+		ConfigHistory:   NewConfigHistory(defaultConfigHistoryDepth),
+	}
+	mux := NewAppServeMux(newTestMetrics(), cfg)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var got map[string]bool
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	want := map[string]bool{
+		"admin":            true,
+		"metrics_reset":    false,
+		"metrics_snapshot": true,
+		"probing":          true,
+		"config_history":   false,
+		"event_log":        false,
+		"state_export":     false,
+		"statsd":           false,
+		"mock_server":      false,
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d capabilities, got %v", len(want), got)
+	}
+	for name, enabled := range want {
+		if value, ok := got[name]; !ok || value != enabled {
+			t.Errorf("expected %s=%v, got %v (present: %v)", name, enabled, value, ok)
+		}
+	}
+
+	// Only a history persisted to a file counts
+	history, err := openConfigHistory(filepath.Join(t.TempDir(), "history.jsonl"), defaultConfigHistoryDepth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+	cfg.ConfigHistory = history
+	if !capabilities(cfg)["config_history"] {
+		t.Error("expected config_history with a persisted history")
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/capabilities", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("expected 405 with Allow: GET, got %d", rec.Code)
+	}
+}
+
+func TestRecordCapabilities(t *testing.T) {
+	m := newTestMetrics()
+	cfg := &ServerConfig{EnableMetricsReset: true}
+	recordCapabilities(m, cfg)
+
+	want := capabilities(cfg)
+	if got := testutil.CollectAndCount(m.FeatureEnabled); got != len(want) {
+		t.Errorf("expected one series per feature, got %d", got)
+	}
+	for feature, enabled := range want {
+		value := 0.0
+		if enabled {
+			value = 1
+		}
+		if got := testutil.ToFloat64(m.FeatureEnabled.WithLabelValues(feature)); got != value {
+			t.Errorf("expected %s=%v, got %v", feature, value, got)
+		}
+	}
+	if got := testutil.ToFloat64(m.FeatureEnabled.WithLabelValues("metrics_reset")); got != 1 {
+		t.Errorf("expected metrics_reset to be enabled, got %v", got)
+	}
+}
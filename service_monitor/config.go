@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Configuration structure matching the TOML file
+type Config struct {
+	UpServices   []string `toml:"up_services"`
+	DownServices []string `toml:"down_services"`
+
+	// Upstream dependencies of each service, keyed by service name
+	Dependencies map[string][]string `toml:"dependencies"`
+
+	// Static labels of each service's info metric, keyed by service name
+	ServiceLabels map[string]map[string]string `toml:"service_labels"`
+
+	SLO        SLOConfig        `toml:"slo"`
+	Simulation SimulationConfig `toml:"simulation"`
+
+	// Bucket layout of the request duration histogram, read at startup
+	HistogramConfig
+
+	// URLs that must answer 2xx for /health to pass
+	HealthChecks HealthChecksConfig `toml:"health_checks"`
+
+	// Endpoints checked by /probe, keyed by service name
+	Probes map[string]ProbeTarget `toml:"probes"`
+
+	// Extra headers added to every HTTP response, e.g. security headers
+	HTTPHeaders map[string]string `toml:"http_headers"`
+
+	// Aliases for metric label names in the exposition, e.g. service = "job_name"
+	LabelAliases map[string]string `toml:"label_aliases"`
+
+	// Number of applied configs kept for /config/history, read at startup
+	ConfigHistoryDepth int `toml:"config_history_depth"`
+
+	// Size and line count of the file the config was loaded from
+	fileSize  int
+	fileLines int
+}
+
+var (
+	// Last modification time
+	lastModTime time.Time
+
+	// Path the config file resolved to when it was last loaded, which changes
+	// when Kubernetes swaps the ..data symlink of a ConfigMap volume
+	lastConfigTarget string
+
+	// ETag of the config most recently applied, read without locking
+	lastConfigETag atomic.Value
+
+	// Config most recently applied to the metrics, never modified once stored
+	// Readers load it without locking; writers store it under configMutex
+	currentConfig atomic.Pointer[Config]
+
+	// Number of configs applied so far
+	configGeneration uint64
+
+	// Mutex serializing config updates and the state derived from them
+	configMutex = NewMeteredRWMutex("config")
+)
+
+// configHistoryDepth returns the number of applied configs to keep
+func (c *Config) configHistoryDepth() int {
+	if c.ConfigHistoryDepth <= 0 {
+		return defaultConfigHistoryDepth
+	}
+	return c.ConfigHistoryDepth
+}
+
+// loadCurrentConfig returns the config most recently applied, or nil before the first load
+func loadCurrentConfig() *Config {
+	return currentConfig.Load()
+}
+
+// clone returns a deep copy of c that shares no slices or maps with it
+func (c *Config) clone() *Config {
+	copied := *c
+	copied.UpServices = slices.Clone(c.UpServices)
+	copied.DownServices = slices.Clone(c.DownServices)
+	copied.HTTPHeaders = maps.Clone(c.HTTPHeaders)
+	if c.Dependencies != nil {
+		copied.Dependencies = make(map[string][]string, len(c.Dependencies))
+		for name, deps := range c.Dependencies {
+			copied.Dependencies[name] = slices.Clone(deps)
+		}
+	}
+	copied.HealthChecks.URLs = slices.Clone(c.HealthChecks.URLs)
+	copied.Probes = maps.Clone(c.Probes)
+	copied.LabelAliases = maps.Clone(c.LabelAliases)
+	if c.ServiceLabels != nil {
+		copied.ServiceLabels = make(map[string]map[string]string, len(c.ServiceLabels))
+		for name, labels := range c.ServiceLabels {
+			copied.ServiceLabels[name] = maps.Clone(labels)
+		}
+	}
+	return &copied
+}
+
+// loadConfigETag returns the ETag of the applied config, or "" before the first load
+func loadConfigETag() string {
+	etag, _ := lastConfigETag.Load().(string)
+	return etag
+}
+
+// computeConfigETag returns a strong ETag derived from the JSON form of config,
+// or an empty string if it can't be encoded
+func computeConfigETag(config *Config) string {
+	data, err := json.Marshal(config)
+	if err != nil {
+		log.Printf("Error encoding config for ETag: %v", err)
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// wrapConfigReader wraps the reader of the config file; replaced in tests
+var wrapConfigReader = func(r io.Reader) io.Reader { return r }
+
+// maxPooledConfigBufferSize keeps buffers of unusually large configs out of the pool
+const maxPooledConfigBufferSize = 1 << 20
+
+// configBufPool reuses the buffers config files are read into, which are
+// only needed until the TOML has been decoded
+var configBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
+// readAllInto reads r until EOF, appending to buf and growing it as needed
+func readAllInto(buf []byte, r io.Reader) ([]byte, error) {
+	for {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return buf, err
+		}
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r       io.Reader
+	counter prometheus.Counter
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if c.counter != nil {
+		c.counter.Add(float64(n))
+	}
+	return n, err
+}
+
+// invalidConfigError reports a config file that was read but can't be parsed
+// or applied, as opposed to one that couldn't be read at all
+type invalidConfigError struct {
+	err error
+}
+
+func (e *invalidConfigError) Error() string { return e.err.Error() }
+func (e *invalidConfigError) Unwrap() error { return e.err }
+
+// loadConfig reads the configuration file at path from fsys and returns the
+// Config, adding the number of bytes actually read to bytesRead unless it is nil
+// Parse and validation failures are returned as *invalidConfigError
+// It opens and closes the file for each read to ensure we get the latest content
+func loadConfig(fsys FileSystem, path string, bytesRead prometheus.Counter) (*Config, error) {
+	// Open the file explicitly so it's closed after reading
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening config file: %w", err)
+	}
+	defer file.Close()
+
+	// Read the file content into a pooled buffer
+	bufPtr := configBufPool.Get().(*[]byte)
+	configData, err := readAllInto((*bufPtr)[:0], wrapConfigReader(&countingReader{r: file, counter: bytesRead}))
+	defer func() {
+		if cap(configData) <= maxPooledConfigBufferSize {
+			*bufPtr = configData[:0]
+			configBufPool.Put(bufPtr)
+		}
+	}()
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if configEncryption != nil {
+		plaintext, err := configEncryption.Decrypt(configData)
+		if err != nil {
+			return nil, &invalidConfigError{err}
+		}
+		return parseConfig(plaintext)
+	}
+	return parseConfig(configData)
+}
+
+// parseConfig parses and validates the TOML content of a config file
+// Failures are returned as *invalidConfigError
+func parseConfig(data []byte) (*Config, error) {
+	var config Config
+	if err := toml.Unmarshal(data, &config); err != nil {
+		return nil, &invalidConfigError{fmt.Errorf("error parsing config file: %w", err)}
+	}
+	if err := validateConfig(&config); err != nil {
+		return nil, &invalidConfigError{fmt.Errorf("invalid config file: %w", err)}
+	}
+	config.fileSize = len(data)
+	config.fileLines = countLines(data)
+
+	return &config, nil
+}
+
+// normalizeConfigPath cleans path and uses forward slashes on every platform,
+// so trailing slashes, doubled separators and a mix of \ and / on Windows all
+// refer to the config file the same way
+// Case is kept, since macOS volumes can be case-sensitive too
+func normalizeConfigPath(path string) string {
+	return filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))
+}
+
+// decodeBase64Config decodes a config passed in an environment variable
+// Line breaks in the encoded value, as added by base64 without -w0, are ignored
+func decodeBase64Config(encoded string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding base64 config: %w", err)
+	}
+	return data, nil
+}
+
+// writeBase64Config decodes a config passed as base64 into a temporary file,
+// so reloads and /config read it like a mounted config file
+func writeBase64Config(encoded string) (string, error) {
+	data, err := decodeBase64Config(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp("", "service_monitor-config-*.toml")
+	if err != nil {
+		return "", fmt.Errorf("error creating config file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error writing config file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error writing config file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// validateConfig checks the parsed config for values that can't be applied
+func validateConfig(config *Config) error {
+	if problems := configProblems(config); len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// configProblems lists every reason config can't be applied
+func configProblems(config *Config) []string {
+	var problems []string
+
+	up := make(map[string]bool, len(config.UpServices))
+	for _, name := range config.UpServices {
+		up[name] = true
+	}
+	for _, name := range append(append([]string{}, config.UpServices...), config.DownServices...) {
+		if strings.TrimSpace(name) == "" {
+			problems = append(problems, "empty service name")
+			break
+		}
+	}
+	for _, list := range []struct {
+		key      string
+		services []string
+	}{{"up_services", config.UpServices}, {"down_services", config.DownServices}} {
+		seen := make(map[string]int, len(list.services))
+		for _, name := range list.services {
+			if seen[name]++; seen[name] == 2 {
+				problems = append(problems, fmt.Sprintf("service %q is listed more than once in %s", name, list.key))
+			}
+		}
+	}
+	for _, name := range config.DownServices {
+		if up[name] {
+			problems = append(problems, fmt.Sprintf("service %q is listed as both up and down", name))
+		}
+	}
+
+	problems = append(problems, config.HistogramConfig.problems()...)
+	problems = append(problems, probeTargetProblems(config.Probes)...)
+	problems = append(problems, config.HealthChecks.problems()...)
+	problems = append(problems, labelAliasProblems(config.LabelAliases)...)
+	problems = append(problems, serviceLabelProblems(config.ServiceLabels)...)
+
+	names := make([]string, 0, len(config.HTTPHeaders))
+	for name := range config.HTTPHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !isValidHeaderName(name) {
+			problems = append(problems, fmt.Sprintf("invalid HTTP header name %q", name))
+		}
+	}
+
+	return problems
+}
+
+// isValidHeaderName reports whether name is a non-empty RFC 7230 token
+func isValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// countLines returns the number of lines in data, counting a final line
+// without a trailing newline
+func countLines(data []byte) int {
+	lines := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
+
+// Trigger label values for config load duration
+const (
+	loadTriggerWatch  = "watch"
+	loadTriggerManual = "manual"
+
+	// loadTriggerStartup labels the initial load
+	loadTriggerStartup = "startup"
+
+	// loadTriggerSignal labels reloads requested with SIGHUP
+	loadTriggerSignal = "signal"
+
+	// loadTriggerRemote labels configs fetched from CONFIG_URL
+	loadTriggerRemote = "remote"
+
+	// loadTriggerInline labels changes of CONFIG_INLINE picked up by polling
+	loadTriggerInline = "inline"
+)
+
+// timedLoadConfig calls loadConfig and records how long it took and whether
+// it leaked file descriptors
+func timedLoadConfig(m *Metrics, fsys FileSystem, path, trigger string) (*Config, error) {
+	var config *Config
+	var err error
+	var elapsed time.Duration
+	detectFDLeak(m, func() {
+		start := time.Now()
+		config, err = loadConfig(fsys, path, m.ConfigBytesRead)
+		elapsed = time.Since(start)
+	})
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.ConfigLoadDuration.WithLabelValues(trigger, result).Observe(elapsed.Seconds())
+
+	return config, err
+}
+
+// configCheckInterval is how often watchConfig checks the config file; replaced in tests
+var configCheckInterval = 3 * time.Second
+
+// watchConfig monitors the config file for changes and reloads it until ctx is cancelled
+// The file is opened and closed on each check to ensure we detect changes
+// A missing or unreadable file is logged and checked again on the next poll
+func watchConfig(ctx context.Context, m *Metrics, cfg *ServerConfig) {
+	log.Printf("Starting config watcher for file: %s", cfg.ConfigPath)
+
+	for {
+		m.ConfigWatcherHeartbeat.SetToCurrentTime()
+		cfg.awaitReload(ctx, loadTriggerWatch, func() (*Config, error) {
+			checkConfigFile(m, cfg)
+			return nil, nil
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(configCheckInterval):
+		}
+	}
+}
+
+// statConfigFile returns the modification time of the config file and the
+// path it resolves to through any symlinks
+// A ConfigMap update replaces the ..data symlink, which may keep the mtime
+func statConfigFile(fsys FileSystem, path string) (time.Time, string, error) {
+	fileInfo, err := fsys.Stat(path)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	target, err := fsys.EvalSymlinks(path)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return fileInfo.ModTime(), target, nil
+}
+
+// checkConfigFile reloads the config file if it changed since the last load
+func checkConfigFile(m *Metrics, cfg *ServerConfig) {
+	modTime, target, err := statConfigFile(cfg.fileSystem(), cfg.ConfigPath)
+	if err != nil {
+		log.Printf("Error checking config file: %v", err)
+		return
+	}
+
+	configMutex.RLock()
+	unchanged := modTime == lastModTime && target == lastConfigTarget
+	retargeted := target != lastConfigTarget
+	configMutex.RUnlock()
+	if unchanged {
+		return
+	}
+	if retargeted {
+		log.Printf("Config file now resolves to %s, reloading...", target)
+	} else {
+		log.Println("Config file changed, reloading...")
+	}
+
+	config, err := timedLoadConfig(m, cfg.fileSystem(), cfg.ConfigPath, loadTriggerWatch)
+	if err != nil {
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	configMutex.Lock()
+	applyConfig(m, cfg, config, loadTriggerWatch)
+	lastModTime, lastConfigTarget = modTime, target
+	configMutex.Unlock()
+	log.Printf("Reloaded config: %d up services and %d down services",
+		len(config.UpServices), len(config.DownServices))
+}
+
+// reloadConfig loads the config file and applies it immediately, without
+// waiting for the watcher to notice a change
+func reloadConfig(m *Metrics, cfg *ServerConfig, trigger string) (*Config, error) {
+	config, err := timedLoadConfig(m, cfg.fileSystem(), cfg.ConfigPath, trigger)
+	if err != nil {
+		return nil, err
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	applyConfig(m, cfg, config, trigger)
+	if modTime, target, err := statConfigFile(cfg.fileSystem(), cfg.ConfigPath); err == nil {
+		lastModTime, lastConfigTarget = modTime, target
+	}
+	return config, nil
+}
+
+// configSourceDescriptions explain where the config comes from when it
+// isn't read from the config file
+var configSourceDescriptions = map[string]string{
+	configSourceKubernetes: "Kubernetes discovery, which refreshes the services every 30 seconds",
+	configSourceInline:     "CONFIG_INLINE, which is part of the process environment; restart the service to change it",
+}
+
+// notReloadableError is returned when a reload is requested for a config
+// source that applies its changes by itself
+type notReloadableError struct {
+	source string
+}
+
+func (e *notReloadableError) Error() string {
+	description, ok := configSourceDescriptions[e.source]
+	if !ok {
+		description = e.source
+	}
+	return fmt.Sprintf("the config comes from %s and can't be reloaded from the config file", description)
+}
+
+// reloadFromSource reloads the config from where it comes from: the config
+// file is read again and a config server is fetched from again
+func reloadFromSource(ctx context.Context, m *Metrics, cfg *ServerConfig, trigger string) (*Config, error) {
+	switch {
+	case cfg.fromConfigFile():
+		return reloadConfig(m, cfg, trigger)
+	case cfg.ConfigSource == configSourceRemote && cfg.RemoteConfig != nil:
+		return cfg.RemoteConfig.fetchAndApply(ctx, m, cfg, trigger)
+	default:
+		return nil, &notReloadableError{source: cfg.ConfigSource}
+	}
+}
+
+// applyConfig makes config the active configuration for metrics and handlers
+// The caller must hold configMutex for writing when other goroutines are running
+func applyConfig(m *Metrics, cfg *ServerConfig, config *Config, trigger string) {
+	prev := loadCurrentConfig()
+	configGeneration++
+	lastConfigETag.Store(computeConfigETag(config))
+
+	updateServiceMetrics(m, config)
+	updateConfigFileMetrics(m, config)
+	cfg.requestLimiter().resize(config.Simulation.maxConcurrentRequests())
+	cfg.loadAverage().SetAlpha(config.Simulation.emaAlpha(cfg.defaultEMAAlpha()))
+	cfg.setResponseHeaders(config.HTTPHeaders)
+	m.Exposition.SetAliases(config.LabelAliases)
+
+	if cfg.ConfigHistory != nil {
+		cfg.ConfigHistory.record(config, configGeneration, trigger, time.Now())
+	}
+
+	if cfg.EventLog != nil {
+		cfg.EventLog.write(m, statusEvents(prev, config, trigger, configGeneration, time.Now()))
+	}
+
+	if exporter := cfg.stateExporter(); exporter != nil {
+		exporter.update(m, buildExportedState(config, cfg.serviceSource(), time.Now()))
+	}
+
+	if cfg.StatsD != nil {
+		cfg.StatsD.sendStatus(m, config)
+	}
+}
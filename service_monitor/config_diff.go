@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// configRequest is the JSON form of a proposed config
+type configRequest struct {
+	UpServices   []string `json:"up_services"`
+	DownServices []string `json:"down_services"`
+}
+
+// configDiff describes how the services of one config differ from another
+type configDiff struct {
+	AddedUp          []string `json:"added_up"`
+	RemovedUp        []string `json:"removed_up"`
+	AddedDown        []string `json:"added_down"`
+	RemovedDown      []string `json:"removed_down"`
+	MovedToUp        []string `json:"moved_to_up"`
+	MovedToDown      []string `json:"moved_to_down"`
+	ValidationErrors []string `json:"validation_errors"`
+}
+
+// changed reports whether any service was added, removed or moved
+func (d configDiff) changed() bool {
+	return len(d.AddedUp)+len(d.RemovedUp)+len(d.AddedDown)+len(d.RemovedDown)+
+		len(d.MovedToUp)+len(d.MovedToDown) > 0
+}
+
+// diffConfig compares the services of prev and next; services that change
+// status are reported as moved rather than added and removed
+func diffConfig(prev, next *Config) configDiff {
+	before := serviceStatuses(prev)
+	after := serviceStatuses(next)
+
+	diff := configDiff{
+		AddedUp:          []string{},
+		RemovedUp:        []string{},
+		AddedDown:        []string{},
+		RemovedDown:      []string{},
+		MovedToUp:        []string{},
+		MovedToDown:      []string{},
+		ValidationErrors: []string{},
+	}
+
+	for name, up := range after {
+		wasUp, existed := before[name]
+		switch {
+		case !existed && up:
+			diff.AddedUp = append(diff.AddedUp, name)
+		case !existed:
+			diff.AddedDown = append(diff.AddedDown, name)
+		case up && !wasUp:
+			diff.MovedToUp = append(diff.MovedToUp, name)
+		case !up && wasUp:
+			diff.MovedToDown = append(diff.MovedToDown, name)
+		}
+	}
+	for name, wasUp := range before {
+		if _, exists := after[name]; exists {
+			continue
+		}
+		if wasUp {
+			diff.RemovedUp = append(diff.RemovedUp, name)
+		} else {
+			diff.RemovedDown = append(diff.RemovedDown, name)
+		}
+	}
+
+	for _, list := range [][]string{diff.AddedUp, diff.RemovedUp, diff.AddedDown, diff.RemovedDown, diff.MovedToUp, diff.MovedToDown} {
+		sort.Strings(list)
+	}
+	return diff
+}
+
+// configDiffHandler previews how a proposed config would change the live one
+// without applying it
+func configDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req configRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	proposed := &Config{UpServices: req.UpServices, DownServices: req.DownServices}
+
+	// Only snapshot the live config; applied configs are never modified
+	current := loadCurrentConfig()
+
+	diff := diffConfig(current, proposed)
+	if problems := configProblems(proposed); len(problems) > 0 {
+		diff.ValidationErrors = problems
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDiffConfig(t *testing.T) {
+	prev := &Config{UpServices: []string{"api", "auth", "cache"}, DownServices: []string{"db", "queue"}}
+	next := &Config{UpServices: []string{"api", "db", "search"}, DownServices: []string{"auth", "mail"}}
+
+	diff := diffConfig(prev, next)
+
+	tests := []struct {
+		field     string
+		got, want []string
+	}{
+		{"added_up", diff.AddedUp, []string{"search"}},
+		{"removed_up", diff.RemovedUp, []string{"cache"}},
+		{"added_down", diff.AddedDown, []string{"mail"}},
+		{"removed_down", diff.RemovedDown, []string{"queue"}},
+		{"moved_to_up", diff.MovedToUp, []string{"db"}},
+		{"moved_to_down", diff.MovedToDown, []string{"auth"}},
+	}
+	for _, tt := range tests {
+		if fmt.Sprint(tt.got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.field, tt.want, tt.got)
+		}
+	}
+}
+
+func TestConfigDiffHandler(t *testing.T) {
+	setTestServices(t, []string{"api", "auth"}, []string{"db"})
+	noSleep(t)
+
+	m := newTestMetrics()
+	updateServiceMetrics(m, loadCurrentConfig())
+	mux := NewAppServeMux(m, &ServerConfig{AdminUsername: "admin", AdminPassword: "secret"})
+
+	body := `{"up_services":["api","db"],"down_services":["cache"]}`
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/config/diff", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var diff configDiff
+	if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if fmt.Sprint(diff.RemovedUp) != "[auth]" || fmt.Sprint(diff.MovedToUp) != "[db]" ||
+		fmt.Sprint(diff.AddedDown) != "[cache]" || len(diff.ValidationErrors) != 0 {
+		t.Errorf("unexpected diff: %+v", diff)
+	}
+
+	// The live config is unchanged
+	req := httptest.NewRequest(http.MethodGet, "/admin/services", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	var services adminServicesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &services); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	got := make(map[string]string)
+	for _, svc := range services.Services {
+		got[svc.Name] = svc.Status
+	}
+	if fmt.Sprint(got) != fmt.Sprint(map[string]string{"api": "up", "auth": "up", "db": "down"}) {
+		t.Errorf("expected the old services after a diff, got %v", got)
+	}
+	if v := gaugeValue(t, m.Registry, "service_monitor_services_down_count"); v != 1 {
+		t.Errorf("expected metrics unchanged, got %v services down", v)
+	}
+}
+
+func TestConfigDiffHandler_ValidationErrors(t *testing.T) {
+	setTestServices(t, []string{"api"}, nil)
+
+	body := `{"up_services":["api",""],"down_services":["api"]}`
+	rec := httptest.NewRecorder()
+	configDiffHandler(rec, httptest.NewRequest(http.MethodPost, "/config/diff", strings.NewReader(body)))
+
+	var diff configDiff
+	if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(diff.ValidationErrors) != 2 {
+		t.Errorf("expected 2 validation errors, got %v", diff.ValidationErrors)
+	}
+
+	rec = httptest.NewRecorder()
+	configDiffHandler(rec, httptest.NewRequest(http.MethodGet, "/config/diff", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET, got %d", rec.Code)
+	}
+}
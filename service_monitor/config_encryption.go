@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+)
+
+// Formats CONFIG_ENCRYPTION_FORMAT accepts
+const (
+	configEncryptionAESGCM = "aes-gcm"
+	configEncryptionAge    = "age"
+)
+
+// configCipher encrypts and decrypts config files stored at rest
+type configCipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// configEncryption decrypts the config file before it is parsed; nil when
+// CONFIG_ENCRYPTION_KEY is unset and the file is plaintext
+var configEncryption configCipher
+
+// newConfigCipher returns the cipher for format, "aes-gcm" (the default) with
+// a hex-encoded 32-byte key or "age" with an AGE-SECRET-KEY-1... identity
+func newConfigCipher(format, key string) (configCipher, error) {
+	switch format {
+	case "", configEncryptionAESGCM:
+		raw, err := hex.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("AES-GCM key must be hex-encoded: %w", err)
+		}
+		return newAESGCMCipher(raw)
+	case configEncryptionAge:
+		identity, err := age.ParseX25519Identity(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identity: %w", err)
+		}
+		return ageCipher{identity: identity}, nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption format %q, must be %s or %s",
+			format, configEncryptionAESGCM, configEncryptionAge)
+	}
+}
+
+// aesGCMCipher uses AES-256-GCM with a random 12-byte nonce stored in front
+// of the ciphertext
+type aesGCMCipher struct {
+	aead cipher.AEAD
+}
+
+func newAESGCMCipher(key []byte) (aesGCMCipher, error) {
+	if len(key) != 32 {
+		return aesGCMCipher{}, fmt.Errorf("AES-256 key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return aesGCMCipher{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return aesGCMCipher{}, err
+	}
+	return aesGCMCipher{aead: aead}, nil
+}
+
+func (c aesGCMCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (c aesGCMCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, errors.New("encrypted config is shorter than the nonce")
+	}
+	nonce, sealed := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting config: %w", err)
+	}
+	return plaintext, nil
+}
+
+// ageCipher uses the binary age format, encrypting to the recipient of the
+// identity it decrypts with
+type ageCipher struct {
+	identity *age.X25519Identity
+}
+
+func (c ageCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, c.identity.Recipient())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c ageCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), c.identity)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting config: %w", err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting config: %w", err)
+	}
+	return plaintext, nil
+}
+
+// runEncryptCommand implements `service_monitor encrypt -in config.toml -out
+// config.toml.enc`, encrypting a config with CONFIG_ENCRYPTION_KEY in
+// CONFIG_ENCRYPTION_FORMAT; the config is validated first
+func runEncryptCommand(args []string, getenv func(string) string) error {
+	flags := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	in := flags.String("in", "", "plaintext config file to encrypt")
+	out := flags.String("out", "", "file to write the encrypted config to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" || *out == "" {
+		return errors.New("both -in and -out are required")
+	}
+
+	key := getenv("CONFIG_ENCRYPTION_KEY")
+	if key == "" {
+		return errors.New("CONFIG_ENCRYPTION_KEY is not set")
+	}
+	c, err := newConfigCipher(getenv("CONFIG_ENCRYPTION_FORMAT"), key)
+	if err != nil {
+		return fmt.Errorf("invalid CONFIG_ENCRYPTION_KEY: %w", err)
+	}
+
+	plaintext, err := os.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+	if _, err := parseConfig(plaintext); err != nil {
+		return fmt.Errorf("refusing to encrypt %s: %w", *in, err)
+	}
+	ciphertext, err := c.Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*out, ciphertext, 0600)
+}
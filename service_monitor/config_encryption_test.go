@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// useConfigEncryption decrypts config files with c for the rest of the test
+func useConfigEncryption(t *testing.T, c configCipher) {
+	t.Helper()
+	orig := configEncryption
+	configEncryption = c
+	t.Cleanup(func() { configEncryption = orig })
+}
+
+func newTestAESKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(key)
+}
+
+func TestConfigEncryption_RoundTrip(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		format string
+		key    string
+	}{
+		{"", newTestAESKey(t)},
+		{configEncryptionAESGCM, newTestAESKey(t)},
+		{configEncryptionAge, identity.String()},
+	}
+
+	plaintext := []byte("up_services = [\"api-gateway\", \"auth-service\"]\ndown_services = [\"payment-service\"]\n\n[slo]\nthreshold = 0.9\n")
+	want, err := parseConfig(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		in, out := filepath.Join(dir, "config.toml"), filepath.Join(dir, "config.toml.enc")
+		if err := os.WriteFile(in, plaintext, 0644); err != nil {
+			t.Fatal(err)
+		}
+		env := map[string]string{"CONFIG_ENCRYPTION_KEY": tt.key, "CONFIG_ENCRYPTION_FORMAT": tt.format}
+		if err := runEncryptCommand([]string{"-in", in, "-out", out}, func(key string) string { return env[key] }); err != nil {
+			t.Fatalf("%q: encrypt failed: %v", tt.format, err)
+		}
+
+		ciphertext, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(ciphertext, []byte("api-gateway")) {
+			t.Errorf("%q: expected no plaintext service names in the encrypted file", tt.format)
+		}
+
+		c, err := newConfigCipher(tt.format, tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		useConfigEncryption(t, c)
+		got, err := loadConfig(RealFileSystem{}, out, nil)
+		if err != nil {
+			t.Fatalf("%q: loading the encrypted config failed: %v", tt.format, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: expected %+v, got %+v", tt.format, want, got)
+		}
+	}
+}
+
+func TestAESGCMCipher_RandomNonce(t *testing.T) {
+	c, err := newConfigCipher(configEncryptionAESGCM, newTestAESKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := c.Encrypt([]byte("up_services = []"))
+	second, _ := c.Encrypt([]byte("up_services = []"))
+	if bytes.Equal(first[:12], second[:12]) || bytes.Equal(first, second) {
+		t.Error("expected a new nonce for every encryption")
+	}
+
+	// Any change to the file fails authentication
+	first[len(first)-1] ^= 1
+	if _, err := c.Decrypt(first); err == nil {
+		t.Error("expected a tampered config to fail decryption")
+	}
+	if _, err := c.Decrypt(first[:5]); err == nil {
+		t.Error("expected a truncated config to fail decryption")
+	}
+}
+
+func TestLoadConfig_WrongEncryptionKey(t *testing.T) {
+	encrypter, _ := newConfigCipher(configEncryptionAESGCM, newTestAESKey(t))
+	ciphertext, err := encrypter.Encrypt([]byte("up_services = [\"api\"]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.toml.enc")
+	if err := os.WriteFile(path, ciphertext, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := newConfigCipher(configEncryptionAESGCM, newTestAESKey(t))
+	useConfigEncryption(t, other)
+	_, err = loadConfig(RealFileSystem{}, path, nil)
+	var invalid *invalidConfigError
+	if !errors.As(err, &invalid) {
+		t.Errorf("expected an invalid config error, got %v", err)
+	}
+}
+
+func TestNewConfigCipher_Invalid(t *testing.T) {
+	tests := []struct{ format, key string }{
+		{configEncryptionAESGCM, "not hex"},
+		{configEncryptionAESGCM, hex.EncodeToString(make([]byte, 16))},
+		{configEncryptionAge, "AGE-SECRET-KEY-1INVALID"},
+		{"rot13", newTestAESKey(t)},
+	}
+	for _, tt := range tests {
+		if _, err := newConfigCipher(tt.format, tt.key); err == nil {
+			t.Errorf("%s %q: expected an error", tt.format, tt.key)
+		}
+	}
+}
+
+func TestRunEncryptCommand_Errors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.toml")
+	if err := os.WriteFile(invalid, []byte("up_services = [\"api\"]\ndown_services = [\"api\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"CONFIG_ENCRYPTION_KEY": newTestAESKey(t)}
+	getenv := func(key string) string { return env[key] }
+
+	if err := runEncryptCommand([]string{"-in", invalid}, getenv); err == nil {
+		t.Error("expected an error without -out")
+	}
+	if err := runEncryptCommand([]string{"-in", invalid, "-out", filepath.Join(dir, "out")}, getenv); err == nil {
+		t.Error("expected an invalid config to be refused")
+	}
+	if err := runEncryptCommand([]string{"-in", invalid, "-out", filepath.Join(dir, "out")}, func(string) string { return "" }); err == nil {
+		t.Error("expected an error without CONFIG_ENCRYPTION_KEY")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultConfigHistoryDepth is the number of applied configs /config/history keeps
+const defaultConfigHistoryDepth = 10
+
+// configHistoryEntry is one applied config and how it differed from the one before
+type configHistoryEntry struct {
+	Generation uint64        `json:"generation"`
+	LoadedAt   time.Time     `json:"loaded_at"`
+	Trigger    string        `json:"trigger"`
+	Config     configRequest `json:"config_snapshot"`
+	Diff       configDiff    `json:"diff_from_previous"`
+}
+
+func configHistoryEntryTime(e configHistoryEntry) time.Time { return e.LoadedAt }
+
+// ConfigHistory keeps the most recently applied configs, optionally
+// persisting them to a JSON lines file so they survive restarts
+type ConfigHistory struct {
+	mu      sync.Mutex
+	entries *RingBuffer[configHistoryEntry]
+	file    *os.File
+}
+
+// NewConfigHistory creates an in-memory history of depth configs
+func NewConfigHistory(depth int) *ConfigHistory {
+	return &ConfigHistory{entries: NewRingBuffer(depth, configHistoryEntryTime)}
+}
+
+// openConfigHistory loads the history persisted at path, if any, and appends
+// new entries to it; the file is compacted to the last depth entries first
+func openConfigHistory(path string, depth int) (*ConfigHistory, error) {
+	h := NewConfigHistory(depth)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading config history: %w", err)
+	}
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry configHistoryEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			log.Printf("Skipping invalid config history line %d: %v", i+1, err)
+			continue
+		}
+		h.entries.Push(entry)
+	}
+
+	if err := rewriteConfigHistory(path, h.entries.Slice(0)); err != nil {
+		return nil, err
+	}
+	h.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("error opening config history: %w", err)
+	}
+	return h, nil
+}
+
+// rewriteConfigHistory atomically replaces path with entries as JSON lines
+func rewriteConfigHistory(path string, entries []configHistoryEntry) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			tmp.Close()
+			return fmt.Errorf("error writing config history: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing config history: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error renaming temp file: %w", err)
+	}
+	return nil
+}
+
+// record adds an applied config, diffed against the newest entry so the chain
+// continues across restarts
+func (h *ConfigHistory) record(config *Config, generation uint64, trigger string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var prev *Config
+	if latest := h.entries.Slice(1); len(latest) == 1 {
+		prev = &Config{UpServices: latest[0].Config.UpServices, DownServices: latest[0].Config.DownServices}
+	}
+
+	entry := configHistoryEntry{
+		Generation: generation,
+		LoadedAt:   now,
+		Trigger:    trigger,
+		Config: configRequest{
+			UpServices:   slices.Clone(config.UpServices),
+			DownServices: slices.Clone(config.DownServices),
+		},
+		Diff: diffConfig(prev, config),
+	}
+	h.entries.Push(entry)
+
+	if h.file == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		_, err = h.file.Write(append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("Error persisting config history: %v", err)
+	}
+}
+
+// latest returns up to limit entries, newest first; limit <= 0 returns all
+func (h *ConfigHistory) latest(limit int) []configHistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := h.entries.Slice(limit)
+	slices.Reverse(entries)
+	return entries
+}
+
+// persisted reports whether the history is written to a file
+func (h *ConfigHistory) persisted() bool {
+	return h != nil && h.file != nil
+}
+
+// Close closes the persisted history file
+func (h *ConfigHistory) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	return h.file.Close()
+}
+
+// configHistoryResponse is the JSON body returned by /config/history
+type configHistoryResponse struct {
+	Entries []configHistoryEntry `json:"entries"`
+}
+
+// configHistoryHandler lists the most recently applied configs, newest first
+func configHistoryHandler(cfg *ServerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit, err := positiveQueryInt(r.URL.Query().Get("limit"), 0)
+		if err != nil {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+
+		if cfg.ConfigHistory == nil {
+			http.Error(w, "Config history is not available", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, http.StatusOK, configHistoryResponse{Entries: cfg.ConfigHistory.latest(limit)})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigHistory_DepthLimit(t *testing.T) {
+	h := NewConfigHistory(3)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		h.record(&Config{UpServices: []string{fmt.Sprintf("svc-%d", i)}}, uint64(i), loadTriggerWatch, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	entries := h.latest(0)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, want := range []uint64{5, 4, 3} {
+		if entries[i].Generation != want {
+			t.Errorf("entry %d: expected generation %d, got %d", i, want, entries[i].Generation)
+		}
+	}
+
+	// Each entry is diffed against the one before it
+	diff := entries[0].Diff
+	if strings.Join(diff.AddedUp, ",") != "svc-5" || strings.Join(diff.RemovedUp, ",") != "svc-4" {
+		t.Errorf("unexpected diff from previous: %+v", diff)
+	}
+
+	if got := h.latest(2); len(got) != 2 || got[1].Generation != 4 {
+		t.Errorf("expected the 2 newest entries, got %+v", got)
+	}
+}
+
+func TestConfigHistoryHandler(t *testing.T) {
+	cfg := &ServerConfig{ConfigHistory: NewConfigHistory(10)}
+	cfg.ConfigHistory.record(&Config{UpServices: []string{"api"}}, 1, loadTriggerStartup, time.Now())
+	cfg.ConfigHistory.record(&Config{DownServices: []string{"api"}}, 2, loadTriggerManual, time.Now())
+	mux := NewAppServeMux(newTestMetrics(), cfg)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config/history?limit=5", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Entries []struct {
+			Generation uint64          `json:"generation"`
+			Trigger    string          `json:"trigger"`
+			Config     configRequest   `json:"config_snapshot"`
+			Diff       json.RawMessage `json:"diff_from_previous"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(resp.Entries) != 2 || resp.Entries[0].Generation != 2 || resp.Entries[0].Trigger != loadTriggerManual {
+		t.Fatalf("unexpected entries: %+v", resp.Entries)
+	}
+
+	// The diff has the same shape as the /config/diff response
+	var diff configDiff
+	if err := json.Unmarshal(resp.Entries[0].Diff, &diff); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(diff.MovedToDown, ",") != "api" || diff.ValidationErrors == nil {
+		t.Errorf("unexpected diff: %s", resp.Entries[0].Diff)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config/history?limit=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid limit, got %d", rec.Code)
+	}
+}
+
+func TestConfigHistory_PersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config_history.jsonl")
+
+	h, err := openConfigHistory(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		h.record(&Config{UpServices: []string{fmt.Sprintf("svc-%d", i)}}, uint64(i), loadTriggerWatch, time.Now())
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening keeps the last 3 entries and compacts the file to them
+	h, err = openConfigHistory(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	entries := h.latest(0)
+	if len(entries) != 3 || entries[0].Generation != 4 || entries[2].Generation != 2 {
+		t.Fatalf("unexpected entries after restart: %+v", entries)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("expected the file to be compacted to 3 lines, got %d", lines)
+	}
+
+	// The first config after the restart is diffed against the persisted one
+	h.record(&Config{UpServices: []string{"svc-4", "svc-5"}}, 1, loadTriggerStartup, time.Now())
+	if diff := h.latest(1)[0].Diff; strings.Join(diff.AddedUp, ",") != "svc-5" || len(diff.RemovedUp) != 0 {
+		t.Errorf("expected the chain to continue across the restart, got %+v", diff)
+	}
+}
+
+func TestOpenConfigHistory_SkipsInvalidLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config_history.jsonl")
+	content := `{"generation":1,"config_snapshot":{"up_services":["api"]}}
+not json
+{"generation":2,"config_snapshot":{"down_services":["api"]}}
+`
+	if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := openConfigHistory(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if entries := h.latest(0); len(entries) != 2 || entries[0].Generation != 2 {
+		t.Errorf("expected the 2 valid entries, got %+v", entries)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+// configPathTests are the normalizations expected on Linux and macOS, where
+// \ is an ordinary file name character and case is kept
+var configPathTests = []struct {
+	path, want string
+}{
+	{"/app/config/config.toml", "/app/config/config.toml"},
+	{"/app/config/config.toml/", "/app/config/config.toml"},
+	{"/app//config/./config.toml", "/app/config/config.toml"},
+	{"/app/config/../config/config.toml", "/app/config/config.toml"},
+	{"config/config.toml", "config/config.toml"},
+	{"./config.toml", "config.toml"},
+	{`/app/config\config.toml`, `/app/config\config.toml`},
+	{"/App/Config/Config.TOML", "/App/Config/Config.TOML"},
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+// configPathTests are the normalizations expected on Windows, where \ and /
+// are both separators
+var configPathTests = []struct {
+	path, want string
+}{
+	{`C:\app\config\config.toml`, "C:/app/config/config.toml"},
+	{`C:\app\config\config.toml\`, "C:/app/config/config.toml"},
+	{`C:/app\config/config.toml`, "C:/app/config/config.toml"},
+	{`C:\app\\config\.\config.toml`, "C:/app/config/config.toml"},
+	{`config\config.toml`, "config/config.toml"},
+	{`\\server\share\config.toml`, "//server/share/config.toml"},
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+)
+
+// configSchemaVersion is reported as schema_version by /config/schema
+// Increment it with every change to configSchema
+const configSchemaVersion = 2
+
+// Patterns shared by the schema properties
+const (
+	// Label names accepted by isValidLabelName
+	labelNamePattern = "^(?!__)[a-zA-Z_][a-zA-Z0-9_]*$"
+
+	// Header names accepted by isValidHeaderName
+	headerNamePattern = "^[!#$%&'*+.^_`|~0-9A-Za-z-]+$"
+)
+
+// serviceListSchema describes up_services and down_services
+var serviceListSchema = map[string]any{
+	"type": "array",
+	"items": map[string]any{
+		"type":        "string",
+		"description": "Service name, must not be blank",
+		"pattern":     `\S`,
+	},
+	"default": []string{},
+}
+
+// configSchema is the JSON Schema (draft 7) of the config file, written by
+// hand so it only promises what configProblems and the defaults implement
+// Values that fall back to the default when out of range are documented in
+// the descriptions rather than rejected by constraints
+var configSchema = map[string]any{
+	"$schema":        "http://json-schema.org/draft-07/schema#",
+	"$id":            "https://github.com/curusarn/prometheus-playground/service_monitor/config.schema.json",
+	"title":          "Service monitor config",
+	"description":    "The TOML config file read from CONFIG_PATH; a service can't be listed as both up and down",
+	"schema_version": configSchemaVersion,
+	"type":           "object",
+	"properties": map[string]any{
+		"up_services":   withDescription(serviceListSchema, "Services reported as up"),
+		"down_services": withDescription(serviceListSchema, "Services reported as down"),
+		"dependencies": map[string]any{
+			"type":        "object",
+			"description": "Upstream dependencies of each service, keyed by service name",
+			"additionalProperties": map[string]any{
+				"type":  "array",
+				"items": map[string]any{"type": "string"},
+			},
+		},
+		"service_labels": map[string]any{
+			"type":        "object",
+			"description": "Static labels of each service's info metric, keyed by service name",
+			"additionalProperties": map[string]any{
+				"type": "object",
+				"propertyNames": map[string]any{
+					"pattern": labelNamePattern,
+					"not":     map[string]any{"const": "service"},
+				},
+				"additionalProperties": map[string]any{"type": "string"},
+			},
+		},
+		"slo": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"window_minutes": map[string]any{
+					"type":        "integer",
+					"description": "Length of the availability window; values below 1 use the default",
+					"default":     defaultSLOWindowMinutes,
+				},
+				"probe_interval_seconds": map[string]any{
+					"type":        "integer",
+					"description": "Time between availability probes; values below 1 use the default",
+					"default":     defaultSLOProbeIntervalSeconds,
+				},
+				"threshold": map[string]any{
+					"type":        "number",
+					"description": "Fraction of services that must be up for /status to report the SLO as met; values outside (0, 1] use the default",
+					"default":     defaultSLOThreshold,
+				},
+				"history_depth": map[string]any{
+					"type":        "integer",
+					"description": "Number of probe results kept per service for /history; values below 1 use the default",
+					"default":     defaultHistoryDepth,
+				},
+			},
+		},
+		"simulation": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"max_concurrent_requests": map[string]any{
+					"type":        "integer",
+					"description": "Limit of concurrently simulated requests; values below 1 use the default",
+					"default":     defaultMaxConcurrentRequests,
+				},
+				"ema_alpha": map[string]any{
+					"type":        "number",
+					"description": "Smoothing factor of the simulated active requests gauge; values outside (0, 1] use ACTIVE_REQUESTS_EMA_ALPHA or the default",
+					"default":     defaultEMAAlpha,
+				},
+			},
+		},
+		"histogram_schema": map[string]any{
+			"type":        "string",
+			"description": "Bucket layout of the request duration histogram, read at startup",
+			"enum":        []string{histogramSchemaClassic, histogramSchemaNative, histogramSchemaBoth},
+			"default":     histogramSchemaClassic,
+		},
+		"native_histogram_bucket_factor": map[string]any{
+			"type":        "number",
+			"description": "Upper bound on the growth factor between native buckets; values up to 1 use the default",
+			"default":     defaultNativeHistogramBucketFactor,
+		},
+		"health_checks": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"urls": map[string]any{
+					"type":        "array",
+					"description": "URLs that must answer 2xx for /health to pass",
+					"items": map[string]any{
+						"type":    "string",
+						"format":  "uri",
+						"pattern": "^https?://[^/?#]",
+					},
+				},
+			},
+		},
+		"probes": map[string]any{
+			"type":        "object",
+			"description": "Endpoints checked by /probe, keyed by service name",
+			"additionalProperties": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"url": map[string]any{
+						"type":        "string",
+						"description": "Up when a GET returns a status below 400",
+						"format":      "uri",
+						"pattern":     "^https?://[^/?#]",
+					},
+					"tcp_address": map[string]any{
+						"type":        "string",
+						"description": "Up when a TCP connection can be established, as host:port",
+						"minLength":   1,
+					},
+					"timeout_ms": map[string]any{
+						"type":        "integer",
+						"description": "Time a probe may take, in milliseconds; values below 1 use the default",
+						"default":     defaultProbeTimeout.Milliseconds(),
+					},
+				},
+				"oneOf": []any{
+					map[string]any{"required": []string{"url"}},
+					map[string]any{"required": []string{"tcp_address"}},
+				},
+			},
+		},
+		"http_headers": map[string]any{
+			"type":                 "object",
+			"description":          "Extra headers added to every HTTP response",
+			"propertyNames":        map[string]any{"pattern": headerNamePattern},
+			"additionalProperties": map[string]any{"type": "string"},
+		},
+		"label_aliases": map[string]any{
+			"type":                 "object",
+			"description":          "Aliases for metric label names in the exposition; aliases must be unique and not aliased themselves",
+			"propertyNames":        map[string]any{"pattern": labelNamePattern},
+			"additionalProperties": map[string]any{"type": "string", "pattern": labelNamePattern},
+		},
+		"config_history_depth": map[string]any{
+			"type":        "integer",
+			"description": "Number of applied configs kept for /config/history, read at startup; values below 1 use the default",
+			"default":     defaultConfigHistoryDepth,
+		},
+	},
+}
+
+// withDescription returns a copy of schema with the description set
+func withDescription(schema map[string]any, description string) map[string]any {
+	copied := maps.Clone(schema)
+	copied["description"] = description
+	return copied
+}
+
+// configSchemaHandler serves the JSON Schema of the config file for editors
+func configSchemaHandler() http.HandlerFunc {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(configSchema); err != nil {
+		panic(fmt.Sprintf("error encoding config schema: %v", err))
+	}
+	body := buf.Bytes()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigSchemaHandler(t *testing.T) {
+	mux := NewAppServeMux(newTestMetrics(), &ServerConfig{})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config/schema", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/schema+json" {
+		t.Errorf("expected application/schema+json, got %q", got)
+	}
+
+	golden := filepath.Join("testdata", "config_schema.json")
+	if *update {
+		if err := os.WriteFile(golden, rec.Body.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("error reading golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), want) {
+		t.Errorf("/config/schema changed, bump configSchemaVersion and run with -update if intended\ngot:\n%s", rec.Body.String())
+	}
+
+	var schema struct {
+		Schema        string `json:"$schema"`
+		SchemaVersion int    `json:"schema_version"`
+		Type          string `json:"type"`
+		Properties    map[string]struct {
+			Type  string `json:"type"`
+			Items struct {
+				Type    string `json:"type"`
+				Pattern string `json:"pattern"`
+			} `json:"items"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &schema); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if schema.Schema != "http://json-schema.org/draft-07/schema#" || schema.Type != "object" {
+		t.Errorf("expected a draft 7 object schema, got %q of type %q", schema.Schema, schema.Type)
+	}
+	if schema.SchemaVersion != configSchemaVersion {
+		t.Errorf("expected schema_version %d, got %d", configSchemaVersion, schema.SchemaVersion)
+	}
+	up := schema.Properties["up_services"]
+	if up.Type != "array" || up.Items.Type != "string" || up.Items.Pattern == "" {
+		t.Errorf("expected up_services to be an array of non-blank strings, got %+v", up)
+	}
+}
+
+func TestConfigSchema_CoversConfig(t *testing.T) {
+	properties := configSchema["properties"].(map[string]any)
+	var check func(typ reflect.Type)
+	check = func(typ reflect.Type) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.Anonymous {
+				check(field.Type)
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+			if name == "" {
+				continue
+			}
+			if _, ok := properties[name]; !ok {
+				t.Errorf("config key %q is missing from the schema", name)
+			}
+		}
+	}
+	check(reflect.TypeOf(Config{}))
+}
@@ -0,0 +1,676 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// slowWriter delays every write, slowing down reads teed into it
+type slowWriter struct {
+	delay time.Duration
+}
+
+func (s slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return len(p), nil
+}
+
+func TestTimedLoadConfig_ObservesDuration(t *testing.T) {
+	const delay = 50 * time.Millisecond
+
+	origWrap := wrapConfigReader
+	wrapConfigReader = func(r io.Reader) io.Reader {
+		return io.TeeReader(r, slowWriter{delay: delay})
+	}
+	defer func() { wrapConfigReader = origWrap }()
+
+	m := newTestMetrics()
+	path := writeTestConfig(t, []string{"api-gateway"}, nil)
+
+	if _, err := timedLoadConfig(m, RealFileSystem{}, path, loadTriggerWatch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	h := histogramOf(t, m, loadTriggerWatch, "success")
+	if h.GetSampleCount() != 1 {
+		t.Fatalf("expected 1 observation, got %d", h.GetSampleCount())
+	}
+	if h.GetSampleSum() < delay.Seconds() {
+		t.Errorf("expected observed duration above %v, got %vs", delay, h.GetSampleSum())
+	}
+}
+
+func TestTimedLoadConfig_LabelsErrors(t *testing.T) {
+	m := newTestMetrics()
+
+	if _, err := timedLoadConfig(m, RealFileSystem{}, "/nonexistent/config.toml", loadTriggerManual); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+
+	if n := testutil.CollectAndCount(m.ConfigLoadDuration); n != 1 {
+		t.Fatalf("expected 1 series, got %d", n)
+	}
+	if h := histogramOf(t, m, loadTriggerManual, "error"); h.GetSampleCount() != 1 {
+		t.Errorf("expected 1 error observation, got %d", h.GetSampleCount())
+	}
+}
+
+// histogramOf returns the config load histogram for the given labels
+func histogramOf(t *testing.T, m *Metrics, trigger, result string) *dto.Histogram {
+	t.Helper()
+	var metric dto.Metric
+	observer := m.ConfigLoadDuration.WithLabelValues(trigger, result)
+	if err := observer.(prometheus.Metric).Write(&metric); err != nil {
+		t.Fatalf("failed to read histogram: %v", err)
+	}
+	return metric.GetHistogram()
+}
+
+func TestApplyConfig_ConfigFileMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		lines   float64
+		up      float64
+		down    float64
+	}{
+		{
+			name:    "trailing newline",
+			content: "up_services = [\"a\", \"b\", \"c\"]\ndown_services = [\"d\"]\n",
+			lines:   2,
+			up:      3,
+			down:    1,
+		},
+		{
+			name:    "no trailing newline",
+			content: "# comment\nup_services = []\n\ndown_services = [\"a\", \"b\"]",
+			lines:   4,
+			up:      0,
+			down:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			m := newTestMetrics()
+			config, err := loadConfig(RealFileSystem{}, path, m.ConfigBytesRead)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			applyConfig(m, &ServerConfig{}, config, loadTriggerManual)
+
+			if v := testutil.ToFloat64(m.ConfigFileSize); v != float64(len(tt.content)) {
+				t.Errorf("expected size %d, got %v", len(tt.content), v)
+			}
+			if v := testutil.ToFloat64(m.ConfigFileLines); v != tt.lines {
+				t.Errorf("expected %v lines, got %v", tt.lines, v)
+			}
+			if v := testutil.ToFloat64(m.ConfigServices.WithLabelValues("up")); v != tt.up {
+				t.Errorf("expected %v up services, got %v", tt.up, v)
+			}
+			if v := testutil.ToFloat64(m.ConfigServices.WithLabelValues("down")); v != tt.down {
+				t.Errorf("expected %v down services, got %v", tt.down, v)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_InvalidHeaderName(t *testing.T) {
+	for _, name := range []string{`"X Frame"`, `"X-Frame:"`, `""`, `"Café"`} {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		content := fmt.Sprintf("up_services = []\n\n[http_headers]\n%s = \"DENY\"\n", name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := loadConfig(RealFileSystem{}, path, newTestMetrics().ConfigBytesRead)
+		if err == nil || !strings.Contains(err.Error(), "invalid HTTP header name") {
+			t.Errorf("header %s: expected a validation error, got %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfig_CountsBytesRead(t *testing.T) {
+	m := newTestMetrics()
+	path := writeTestConfig(t, []string{"api-gateway"}, []string{"user-service"})
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := timedLoadConfig(m, RealFileSystem{}, path, loadTriggerManual); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got, want := testutil.ToFloat64(m.ConfigBytesRead), float64(2*info.Size()); got != want {
+		t.Errorf("expected %v bytes read, got %v", want, got)
+	}
+
+	// A read that fails midway counts only the bytes that arrived
+	origWrap := wrapConfigReader
+	wrapConfigReader = func(r io.Reader) io.Reader {
+		return io.MultiReader(io.LimitReader(r, 10), iotest.ErrReader(io.ErrUnexpectedEOF))
+	}
+	defer func() { wrapConfigReader = origWrap }()
+
+	if _, err := timedLoadConfig(m, RealFileSystem{}, path, loadTriggerManual); err == nil {
+		t.Fatal("expected the truncated read to fail")
+	}
+	if got, want := testutil.ToFloat64(m.ConfigBytesRead), float64(2*info.Size()+10); got != want {
+		t.Errorf("expected %v bytes read after the truncated read, got %v", want, got)
+	}
+}
+
+// chdir changes the working directory until the end of the test; t.Chdir
+// needs a newer Go than the module targets
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Fatalf("failed to restore the working directory: %v", err)
+		}
+	})
+}
+
+func TestLoadConfig_AbsoluteAndRelativePaths(t *testing.T) {
+	path := writeTestConfig(t, []string{"api-gateway"}, []string{"user-service"})
+	dir := filepath.Dir(path)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dotted := filepath.Join(dir, "sub") + string(filepath.Separator) + ".." + string(filepath.Separator) + "config.toml"
+	if filepath.Clean(dotted) != path {
+		t.Fatalf("expected %s to clean to %s", dotted, path)
+	}
+
+	tests := []struct {
+		name string
+		dir  string // working directory, unchanged if empty
+		path string
+	}{
+		{"absolute", "", path},
+		{"relative to the config directory", dir, "config.toml"},
+		{"relative with ./", dir, "./config.toml"},
+		{"relative from a subdirectory", filepath.Join(dir, "sub"), "../config.toml"},
+		{"absolute with ..", "", dotted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.dir != "" {
+				chdir(t, tt.dir)
+			}
+			config, err := loadConfig(RealFileSystem{}, tt.path, nil)
+			if err != nil {
+				t.Fatalf("loadConfig(%q): %v", tt.path, err)
+			}
+			if len(config.UpServices) != 1 || config.UpServices[0] != "api-gateway" {
+				t.Errorf("loadConfig(%q) loaded %+v", tt.path, config)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		chdir(t, dir)
+		for _, missing := range []string{filepath.Join(dir, "missing.toml"), "missing.toml", "sub/../missing.toml"} {
+			_, err := loadConfig(RealFileSystem{}, missing, nil)
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("loadConfig(%q): expected a not-exist error, got %v", missing, err)
+			}
+		}
+	})
+}
+
+func BenchmarkLoadConfig(b *testing.B) {
+	up := make([]string, 100)
+	for i := range up {
+		up[i] = fmt.Sprintf("service-%03d", i)
+	}
+	path := filepath.Join(b.TempDir(), "config.toml")
+	content := fmt.Sprintf("up_services = [%s]\ndown_services = []\n", quoteList(up))
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		b.Fatal(err)
+	}
+	bytesRead := newTestMetrics().ConfigBytesRead
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := loadConfig(RealFileSystem{}, path, bytesRead); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestNormalizeConfigPath(t *testing.T) {
+	for _, tt := range configPathTests {
+		if got := normalizeConfigPath(tt.path); got != tt.want {
+			t.Errorf("normalizeConfigPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWriteBase64Config(t *testing.T) {
+	content := "up_services = [\"api\"]\ndown_services = [\"db\"]\n"
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	// base64 without -w0 wraps its output every 76 characters
+	wrapped := encoded[:10] + "\n" + encoded[10:] + "\n"
+
+	for _, value := range []string{encoded, wrapped} {
+		path, err := writeBase64Config(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Remove(path) })
+
+		config, err := loadConfig(RealFileSystem{}, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(config.UpServices, config.DownServices) != "[api] [db]" {
+			t.Errorf("unexpected config from %q: %+v", value, config)
+		}
+	}
+
+	if _, err := writeBase64Config("not base64!"); err == nil {
+		t.Error("expected an error for invalid base64")
+	}
+}
+
+func TestLoadConfig_ReusedBufferDoesNotAliasConfig(t *testing.T) {
+	bytesRead := newTestMetrics().ConfigBytesRead
+	first, err := loadConfig(RealFileSystem{}, writeTestConfig(t, []string{"api-gateway"}, []string{"user-service"}), bytesRead)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Loading another file reuses the pooled buffer the first one was read into
+	if _, err := loadConfig(RealFileSystem{}, writeTestConfig(t, []string{"xxxxxxxxxxx"}, []string{"yyyyyyyyyyyy"}), bytesRead); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if first.UpServices[0] != "api-gateway" || first.DownServices[0] != "user-service" {
+		t.Errorf("config changed after its buffer was reused: %+v", first)
+	}
+}
+
+func TestCurrentConfig_ReadsDuringUpdates(t *testing.T) {
+	setTestServices(t, []string{"api"}, nil)
+	m := newTestMetrics()
+	cfg := &ServerConfig{}
+
+	// Readers never take configMutex, so they keep making progress while a
+	// writer holds it and always see a complete config
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if config := loadCurrentConfig(); len(config.UpServices)+len(config.DownServices) != 1 {
+					t.Errorf("expected one service, got %+v", config)
+					return
+				}
+			}
+		}()
+	}
+
+	configMutex.Lock()
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("svc-%d", i)
+		if i%2 == 0 {
+			applyConfig(m, cfg, &Config{UpServices: []string{name}}, loadTriggerManual)
+		} else {
+			applyConfig(m, cfg, &Config{DownServices: []string{name}}, loadTriggerManual)
+		}
+	}
+	configMutex.Unlock()
+
+	close(done)
+	wg.Wait()
+}
+
+func TestUpdateServiceMetrics_CopiesConfig(t *testing.T) {
+	setTestServices(t, nil, nil)
+
+	config := &Config{
+		UpServices:   []string{"api"},
+		Dependencies: map[string][]string{"api": {"db"}},
+		HTTPHeaders:  map[string]string{"X-Frame-Options": "DENY"},
+	}
+	updateServiceMetrics(newTestMetrics(), config)
+
+	// Changes to the caller's config after it was applied stay invisible to readers
+	config.UpServices[0] = "changed"
+	config.Dependencies["api"][0] = "changed"
+	config.HTTPHeaders["X-Frame-Options"] = "changed"
+
+	current := loadCurrentConfig()
+	if current == config {
+		t.Fatal("expected the applied config to be a copy")
+	}
+	if current.UpServices[0] != "api" || current.Dependencies["api"][0] != "db" || current.HTTPHeaders["X-Frame-Options"] != "DENY" {
+		t.Errorf("expected the applied config to be unaffected, got %+v", current)
+	}
+}
+
+// preserveConfigState restores the applied config, its ETag, modification
+// time and change times when the test ends
+func preserveConfigState(t *testing.T) {
+	t.Helper()
+	configMutex.Lock()
+	origConfig, origETag, origModTime, origChanged := loadCurrentConfig(), loadConfigETag(), lastModTime, serviceChangedAt
+	origTarget := lastConfigTarget
+	serviceChangedAt = map[string]time.Time{}
+	configMutex.Unlock()
+
+	t.Cleanup(func() {
+		configMutex.Lock()
+		currentConfig.Store(origConfig)
+		lastConfigETag.Store(origETag)
+		lastModTime, lastConfigTarget = origModTime, origTarget
+		serviceChangedAt = origChanged
+		configMutex.Unlock()
+	})
+}
+
+// syncBuffer is a bytes.Buffer that the log package and a test can share
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+// gatheredServiceStatus returns the service_monitor_up values in mfs by service
+func gatheredServiceStatus(mfs []*dto.MetricFamily) map[string]float64 {
+	statuses := map[string]float64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "service_monitor_up" {
+			continue
+		}
+		for _, metric := range mf.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "service" {
+					statuses[label.GetValue()] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return statuses
+}
+
+func TestUpdateServiceMetrics_ResetSemantics(t *testing.T) {
+	// Two configs that share some services and disagree on the status of others
+	configs := make([]*Config, 2)
+	for i := range configs {
+		configs[i] = &Config{}
+		for j := 0; j < 20; j++ {
+			shared := fmt.Sprintf("shared-%02d", j)
+			own := fmt.Sprintf("config%d-%02d", i, j)
+			if (i+j)%2 == 0 {
+				configs[i].UpServices = append(configs[i].UpServices, shared, own)
+			} else {
+				configs[i].DownServices = append(configs[i].DownServices, shared, own)
+			}
+		}
+	}
+
+	for _, ttl := range []time.Duration{0, time.Hour} {
+		t.Run(fmt.Sprintf("cache ttl %s", ttl), func(t *testing.T) {
+			setTestServices(t, nil, nil)
+			m := newTestMetrics()
+			m.Gatherer.TTL = ttl
+
+			// Scrapes run throughout the updates and may see a reset in
+			// progress; they must never fail
+			const scrapers = 2
+			var ready, done sync.WaitGroup
+			stop := make(chan struct{})
+			ready.Add(scrapers)
+			done.Add(scrapers)
+			for i := 0; i < scrapers; i++ {
+				go func() {
+					defer done.Done()
+					ready.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						if _, err := m.Gatherer.Gather(); err != nil {
+							t.Errorf("scrape failed during an update: %v", err)
+							return
+						}
+					}
+				}()
+			}
+			ready.Wait()
+
+			// Once updateServiceMetrics returns, every scrape has exactly the
+			// services of the applied config with their status
+			for i := 0; i < 100; i++ {
+				config := configs[i%2]
+				configMutex.Lock()
+				updateServiceMetrics(m, config)
+				configMutex.Unlock()
+
+				mfs, err := m.Gatherer.Gather()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := gatheredServiceStatus(mfs)
+				if len(got) != len(config.UpServices)+len(config.DownServices) {
+					t.Fatalf("update %d: expected %d services, got %d", i, len(config.UpServices)+len(config.DownServices), len(got))
+				}
+				for _, service := range config.UpServices {
+					if v, ok := got[service]; !ok || v != 1 {
+						t.Fatalf("update %d: expected %s to be up, got %v (present: %v)", i, service, v, ok)
+					}
+				}
+				for _, service := range config.DownServices {
+					if v, ok := got[service]; !ok || v != 0 {
+						t.Fatalf("update %d: expected %s to be down, got %v (present: %v)", i, service, v, ok)
+					}
+				}
+			}
+			close(stop)
+			done.Wait()
+		})
+	}
+}
+
+func TestConfigWatcher_FileDeleted(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	origInterval := configCheckInterval
+	configCheckInterval = interval
+	t.Cleanup(func() { configCheckInterval = origInterval })
+
+	var logs syncBuffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	preserveConfigState(t)
+
+	m := newTestMetrics()
+	path := writeTestConfig(t, []string{"api-gateway"}, nil)
+	cfg := &ServerConfig{ConfigPath: path}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchConfig(ctx, m, cfg)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	hasService := func(name string) func() bool {
+		return func() bool {
+			config := loadCurrentConfig()
+			return config != nil && len(config.UpServices) == 1 && config.UpServices[0] == name
+		}
+	}
+	if !waitFor(t, 2*time.Second, hasService("api-gateway")) {
+		t.Fatal("watcher did not load the initial config")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return strings.Contains(logs.String(), "Error checking config file") }) {
+		t.Fatalf("expected the missing file to be logged, got:\n%s", logs.String())
+	}
+
+	// The watcher keeps polling while the file is gone
+	heartbeat := testutil.ToFloat64(m.ConfigWatcherHeartbeat)
+	time.Sleep(2 * interval)
+	if !waitFor(t, 2*time.Second, func() bool { return testutil.ToFloat64(m.ConfigWatcherHeartbeat) > heartbeat }) {
+		t.Fatal("watcher heartbeat stopped after the file was deleted")
+	}
+	if !hasService("api-gateway")() {
+		t.Errorf("expected the last config to stay applied, got %+v", loadCurrentConfig())
+	}
+
+	// Restoring the file is picked up on a later poll
+	content := "up_services = [\"auth-service\"]\ndown_services = []\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	restored := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, restored, restored); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, 2*time.Second, hasService("auth-service")) {
+		t.Fatalf("watcher did not reload the restored file, got %+v", loadCurrentConfig())
+	}
+}
+
+func TestSymlinkConfigReload(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	origInterval := configCheckInterval
+	configCheckInterval = interval
+	t.Cleanup(func() { configCheckInterval = origInterval })
+
+	preserveConfigState(t)
+
+	// Same layout as a ConfigMap volume: config.toml -> data/config.toml -> v1/config.toml
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	for _, version := range []string{"v1", "v2"} {
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfigAt(t, filepath.Join(dir, "v1", "config.toml"), "up_services = [\"api-gateway\"]\n", modTime)
+	if err := os.Symlink("v1", filepath.Join(dir, "data")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	path := filepath.Join(dir, "config.toml")
+	if err := os.Symlink(filepath.Join("data", "config.toml"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{ConfigPath: path}
+	statuses := func() map[string]float64 {
+		mfs, err := m.Registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gatheredServiceStatus(mfs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchConfig(ctx, m, cfg)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if !waitFor(t, 2*time.Second, func() bool { return statuses()["api-gateway"] == 1 }) {
+		t.Fatal("watcher did not load the initial config")
+	}
+
+	// The new version keeps the mtime, so only the swapped symlink reveals it
+	writeConfigAt(t, filepath.Join(dir, "v2", "config.toml"), "up_services = [\"auth-service\"]\n", modTime)
+	if err := os.Symlink("v2", filepath.Join(dir, "data.tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "data.tmp"), filepath.Join(dir, "data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !waitFor(t, 2*time.Second, func() bool { return statuses()["auth-service"] == 1 }) {
+		t.Fatalf("watcher did not reload after the symlink swap, got %+v", loadCurrentConfig())
+	}
+	if _, ok := statuses()["api-gateway"]; ok {
+		t.Error("expected api-gateway to be removed after the reload")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/pelletier/go-toml/v2"
+	"golang.org/x/time/rate"
+)
+
+// configValidateRate is the number of /config/validate requests allowed per second
+const configValidateRate = 5
+
+// configValidateResponse is the JSON body returned by /config/validate
+type configValidateResponse struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// parseProposedConfig parses a submitted config body as TOML, the config file
+// format, or as JSON with the service lists accepted by /config/diff
+func parseProposedConfig(contentType string, body []byte) (*Config, error) {
+	mediaType := "application/toml"
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, fmt.Errorf("invalid Content-Type: %w", err)
+		}
+	}
+
+	switch mediaType {
+	case "application/toml", "text/plain":
+		var config Config
+		if err := toml.Unmarshal(body, &config); err != nil {
+			var decodeErr *toml.DecodeError
+			if errors.As(err, &decodeErr) {
+				row, col := decodeErr.Position()
+				return nil, fmt.Errorf("invalid TOML at line %d, column %d: %v", row, col, decodeErr)
+			}
+			return nil, fmt.Errorf("invalid TOML: %w", err)
+		}
+		return &config, nil
+	case "application/json":
+		var req configRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return &Config{UpServices: req.UpServices, DownServices: req.DownServices}, nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Type %q, use application/toml or application/json", mediaType)
+	}
+}
+
+// configValidateHandler checks a submitted config without applying it
+// It takes no locks and records no metrics, so pipelines can call it freely
+// within its own rate limit; only a body that can't be parsed gets a 400
+func configValidateHandler(limiter *rate.Limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !limiter.Allow() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeJSON(w, http.StatusRequestEntityTooLarge, configValidateResponse{
+					Errors: []string{fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit)},
+				})
+				return
+			}
+			writeJSON(w, http.StatusBadRequest, configValidateResponse{Errors: []string{err.Error()}})
+			return
+		}
+
+		config, err := parseProposedConfig(r.Header.Get("Content-Type"), body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, configValidateResponse{Errors: []string{err.Error()}})
+			return
+		}
+
+		problems := configProblems(config)
+		writeJSON(w, http.StatusOK, configValidateResponse{Valid: len(problems) == 0, Errors: problems})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/time/rate"
+)
+
+func TestConfigValidateHandler(t *testing.T) {
+	setTestServices(t, []string{"api"}, []string{"db"})
+	m := newTestMetrics()
+	updateServiceMetrics(m, loadCurrentConfig())
+	handler := configValidateHandler(rate.NewLimiter(rate.Inf, 0))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    int
+		wantValid   bool
+		wantErrors  int
+	}{
+		{"valid TOML", "application/toml", "up_services = [\"web\"]\ndown_services = [\"cache\"]\n", http.StatusOK, true, 0},
+		{"valid JSON", "application/json", `{"up_services":["web"],"down_services":["cache"]}`, http.StatusOK, true, 0},
+		{"duplicate service", "application/toml", "up_services = [\"web\"]\ndown_services = [\"web\"]\n", http.StatusOK, false, 1},
+		{"service repeated in one list", "application/toml", "up_services = [\"web\", \"web\", \"web\"]\ndown_services = [\"db\", \"db\"]\n", http.StatusOK, false, 2},
+		{"duplicate service JSON", "application/json", `{"up_services":["web"],"down_services":["web",""]}`, http.StatusOK, false, 2},
+		{"malformed TOML", "application/toml", "up_services = [\"web\"\n", http.StatusBadRequest, false, 1},
+		{"malformed JSON", "application/json", `{"up_services":`, http.StatusBadRequest, false, 1},
+		{"unsupported type", "application/xml", "<config/>", http.StatusBadRequest, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/config/validate", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			var resp configValidateResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if resp.Valid != tt.wantValid || len(resp.Errors) != tt.wantErrors {
+				t.Errorf("unexpected response: %+v", resp)
+			}
+		})
+	}
+
+	// Validation never touches the live config or the metrics
+	if got := loadCurrentConfig(); len(got.UpServices) != 1 || got.UpServices[0] != "api" {
+		t.Errorf("live config changed: %+v", got)
+	}
+	if n := testutil.CollectAndCount(m.ServiceStatus); n != 2 {
+		t.Errorf("expected only the statuses of api and db, got %d series", n)
+	}
+}
+
+func TestConfigValidateHandler_MalformedTOMLPosition(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/config/validate",
+		strings.NewReader("up_services = [\"web\"]\ndown_services = \n"))
+	rec := httptest.NewRecorder()
+	configValidateHandler(rate.NewLimiter(rate.Inf, 0))(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "line 2") {
+		t.Errorf("expected the error to name line 2, got %s", rec.Body.String())
+	}
+}
+
+func TestConfigValidateHandler_RateLimit(t *testing.T) {
+	handler := configValidateHandler(rate.NewLimiter(configValidateRate, configValidateRate))
+
+	var limited int
+	for i := 0; i < configValidateRate+2; i++ {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/config/validate", strings.NewReader("up_services = []")))
+		if rec.Code == http.StatusTooManyRequests {
+			limited++
+			if rec.Header().Get("Retry-After") == "" {
+				t.Error("expected a Retry-After header")
+			}
+		}
+	}
+	if limited == 0 {
+		t.Error("expected requests beyond the burst to be rate limited")
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/config/validate", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("expected 405 with Allow: POST, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cpuThrottlingInterval = 10 * time.Second
+
+// cgroupCPUStatFile holds the CFS throttling statistics of the container (cgroups v2)
+var cgroupCPUStatFile = "/sys/fs/cgroup/cpu.stat"
+
+// cpuStat holds the throttling fields of a cgroup cpu.stat file
+type cpuStat struct {
+	nrThrottled   uint64
+	throttledUsec uint64
+}
+
+// parseCPUStat extracts the throttling fields from cpu.stat content
+func parseCPUStat(data []byte) (cpuStat, error) {
+	var stat cpuStat
+	var found int
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		var target *uint64
+		switch fields[0] {
+		case "nr_throttled":
+			target = &stat.nrThrottled
+		case "throttled_usec":
+			target = &stat.throttledUsec
+		default:
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return cpuStat{}, fmt.Errorf("invalid %s value %q: %w", fields[0], fields[1], err)
+		}
+		*target = value
+		found++
+	}
+
+	if found != 2 {
+		return cpuStat{}, fmt.Errorf("nr_throttled or throttled_usec missing from cpu.stat")
+	}
+	return stat, nil
+}
+
+// recordCPUThrottling adds the throttling since prev to the counters and returns next
+func recordCPUThrottling(m *Metrics, prev, next cpuStat) cpuStat {
+	// The cgroup counters only decrease if the cgroup is recreated; skip that sample
+	if next.nrThrottled >= prev.nrThrottled && next.throttledUsec >= prev.throttledUsec {
+		m.CPUThrottledPeriods.Add(float64(next.nrThrottled - prev.nrThrottled))
+		m.CPUThrottledSeconds.Add(float64(next.throttledUsec-prev.throttledUsec) / 1e6)
+	}
+	return next
+}
+
+// runCPUThrottlingMonitor periodically reads the cgroup CPU throttling statistics
+func runCPUThrottlingMonitor(m *Metrics, interval time.Duration) {
+	data, err := os.ReadFile(cgroupCPUStatFile)
+	if err != nil {
+		log.Printf("CPU throttling statistics unavailable, not starting the throttling monitor: %v", err)
+		return
+	}
+	last, err := parseCPUStat(data)
+	if err != nil {
+		log.Printf("Error parsing %s, not starting the throttling monitor: %v", cgroupCPUStatFile, err)
+		return
+	}
+
+	// Throttling before startup counts too, so the counters match the cgroup
+	last = recordCPUThrottling(m, cpuStat{}, last)
+
+	for {
+		time.Sleep(interval)
+
+		data, err := os.ReadFile(cgroupCPUStatFile)
+		if err != nil {
+			log.Printf("Error reading %s: %v", cgroupCPUStatFile, err)
+			continue
+		}
+		next, err := parseCPUStat(data)
+		if err != nil {
+			log.Printf("Error parsing %s: %v", cgroupCPUStatFile, err)
+			continue
+		}
+		last = recordCPUThrottling(m, last, next)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseCPUStat(t *testing.T) {
+	data := []byte(`usage_usec 8827381
+user_usec 5512749
+system_usec 3314632
+nr_periods 1204
+nr_throttled 37
+throttled_usec 2500000
+`)
+	stat, err := parseCPUStat(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stat.nrThrottled != 37 || stat.throttledUsec != 2500000 {
+		t.Errorf("unexpected stat: %+v", stat)
+	}
+
+	for _, invalid := range []string{"", "usage_usec 10\n", "nr_throttled x\nthrottled_usec 1\n"} {
+		if _, err := parseCPUStat([]byte(invalid)); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestRecordCPUThrottling(t *testing.T) {
+	m := newTestMetrics()
+
+	last := recordCPUThrottling(m, cpuStat{}, cpuStat{nrThrottled: 2, throttledUsec: 1500000})
+	last = recordCPUThrottling(m, last, cpuStat{nrThrottled: 5, throttledUsec: 2000000})
+
+	if got := testutil.ToFloat64(m.CPUThrottledSeconds); got != 2 {
+		t.Errorf("expected 2 throttled seconds, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.CPUThrottledPeriods); got != 5 {
+		t.Errorf("expected 5 throttled periods, got %v", got)
+	}
+
+	// A reset of the cgroup counters must not decrease the Prometheus counters
+	last = recordCPUThrottling(m, last, cpuStat{nrThrottled: 1, throttledUsec: 100})
+	if got := testutil.ToFloat64(m.CPUThrottledSeconds); got != 2 {
+		t.Errorf("expected counters unchanged after a reset, got %v", got)
+	}
+	recordCPUThrottling(m, last, cpuStat{nrThrottled: 2, throttledUsec: 500100})
+	if got := testutil.ToFloat64(m.CPUThrottledSeconds); got != 2.5 {
+		t.Errorf("expected 2.5 throttled seconds, got %v", got)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+// effectiveStatus resolves which services are effectively up and whether the
+// dependency graph contains a cycle
+// A service is effectively up only if it is up itself and all of its direct
+// dependencies are effectively up; unmonitored dependencies count as down, as
+// do services on or downstream of a cycle
+func effectiveStatus(config *Config) (map[string]bool, bool) {
+	status := make(map[string]bool, len(config.UpServices)+len(config.DownServices))
+	for _, service := range config.DownServices {
+		status[service] = false
+	}
+	for _, service := range config.UpServices {
+		status[service] = true
+	}
+
+	// Build the graph with edges from each dependency to its dependents
+	nodes := make(map[string]bool, len(status))
+	for service := range status {
+		nodes[service] = true
+	}
+	inDegree := make(map[string]int)
+	dependents := make(map[string][]string)
+	for service, deps := range config.Dependencies {
+		nodes[service] = true
+		for _, dep := range deps {
+			nodes[dep] = true
+			inDegree[service]++
+			dependents[dep] = append(dependents[dep], service)
+		}
+	}
+
+	// Kahn's algorithm: every node is resolved after all of its dependencies
+	queue := make([]string, 0, len(nodes))
+	for node := range nodes {
+		if inDegree[node] == 0 {
+			queue = append(queue, node)
+		}
+	}
+
+	effective := make(map[string]bool, len(nodes))
+	resolved := 0
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		resolved++
+
+		up := status[node]
+		for _, dep := range config.Dependencies[node] {
+			up = up && effective[dep]
+		}
+		effective[node] = up
+
+		for _, dependent := range dependents[node] {
+			inDegree[dependent]--
+			if inDegree[dependent] == 0 {
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	// Nodes never resolved sit on or behind a cycle and stay effectively down
+	result := make(map[string]bool, len(status))
+	for service := range status {
+		result[service] = effective[service]
+	}
+	return result, resolved < len(nodes)
+}
+
+// updateEffectiveMetrics publishes the dependency-aware status of every service
+// Only the series of removed services are deleted, so a scrape during the
+// update never misses a service that is still configured
+func updateEffectiveMetrics(m *Metrics, config *Config) {
+	effective, cycle := effectiveStatus(config)
+	if cycle {
+		m.DependencyCycles.Inc()
+	}
+
+	for _, service := range statusServices(m.EffectiveStatus) {
+		if _, ok := effective[service]; !ok {
+			m.EffectiveStatus.DeleteLabelValues(service)
+		}
+	}
+	for service, up := range effective {
+		value := 0.0
+		if up {
+			value = 1
+		}
+		m.EffectiveStatus.WithLabelValues(service).Set(value)
+	}
+}
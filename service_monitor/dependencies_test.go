@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEffectiveStatus_DependencyGraphWithCycle(t *testing.T) {
+	// api-gateway <- auth-service <- user-service
+	// payment-service <-> billing-service (cycle)
+	config := &Config{
+		UpServices: []string{"api-gateway", "auth-service", "user-service", "payment-service", "billing-service"},
+		Dependencies: map[string][]string{
+			"auth-service":    {"api-gateway"},
+			"user-service":    {"auth-service"},
+			"payment-service": {"billing-service"},
+			"billing-service": {"payment-service"},
+		},
+	}
+
+	m := newTestMetrics()
+	updateServiceMetrics(m, config)
+
+	want := map[string]float64{
+		"api-gateway":     1,
+		"auth-service":    1,
+		"user-service":    1,
+		"payment-service": 0,
+		"billing-service": 0,
+	}
+	for service, value := range want {
+		if got := testutil.ToFloat64(m.EffectiveStatus.WithLabelValues(service)); got != value {
+			t.Errorf("effective status of %s = %v, want %v", service, got, value)
+		}
+	}
+	if v := testutil.ToFloat64(m.DependencyCycles); v != 1 {
+		t.Errorf("expected 1 detected cycle, got %v", v)
+	}
+
+	// Taking the gateway down propagates through the dependency chain
+	config.UpServices = []string{"auth-service", "user-service", "payment-service", "billing-service"}
+	config.DownServices = []string{"api-gateway"}
+	updateServiceMetrics(m, config)
+
+	for _, service := range []string{"api-gateway", "auth-service", "user-service"} {
+		if got := testutil.ToFloat64(m.EffectiveStatus.WithLabelValues(service)); got != 0 {
+			t.Errorf("expected %s to be effectively down, got %v", service, got)
+		}
+	}
+	if got := testutil.ToFloat64(m.ServiceStatus.WithLabelValues("auth-service")); got != 1 {
+		t.Errorf("own status of auth-service must stay up, got %v", got)
+	}
+	if v := testutil.ToFloat64(m.DependencyCycles); v != 2 {
+		t.Errorf("expected the cycle to be counted again, got %v", v)
+	}
+}
+
+func TestEffectiveStatus_KeepsConfiguredSeries(t *testing.T) {
+	m := newTestMetrics()
+	updateEffectiveMetrics(m, &Config{UpServices: []string{"api", "cache"}})
+	api := m.EffectiveStatus.WithLabelValues("api")
+
+	// Removing cache leaves the series of api in place instead of recreating
+	// it, so a concurrent scrape can't miss it
+	updateEffectiveMetrics(m, &Config{UpServices: []string{"api"}})
+	if m.EffectiveStatus.WithLabelValues("api") != api {
+		t.Error("expected the api series to be kept across the update")
+	}
+	if got := statusServices(m.EffectiveStatus); len(got) != 1 || got[0] != "api" {
+		t.Errorf("expected only the api series after cache was removed, got %v", got)
+	}
+}
+
+func TestEffectiveStatus_UnmonitoredDependency(t *testing.T) {
+	effective, cycle := effectiveStatus(&Config{
+		UpServices:   []string{"user-service"},
+		Dependencies: map[string][]string{"user-service": {"database"}},
+	})
+
+	if cycle {
+		t.Error("expected no cycle")
+	}
+	if effective["user-service"] {
+		t.Error("expected a service with an unmonitored dependency to be effectively down")
+	}
+	if _, ok := effective["database"]; ok {
+		t.Error("unmonitored dependencies must not get an effective status")
+	}
+}
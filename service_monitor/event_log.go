@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event log flushing thresholds
+const (
+	eventLogFlushInterval = time.Second
+	eventLogFlushEvents   = 100
+)
+
+// statusAbsent is the status recorded for a service that isn't in the config
+const statusAbsent = -1
+
+// statusEvent is one record of the status change event log
+type statusEvent struct {
+	Timestamp  time.Time `json:"ts"`
+	Service    string    `json:"service"`
+	OldStatus  int       `json:"old_status"`
+	NewStatus  int       `json:"new_status"`
+	Trigger    string    `json:"trigger"`
+	Generation uint64    `json:"generation"`
+}
+
+// statusEvents lists the status changes from prev to next, ordered by service
+func statusEvents(prev, next *Config, trigger string, generation uint64, now time.Time) []statusEvent {
+	before := serviceStatuses(prev)
+	after := serviceStatuses(next)
+
+	names := make(map[string]bool, len(before)+len(after))
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+
+	var events []statusEvent
+	for name := range names {
+		oldStatus, newStatus := statusValue(before, name), statusValue(after, name)
+		if oldStatus != newStatus {
+			events = append(events, statusEvent{
+				Timestamp:  now,
+				Service:    name,
+				OldStatus:  oldStatus,
+				NewStatus:  newStatus,
+				Trigger:    trigger,
+				Generation: generation,
+			})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Service < events[j].Service })
+	return events
+}
+
+// statusValue returns 1 for up, 0 for down and statusAbsent for unknown services
+func statusValue(statuses map[string]bool, name string) int {
+	up, ok := statuses[name]
+	switch {
+	case !ok:
+		return statusAbsent
+	case up:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// eventLog appends status change events to a file as newline-delimited JSON
+// Writes are buffered and flushed every second or every 100 events
+type eventLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	pending int
+}
+
+// openEventLog opens path for appending, creating it if needed
+func openEventLog(path string) (*eventLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("error opening event log: %w", err)
+	}
+	return &eventLog{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// write buffers events, flushing once enough are pending
+func (l *eventLog) write(m *Metrics, events []statusEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err == nil {
+			data = append(data, '\n')
+			_, err = l.w.Write(data)
+		}
+		if err != nil {
+			m.EventLogWriteErrors.Inc()
+			log.Printf("Error writing event log: %v", err)
+			continue
+		}
+		m.EventLogWrites.Inc()
+		l.pending++
+	}
+
+	if l.pending >= eventLogFlushEvents {
+		l.flushLocked(m)
+	}
+}
+
+// run flushes buffered events every interval
+func (l *eventLog) run(m *Metrics, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.mu.Lock()
+		l.flushLocked(m)
+		l.mu.Unlock()
+	}
+}
+
+// flushLocked writes buffered events to the file; l.mu must be held
+func (l *eventLog) flushLocked(m *Metrics) {
+	if l.pending == 0 {
+		return
+	}
+	if err := l.w.Flush(); err != nil {
+		m.EventLogWriteErrors.Inc()
+		log.Printf("Error flushing event log: %v", err)
+		return
+	}
+	l.pending = 0
+}
+
+// Close flushes buffered events and closes the file
+func (l *eventLog) Close(m *Metrics) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.flushLocked(m)
+	return l.file.Close()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEventLog_StatusChanges(t *testing.T) {
+	setTestServices(t, nil, nil)
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	eventLog, err := openEventLog(path)
+	if err != nil {
+		t.Fatalf("failed to open event log: %v", err)
+	}
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{EventLog: eventLog}
+
+	// The first config adds api, then each flip is exactly one status change
+	applyConfig(m, cfg, &Config{UpServices: []string{"api"}}, loadTriggerManual)
+	for i := 0; i < 9; i++ {
+		config := &Config{UpServices: []string{"api"}}
+		if i%2 == 0 {
+			config = &Config{DownServices: []string{"api"}}
+		}
+		applyConfig(m, cfg, config, loadTriggerWatch)
+	}
+
+	if err := eventLog.Close(m); err != nil {
+		t.Fatalf("failed to close event log: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var events []statusEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event statusEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %d is not valid JSON: %v", len(events)+1, err)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) != 10 {
+		t.Fatalf("expected 10 events, got %d", len(events))
+	}
+	if first := events[0]; first.OldStatus != statusAbsent || first.NewStatus != 1 || first.Trigger != loadTriggerManual {
+		t.Errorf("unexpected first event: %+v", first)
+	}
+	if second := events[1]; second.Service != "api" || second.OldStatus != 1 || second.NewStatus != 0 || second.Trigger != loadTriggerWatch {
+		t.Errorf("unexpected second event: %+v", second)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Generation <= events[i-1].Generation {
+			t.Errorf("expected increasing generations, got %d after %d", events[i].Generation, events[i-1].Generation)
+		}
+	}
+
+	if got := testutil.ToFloat64(m.EventLogWrites); got != 10 {
+		t.Errorf("expected 10 writes, got %v", got)
+	}
+}
+
+func TestStatusEvents(t *testing.T) {
+	prev := &Config{UpServices: []string{"api", "db"}, DownServices: []string{"cache"}}
+	next := &Config{UpServices: []string{"api", "cache"}, DownServices: []string{"queue"}}
+
+	events := statusEvents(prev, next, loadTriggerWatch, 7, time.Unix(0, 0))
+
+	want := []struct {
+		service  string
+		old, new int
+	}{
+		{"cache", 0, 1},
+		{"db", 1, statusAbsent},
+		{"queue", statusAbsent, 0},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), events)
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Service != w.service || e.OldStatus != w.old || e.NewStatus != w.new || e.Generation != 7 {
+			t.Errorf("event %d: expected %+v, got %+v", i, w, e)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build linux
+
+package main
+
+import "os"
+
+// openFDCount returns the number of file descriptors the process has open
+func openFDCount() (int, bool) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, false
+	}
+	// ReadDir itself holds one descriptor open for /proc/self/fd
+	return len(entries) - 1, true
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// openFDCount reports that open descriptors can't be counted on this platform
+func openFDCount() (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,19 @@
+package main
+
+import "log"
+
+// detectFDLeak runs load and counts a leak when more file descriptors are
+// open afterwards than before; a no-op where descriptors can't be counted
+// Descriptors opened concurrently by other goroutines also count, so an
+// occasional increment is noise while a steady rate points at a leak
+func detectFDLeak(m *Metrics, load func()) {
+	before, ok := openFDCount()
+	load()
+	if !ok {
+		return
+	}
+	if after, _ := openFDCount(); after > before {
+		m.ConfigFDLeaks.Inc()
+		log.Printf("Open file descriptors grew from %d to %d while loading the config", before, after)
+	}
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDetectFDLeak(t *testing.T) {
+	m := newTestMetrics()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("up_services = []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	detectFDLeak(m, func() {
+		if _, err := loadConfig(RealFileSystem{}, path, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if got := testutil.ToFloat64(m.ConfigFDLeaks); got != 0 {
+		t.Fatalf("expected loadConfig not to leak, got %v leaks", got)
+	}
+
+	// A loader that forgets to close the file
+	detectFDLeak(m, func() {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+	})
+	if got := testutil.ToFloat64(m.ConfigFDLeaks); got != 1 {
+		t.Errorf("expected 1 leak, got %v", got)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileSystem is the file access the config loader and watcher need, so tests
+// can exercise failures without touching the real filesystem
+type FileSystem interface {
+	Open(name string) (io.ReadCloser, error)
+	ReadFile(name string) ([]byte, error)
+	Stat(name string) (os.FileInfo, error)
+	EvalSymlinks(name string) (string, error)
+}
+
+// RealFileSystem delegates to the os package
+type RealFileSystem struct{}
+
+func (RealFileSystem) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (RealFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (RealFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (RealFileSystem) EvalSymlinks(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
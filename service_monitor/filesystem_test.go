@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// FakeFileSystem serves files from memory and can inject errors and delays
+type FakeFileSystem struct {
+	// Contents of each file by name
+	Files map[string][]byte
+
+	// Errors returned by Open, ReadFile and Stat for a name
+	Errors map[string]error
+
+	// Errors returned by reads once the first PartialBytes bytes were served
+	ReadErrors   map[string]error
+	PartialBytes int
+
+	// Time every call waits before returning
+	Delay time.Duration
+
+	// Modification time reported by Stat
+	ModTime time.Time
+
+	// Path each symlink resolves to; other files resolve to themselves
+	Links map[string]string
+}
+
+func (f *FakeFileSystem) lookup(op, name string) ([]byte, error) {
+	time.Sleep(f.Delay)
+	if err := f.Errors[name]; err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	data, ok := f.Files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (f *FakeFileSystem) Open(name string) (io.ReadCloser, error) {
+	data, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if readErr := f.ReadErrors[name]; readErr != nil {
+		partial := io.LimitReader(bytes.NewReader(data), int64(f.PartialBytes))
+		return io.NopCloser(io.MultiReader(partial, iotest.ErrReader(readErr))), nil
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (f *FakeFileSystem) ReadFile(name string) ([]byte, error) {
+	data, err := f.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(data), nil
+}
+
+func (f *FakeFileSystem) Stat(name string) (os.FileInfo, error) {
+	data, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fakeFileInfo{name: name, size: int64(len(data)), modTime: f.ModTime}, nil
+}
+
+func (f *FakeFileSystem) EvalSymlinks(name string) (string, error) {
+	if _, err := f.lookup("lstat", name); err != nil {
+		return "", err
+	}
+	if target, ok := f.Links[name]; ok {
+		return target, nil
+	}
+	return name, nil
+}
+
+// fakeFileInfo describes a regular file of a FakeFileSystem
+type fakeFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i fakeFileInfo) Name() string       { return i.name }
+func (i fakeFileInfo) Size() int64        { return i.size }
+func (i fakeFileInfo) Mode() fs.FileMode  { return 0644 }
+func (i fakeFileInfo) ModTime() time.Time { return i.modTime }
+func (i fakeFileInfo) IsDir() bool        { return false }
+func (i fakeFileInfo) Sys() interface{}   { return nil }
+
+func TestLoadConfig_FakeFileSystem(t *testing.T) {
+	const path = "/app/config/config.toml"
+	const content = "up_services = [\"api-gateway\"]\ndown_services = [\"user-service\"]\n"
+
+	tests := []struct {
+		name    string
+		fsys    *FakeFileSystem
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name:    "file not found",
+			fsys:    &FakeFileSystem{},
+			wantErr: fs.ErrNotExist,
+			wantMsg: "error opening config file",
+		},
+		{
+			name: "permission denied",
+			fsys: &FakeFileSystem{
+				Files:  map[string][]byte{path: []byte(content)},
+				Errors: map[string]error{path: fs.ErrPermission},
+			},
+			wantErr: fs.ErrPermission,
+			wantMsg: "error opening config file",
+		},
+		{
+			name: "partial read",
+			fsys: &FakeFileSystem{
+				Files:        map[string][]byte{path: []byte(content)},
+				ReadErrors:   map[string]error{path: io.ErrUnexpectedEOF},
+				PartialBytes: 10,
+			},
+			wantErr: io.ErrUnexpectedEOF,
+			wantMsg: "error reading config file",
+		},
+	}
+
+	for _, tt := range tests {
+		m := newTestMetrics()
+		_, err := loadConfig(tt.fsys, path, m.ConfigBytesRead)
+		if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), tt.wantMsg) {
+			t.Errorf("%s: expected %q wrapping %v, got %v", tt.name, tt.wantMsg, tt.wantErr, err)
+		}
+		if tt.fsys.PartialBytes > 0 {
+			if v := testutil.ToFloat64(m.ConfigBytesRead); v != float64(tt.fsys.PartialBytes) {
+				t.Errorf("%s: expected %d bytes read, got %v", tt.name, tt.fsys.PartialBytes, v)
+			}
+		}
+	}
+}
+
+func TestLoadConfig_FakeFileSystemContent(t *testing.T) {
+	const path = "/app/config/config.toml"
+	const content = "up_services = [\"api-gateway\", \"auth-service\"]\ndown_services = [\"user-service\"]\n"
+
+	fsys := &FakeFileSystem{Files: map[string][]byte{path: []byte(content)}, Delay: time.Millisecond}
+	m := newTestMetrics()
+	config, err := timedLoadConfig(m, fsys, path, loadTriggerManual)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.Join(config.UpServices, ",") != "api-gateway,auth-service" || strings.Join(config.DownServices, ",") != "user-service" {
+		t.Errorf("unexpected services: up %v, down %v", config.UpServices, config.DownServices)
+	}
+	if config.fileSize != len(content) || config.fileLines != 2 {
+		t.Errorf("expected %d bytes in 2 lines, got %d bytes in %d lines", len(content), config.fileSize, config.fileLines)
+	}
+	if h := histogramOf(t, m, loadTriggerManual, "success"); h.GetSampleSum() < time.Millisecond.Seconds() {
+		t.Errorf("expected the injected delay to be observed, got %vs", h.GetSampleSum())
+	}
+}
+
+func TestReloadHandler_UsesFileSystem(t *testing.T) {
+	const path = "/app/config/config.toml"
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fsys := &FakeFileSystem{
+		Files:   map[string][]byte{path: []byte("up_services = [\"api-gateway\"]\n")},
+		ModTime: modTime,
+	}
+
+	preserveConfigState(t)
+
+	m := newTestMetrics()
+	rec := httptest.NewRecorder()
+	reloadHandler(m, &ServerConfig{ConfigPath: path, FS: fsys})(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	if !lastModTime.Equal(modTime) {
+		t.Errorf("expected the modification time from Stat, got %v", lastModTime)
+	}
+}
+
+func TestCheckConfigFile_SymlinkTargetChanged(t *testing.T) {
+	const path = "/app/config/config.toml"
+	preserveConfigState(t)
+
+	fsys := &FakeFileSystem{
+		Files:   map[string][]byte{path: []byte("up_services = [\"api-gateway\"]\n")},
+		Links:   map[string]string{path: "/app/config/..2024_01_01_00_00_00.1/config.toml"},
+		ModTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	m := newTestMetrics()
+	cfg := &ServerConfig{ConfigPath: path, FS: fsys}
+	if _, err := reloadConfig(m, cfg, loadTriggerStartup); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same mtime and target: the new content isn't noticed
+	fsys.Files[path] = []byte("up_services = [\"auth-service\"]\n")
+	checkConfigFile(m, cfg)
+	if got := loadCurrentConfig().UpServices; len(got) != 1 || got[0] != "api-gateway" {
+		t.Fatalf("expected no reload without a change, got %v", got)
+	}
+
+	// Kubernetes swapped ..data to a new timestamped directory
+	fsys.Links[path] = "/app/config/..2024_01_02_00_00_00.2/config.toml"
+	checkConfigFile(m, cfg)
+	if got := loadCurrentConfig().UpServices; len(got) != 1 || got[0] != "auth-service" {
+		t.Errorf("expected a reload after the symlink target changed, got %v", got)
+	}
+	if got := histogramOf(t, m, loadTriggerWatch, "success").GetSampleCount(); got != 1 {
+		t.Errorf("expected 1 watch load, got %d", got)
+	}
+}
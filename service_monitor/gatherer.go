@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// CachedGatherer serves the last Gather result while it is younger than TTL
+// A TTL of zero disables caching and every call is passed through
+type CachedGatherer struct {
+	gatherer prometheus.Gatherer
+
+	// TTL must be set before the gatherer is first used
+	TTL time.Duration
+
+	// now returns the current time; replaced in tests
+	now func() time.Time
+
+	mu         sync.Mutex
+	cached     []*dto.MetricFamily
+	err        error
+	cachedAt   time.Time
+	valid      bool
+	generation uint64
+}
+
+// NewCachedGatherer wraps g with a result cache of the given TTL
+func NewCachedGatherer(g prometheus.Gatherer, ttl time.Duration) *CachedGatherer {
+	return &CachedGatherer{gatherer: g, TTL: ttl, now: time.Now}
+}
+
+// Gather implements prometheus.Gatherer
+func (c *CachedGatherer) Gather() ([]*dto.MetricFamily, error) {
+	if c.TTL <= 0 {
+		return c.gatherer.Gather()
+	}
+
+	c.mu.Lock()
+	if c.valid && c.now().Sub(c.cachedAt) < c.TTL {
+		mfs, err := c.cached, c.err
+		c.mu.Unlock()
+		return mfs, err
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	gatheredAt := c.now()
+	mfs, err := c.gatherer.Gather()
+
+	// Don't cache a result that an invalidation raced with
+	c.mu.Lock()
+	if c.generation == generation {
+		c.cached, c.err, c.cachedAt, c.valid = mfs, err, gatheredAt, true
+	}
+	c.mu.Unlock()
+
+	return mfs, err
+}
+
+// Invalidate drops the cached result so the next Gather is fresh
+func (c *CachedGatherer) Invalidate() {
+	c.mu.Lock()
+	c.valid = false
+	c.cached = nil
+	c.err = nil
+	c.generation++
+	c.mu.Unlock()
+}
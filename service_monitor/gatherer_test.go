@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeClock is a manually advanced clock for staleness tests
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// gaugeValue gathers from g and returns the value of the named gauge
+func gaugeValue(t *testing.T, g prometheus.Gatherer, name string) float64 {
+	t.Helper()
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("metric %s not found", name)
+	return 0
+}
+
+func TestCachedGatherer_StalenessBounds(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge", Help: "test"})
+	reg.MustRegister(gauge)
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	g := NewCachedGatherer(reg, time.Second)
+	g.now = clock.Now
+
+	gauge.Set(1)
+	if v := gaugeValue(t, g, "test_gauge"); v != 1 {
+		t.Fatalf("expected initial value 1, got %v", v)
+	}
+
+	// Within the TTL the cached value is served
+	gauge.Set(2)
+	clock.Advance(999 * time.Millisecond)
+	if v := gaugeValue(t, g, "test_gauge"); v != 1 {
+		t.Errorf("expected cached value 1 within TTL, got %v", v)
+	}
+
+	// Once the TTL has passed the value is fresh
+	clock.Advance(time.Millisecond)
+	if v := gaugeValue(t, g, "test_gauge"); v != 2 {
+		t.Errorf("expected fresh value 2 after TTL, got %v", v)
+	}
+
+	// Invalidation makes the next gather fresh regardless of the TTL
+	gauge.Set(3)
+	g.Invalidate()
+	if v := gaugeValue(t, g, "test_gauge"); v != 3 {
+		t.Errorf("expected fresh value 3 after invalidation, got %v", v)
+	}
+}
+
+func TestCachedGatherer_DisabledByDefault(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge", Help: "test"})
+	reg.MustRegister(gauge)
+
+	g := NewCachedGatherer(reg, 0)
+	for i := 0; i < 3; i++ {
+		gauge.Set(float64(i))
+		if v := gaugeValue(t, g, "test_gauge"); v != float64(i) {
+			t.Errorf("expected uncached value %d, got %v", i, v)
+		}
+	}
+}
+
+func TestUpdateServiceMetrics_InvalidatesGatherCache(t *testing.T) {
+	m := newTestMetrics()
+	m.Gatherer.TTL = time.Hour
+
+	updateServiceMetrics(m, &Config{UpServices: []string{"api-gateway"}})
+	m.Gatherer.Gather()
+
+	updateServiceMetrics(m, &Config{DownServices: []string{"api-gateway"}})
+	mfs, err := m.Gatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "service_monitor_up" {
+			if v := mf.GetMetric()[0].GetGauge().GetValue(); v != 0 {
+				t.Errorf("expected service status update to bypass the cache, got %v", v)
+			}
+			return
+		}
+	}
+	t.Fatal("service_monitor_up not gathered")
+}
+
+func BenchmarkCachedGatherer(b *testing.B) {
+	reg := prometheus.NewRegistry()
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bench_gauge", Help: "bench"}, []string{"service"})
+	reg.MustRegister(vec)
+	for i := 0; i < 1000; i++ {
+		vec.WithLabelValues(fmt.Sprintf("service-%d", i)).Set(1)
+	}
+
+	for _, ttl := range []time.Duration{0, time.Second} {
+		b.Run(fmt.Sprintf("ttl=%s", ttl), func(b *testing.B) {
+			g := NewCachedGatherer(reg, ttl)
+
+			var mu sync.Mutex
+			latencies := make([]time.Duration, 0, b.N)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				local := make([]time.Duration, 0, 128)
+				for pb.Next() {
+					start := time.Now()
+					if _, err := g.Gather(); err != nil {
+						b.Error(err)
+					}
+					local = append(local, time.Since(start))
+				}
+				mu.Lock()
+				latencies = append(latencies, local...)
+				mu.Unlock()
+			})
+			b.StopTimer()
+
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			if len(latencies) > 0 {
+				p99 := latencies[len(latencies)*99/100]
+				b.ReportMetric(float64(p99.Nanoseconds()), "p99-ns")
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"maps"
+	"runtime/debug"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gcPauseBuckets spans GC pauses from 1µs to 100ms
+var gcPauseBuckets = prometheus.ExponentialBucketsRange(1e-6, 0.1, 11)
+
+// gcCollector exposes GC pause durations as a histogram, independent of the
+// go_gc_duration_seconds summary from the standard Go collector
+type gcCollector struct {
+	desc *prometheus.Desc
+
+	mu      sync.Mutex
+	numGC   int64
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+func newGCCollector() *gcCollector {
+	c := &gcCollector{
+		desc: prometheus.NewDesc(
+			"service_monitor_go_gc_duration_histogram_seconds",
+			"Distribution of GC stop-the-world pause durations",
+			nil, nil,
+		),
+		buckets: make(map[float64]uint64, len(gcPauseBuckets)),
+	}
+	for _, bound := range gcPauseBuckets {
+		c.buckets[bound] = 0
+	}
+	return c
+}
+
+func (c *gcCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect folds pauses that happened since the last collection into the histogram
+// debug.ReadGCStats only keeps the most recent pauses, so pauses evicted between
+// two scrapes are not observed
+func (c *gcCollector) Collect(ch chan<- prometheus.Metric) {
+	var stats debug.GCStats
+	debug.ReadGCStats(&stats)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	newPauses := stats.NumGC - c.numGC
+	if newPauses > int64(len(stats.Pause)) {
+		newPauses = int64(len(stats.Pause))
+	}
+
+	// stats.Pause is ordered from most to least recent
+	for _, pause := range stats.Pause[:newPauses] {
+		seconds := pause.Seconds()
+		c.count++
+		c.sum += seconds
+		for _, bound := range gcPauseBuckets {
+			if seconds <= bound {
+				c.buckets[bound]++
+			}
+		}
+	}
+	c.numGC = stats.NumGC
+
+	// The const histogram is encoded after the lock is released, so it gets its own buckets
+	ch <- prometheus.MustNewConstHistogram(c.desc, c.count, c.sum, maps.Clone(c.buckets))
+}
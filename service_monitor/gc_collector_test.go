@@ -0,0 +1,39 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGCCollector_ObservesPauses(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newGCCollector())
+
+	runtime.GC()
+	runtime.GC()
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	if len(mfs) != 1 || mfs[0].GetName() != "service_monitor_go_gc_duration_histogram_seconds" {
+		t.Fatalf("unexpected metric families: %v", mfs)
+	}
+
+	h := mfs[0].GetMetric()[0].GetHistogram()
+	if h.GetSampleCount() < 2 {
+		t.Errorf("expected at least 2 observed pauses, got %d", h.GetSampleCount())
+	}
+	if len(h.GetBucket()) != len(gcPauseBuckets) {
+		t.Errorf("expected %d buckets, got %d", len(gcPauseBuckets), len(h.GetBucket()))
+	}
+
+	// The histogram is cumulative across collections
+	before := h.GetSampleCount()
+	mfs, _ = reg.Gather()
+	if after := mfs[0].GetMetric()[0].GetHistogram().GetSampleCount(); after < before {
+		t.Errorf("expected sample count to never decrease, got %d after %d", after, before)
+	}
+}
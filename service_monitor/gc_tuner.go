@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	gcTunerInterval = 5 * time.Second
+
+	// Bounds and step size for the tuned GOGC value
+	minGCPercent  = 25
+	maxGCPercent  = 400
+	gcPercentStep = 25
+
+	// HeapInuse/Sys ratios above which GC runs less and below which it runs more often
+	gcHighMemoryPressure = 0.8
+	gcLowMemoryPressure  = 0.3
+)
+
+// nextGCPercent returns the GOGC value to use for the given memory pressure
+func nextGCPercent(current int, heapInuse, sys uint64) int {
+	if sys == 0 {
+		return current
+	}
+
+	pressure := float64(heapInuse) / float64(sys)
+	switch {
+	case pressure > gcHighMemoryPressure && current < maxGCPercent:
+		current += gcPercentStep
+	case pressure < gcLowMemoryPressure && current > minGCPercent:
+		current -= gcPercentStep
+	}
+
+	if current > maxGCPercent {
+		current = maxGCPercent
+	}
+	if current < minGCPercent {
+		current = minGCPercent
+	}
+	return current
+}
+
+// runGCTuner periodically adjusts GOGC based on how much of the memory
+// obtained from the OS is in use by the heap
+func runGCTuner(m *Metrics, interval time.Duration) {
+	// SetGCPercent returns the previous value, so restore it immediately
+	current := debug.SetGCPercent(100)
+	debug.SetGCPercent(current)
+	if current < 0 {
+		log.Println("GC is disabled (GOGC=off), not starting the GC tuner")
+		return
+	}
+
+	m.GCTargetPercent.Set(float64(current))
+	log.Printf("Starting GC tuner with GOGC=%d", current)
+
+	var stats runtime.MemStats
+	for {
+		time.Sleep(interval)
+
+		runtime.ReadMemStats(&stats)
+		next := nextGCPercent(current, stats.HeapInuse, stats.Sys)
+		if next != current {
+			debug.SetGCPercent(next)
+			log.Printf("Adjusted GOGC from %d to %d (heap in use %d of %d bytes)", current, next, stats.HeapInuse, stats.Sys)
+			current = next
+		}
+		m.GCTargetPercent.Set(float64(current))
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestNextGCPercent(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   int
+		heapInuse uint64
+		sys       uint64
+		want      int
+	}{
+		{"high pressure increases", 100, 90, 100, 125},
+		{"low pressure decreases", 100, 20, 100, 75},
+		{"moderate pressure keeps", 100, 50, 100, 100},
+		{"capped at maximum", maxGCPercent, 90, 100, maxGCPercent},
+		{"floored at minimum", minGCPercent, 10, 100, minGCPercent},
+		{"no stats keeps", 100, 0, 0, 100},
+	}
+
+	for _, tt := range tests {
+		if got := nextGCPercent(tt.current, tt.heapInuse, tt.sys); got != tt.want {
+			t.Errorf("%s: nextGCPercent(%d, %d, %d) = %d, want %d", tt.name, tt.current, tt.heapInuse, tt.sys, got, tt.want)
+		}
+	}
+}
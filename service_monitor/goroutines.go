@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Timing of the goroutine leak check
+const (
+	goroutineBaselineDelay = 30 * time.Second
+	goroutineCheckInterval = 10 * time.Second
+)
+
+// goroutineMonitor detects goroutine leaks by comparing the goroutine count
+// to a baseline taken once startup has settled
+type goroutineMonitor struct {
+	mu       sync.Mutex
+	baseline int
+	last     int
+	leaking  bool
+}
+
+// observe records a goroutine count; the first count becomes the baseline
+// A leak is reported while the count exceeds twice the baseline and is still growing
+func (g *goroutineMonitor) observe(count int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.baseline == 0 {
+		g.baseline = count
+		g.last = count
+		return
+	}
+
+	leaking := count > 2*g.baseline && count > g.last
+	if leaking && !g.leaking {
+		log.Printf("Possible goroutine leak: %d goroutines, baseline %d", count, g.baseline)
+	}
+	g.leaking = leaking
+	g.last = count
+}
+
+// status returns whether a leak is suspected along with the counts behind it
+func (g *goroutineMonitor) status() (leaking bool, count, baseline int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.leaking, g.last, g.baseline
+}
+
+// runGoroutineMonitor takes the baseline after delay and then samples every interval
+func runGoroutineMonitor(g *goroutineMonitor, delay, interval time.Duration) {
+	time.Sleep(delay)
+	g.observe(runtime.NumGoroutine())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		g.observe(runtime.NumGoroutine())
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGoroutineMonitor(t *testing.T) {
+	g := &goroutineMonitor{}
+
+	steps := []struct {
+		count   int
+		leaking bool
+	}{
+		{10, false}, // baseline
+		{15, false},
+		{20, false}, // exactly twice the baseline
+		{25, true},  // above twice the baseline and growing
+		{30, true},
+		{30, false}, // no longer growing
+		{22, false},
+		{40, true},
+	}
+	for i, step := range steps {
+		g.observe(step.count)
+		if leaking, _, _ := g.status(); leaking != step.leaking {
+			t.Errorf("step %d (%d goroutines): expected leaking=%v", i, step.count, step.leaking)
+		}
+	}
+}
+
+func TestLivenessHandler(t *testing.T) {
+	g := &goroutineMonitor{}
+	check := func() int {
+		rec := httptest.NewRecorder()
+		livenessHandler(g)(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		return rec.Code
+	}
+
+	// Healthy before the baseline is taken
+	if code := check(); code != http.StatusOK {
+		t.Errorf("expected 200 without a baseline, got %d", code)
+	}
+
+	g.observe(10)
+	g.observe(21)
+	if code := check(); code != http.StatusInternalServerError {
+		t.Errorf("expected 500 while leaking, got %d", code)
+	}
+
+	g.observe(21)
+	if code := check(); code != http.StatusOK {
+		t.Errorf("expected 200 once growth stops, got %d", code)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// healthCheckTimeout bounds all [health_checks] requests of one /health call
+	healthCheckTimeout = 3 * time.Second
+
+	// defaultHealthCheckCacheTTL is how long check results are reused
+	defaultHealthCheckCacheTTL = 5 * time.Second
+)
+
+// HealthChecksConfig lists URLs that must answer 2xx for /health to pass
+type HealthChecksConfig struct {
+	URLs []string `toml:"urls"`
+}
+
+// problems returns a description of every invalid health check URL
+func (c HealthChecksConfig) problems() []string {
+	var problems []string
+	for _, rawURL := range c.URLs {
+		if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("invalid health check url %q", rawURL))
+		}
+	}
+	return problems
+}
+
+// HealthCheckCache runs the [health_checks] requests and reuses their
+// outcome for TTL, so frequent /health calls don't hit the URLs every time
+type HealthCheckCache struct {
+	TTL    time.Duration
+	client *http.Client
+
+	// now returns the current time; replaced in tests
+	now func() time.Time
+
+	// Held while checks run, so concurrent callers wait for one run
+	mu        sync.Mutex
+	urls      []string
+	failed    []string
+	checkedAt time.Time
+}
+
+// NewHealthCheckCache creates a cache reusing results for ttl
+func NewHealthCheckCache(ttl time.Duration) *HealthCheckCache {
+	return &HealthCheckCache{TTL: ttl, client: &http.Client{}, now: time.Now}
+}
+
+// failedChecks returns the URLs that didn't answer 2xx, in config order
+// Results are reused while younger than TTL and the URLs are unchanged
+func (c *HealthCheckCache) failedChecks(m *Metrics, urls []string) []string {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.Equal(urls, c.urls) && c.now().Sub(c.checkedAt) < c.TTL {
+		return c.failed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	ok := make([]bool, len(urls))
+	var g errgroup.Group
+	for i, rawURL := range urls {
+		i, rawURL := i, rawURL
+		g.Go(func() error {
+			start := time.Now()
+			ok[i] = c.check(ctx, rawURL)
+			m.HealthCheckDuration.WithLabelValues(rawURL).Observe(time.Since(start).Seconds())
+			return nil
+		})
+	}
+	g.Wait()
+
+	var failed []string
+	for i, rawURL := range urls {
+		if !ok[i] {
+			failed = append(failed, rawURL)
+		}
+	}
+	c.urls, c.failed, c.checkedAt = slices.Clone(urls), failed, c.now()
+	return failed
+}
+
+// check reports whether rawURL answers GET with a 2xx status
+func (c *HealthCheckCache) check(ctx context.Context, rawURL string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// healthResponse is the JSON body /health returns when checks fail
+type healthResponse struct {
+	Status       string   `json:"status"`
+	FailedChecks []string `json:"failed_checks"`
+}
+
+// healthHandler reports that the process is up and serving requests, and
+// that every URL in [health_checks] answers 2xx
+func healthHandler(m *Metrics, cfg *ServerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var urls []string
+		if config := loadCurrentConfig(); config != nil {
+			urls = config.HealthChecks.URLs
+		}
+
+		if failed := cfg.healthCheckCache().failedChecks(m, urls); len(failed) > 0 {
+			writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "degraded", FailedChecks: failed})
+			return
+		}
+		w.Write([]byte("OK"))
+	}
+}
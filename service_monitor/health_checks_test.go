@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestHealthHandler_Checks(t *testing.T) {
+	var healthyCalls atomic.Int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyCalls.Add(1)
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	setTestServices(t, []string{"api"}, nil)
+	config := loadCurrentConfig().clone()
+	config.HealthChecks.URLs = []string{healthy.URL, failing.URL}
+	currentConfig.Store(config)
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{HealthCheckCacheTTL: time.Hour}
+	mux := NewAppServeMux(m, cfg)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp healthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if resp.Status != "degraded" || len(resp.FailedChecks) != 1 || resp.FailedChecks[0] != failing.URL {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	for _, url := range []string{healthy.URL, failing.URL} {
+		var metric dto.Metric
+		if err := m.HealthCheckDuration.WithLabelValues(url).(prometheus.Metric).Write(&metric); err != nil {
+			t.Fatalf("failed to read histogram: %v", err)
+		}
+		if got := metric.GetHistogram().GetSampleCount(); got != 1 {
+			t.Errorf("expected 1 duration observation for %s, got %d", url, got)
+		}
+	}
+
+	// Cached results are reused
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable || healthyCalls.Load() != 1 {
+		t.Errorf("expected a cached 503 without new requests, got %d after %d calls", rec.Code, healthyCalls.Load())
+	}
+
+	// Changed URLs are checked right away
+	config = config.clone()
+	config.HealthChecks.URLs = []string{healthy.URL}
+	currentConfig.Store(config)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "OK" {
+		t.Errorf("expected 200 OK, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHealthCheckCache_Expiry(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	cache := NewHealthCheckCache(5 * time.Second)
+	cache.now = func() time.Time { return now }
+	m := newTestMetrics()
+
+	cache.failedChecks(m, []string{srv.URL})
+	now = now.Add(4 * time.Second)
+	cache.failedChecks(m, []string{srv.URL})
+	if got := calls.Load(); got != 1 {
+		t.Errorf("expected 1 request within the TTL, got %d", got)
+	}
+	now = now.Add(2 * time.Second)
+	cache.failedChecks(m, []string{srv.URL})
+	if got := calls.Load(); got != 2 {
+		t.Errorf("expected a new request after the TTL, got %d", got)
+	}
+}
+
+func TestHealthChecksConfig_Problems(t *testing.T) {
+	c := HealthChecksConfig{URLs: []string{"http://db:8080/health", "ftp://db", "not a url"}}
+	if got := c.problems(); len(got) != 2 {
+		t.Errorf("expected 2 problems, got %v", got)
+	}
+}
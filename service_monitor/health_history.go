@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultHealthCheckHistorySize is the number of health check results kept
+// per service unless PROBE_HISTORY_SIZE says otherwise
+const defaultHealthCheckHistorySize = 100
+
+// healthCheckResult is one run of the health check configured in [probes]
+type healthCheckResult struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Status       int       `json:"status"`
+	LatencyMs    float64   `json:"latency_ms"`
+	ErrorMessage string    `json:"error_message,omitempty"`
+}
+
+func healthCheckResultTime(r healthCheckResult) time.Time { return r.Timestamp }
+
+// HealthCheckHistory keeps the most recent health check results of every
+// service with a probe target
+type HealthCheckHistory struct {
+	mu       sync.Mutex
+	size     int
+	services map[string]*RingBuffer[healthCheckResult]
+}
+
+// NewHealthCheckHistory creates a history keeping size results per service
+func NewHealthCheckHistory(size int) *HealthCheckHistory {
+	return &HealthCheckHistory{size: size, services: make(map[string]*RingBuffer[healthCheckResult])}
+}
+
+// record appends the outcome of a health check of service; once the
+// service's buffer is full each result replaces the oldest one
+func (h *HealthCheckHistory) record(m *Metrics, service string, resp probeResponse, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buf, ok := h.services[service]
+	if !ok {
+		buf = NewRingBuffer(h.size, healthCheckResultTime)
+		h.services[service] = buf
+	}
+	before := buf.Len()
+	buf.Push(healthCheckResult{
+		Timestamp:    now,
+		Status:       resp.Status,
+		LatencyMs:    resp.DurationMs,
+		ErrorMessage: resp.Error,
+	})
+	m.ProbeHistoryEntries.Add(float64(buf.Len() - before))
+}
+
+// remove drops the history of a service that is no longer monitored
+func (h *HealthCheckHistory) remove(m *Metrics, service string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if buf, ok := h.services[service]; ok {
+		m.ProbeHistoryEntries.Sub(float64(buf.Len()))
+		delete(h.services, service)
+	}
+}
+
+// results returns all kept results of service, oldest first, and whether
+// the service has been checked
+func (h *HealthCheckHistory) results(service string) ([]healthCheckResult, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buf, ok := h.services[service]
+	if !ok {
+		return nil, false
+	}
+	return buf.Slice(0), true
+}
+
+// latencyPercentiles are the rolling latencies returned by /services/<name>/probe-history
+type latencyPercentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// computeLatencyPercentiles returns the nearest-rank percentiles of the
+// latencies of results
+func computeLatencyPercentiles(results []healthCheckResult) latencyPercentiles {
+	if len(results) == 0 {
+		return latencyPercentiles{}
+	}
+	latencies := make([]float64, len(results))
+	for i, r := range results {
+		latencies[i] = r.LatencyMs
+	}
+	sort.Float64s(latencies)
+
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(latencies)))) - 1
+		return latencies[max(i, 0)]
+	}
+	return latencyPercentiles{P50: rank(0.50), P90: rank(0.90), P99: rank(0.99)}
+}
+
+// probeHistoryResponse is the JSON body returned by /services/<name>/probe-history
+type probeHistoryResponse struct {
+	Service   string              `json:"service"`
+	LatencyMs latencyPercentiles  `json:"latency_ms"`
+	Results   []healthCheckResult `json:"results"`
+}
+
+// probeHistoryHandler serves the health check results of the service named
+// in /services/<name>/probe-history with their latency percentiles
+func probeHistoryHandler(cfg *ServerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		service, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/services/"), "/probe-history")
+		if !ok || service == "" || strings.Contains(service, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if cfg.SLOTracker == nil {
+			http.Error(w, "Probes are not running", http.StatusServiceUnavailable)
+			return
+		}
+		results, ok := cfg.SLOTracker.healthChecks.results(service)
+		if !ok {
+			http.Error(w, "No health checks recorded for service", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, probeHistoryResponse{
+			Service:   service,
+			LatencyMs: computeLatencyPercentiles(results),
+			Results:   results,
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestComputeLatencyPercentiles(t *testing.T) {
+	var results []healthCheckResult
+	for i := 100; i >= 1; i-- {
+		results = append(results, healthCheckResult{LatencyMs: float64(i)})
+	}
+
+	got := computeLatencyPercentiles(results)
+	if want := (latencyPercentiles{P50: 50, P90: 90, P99: 99}); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if got := computeLatencyPercentiles(results[:1]); got.P50 != 100 || got.P99 != 100 {
+		t.Errorf("expected a single result to be every percentile, got %+v", got)
+	}
+	if got := computeLatencyPercentiles(nil); got != (latencyPercentiles{}) {
+		t.Errorf("expected zero percentiles without results, got %+v", got)
+	}
+}
+
+func TestHealthCheckHistory_Size(t *testing.T) {
+	m := newTestMetrics()
+	h := NewHealthCheckHistory(3)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		h.record(m, "api", probeResponse{Status: 1, DurationMs: float64(i)}, start.Add(time.Duration(i)*time.Second))
+	}
+	h.record(m, "auth", probeResponse{Status: 1}, start)
+
+	results, ok := h.results("api")
+	if !ok || len(results) != 3 || results[0].LatencyMs != 2 || results[2].LatencyMs != 4 {
+		t.Errorf("expected the 3 latest results oldest first, got %+v", results)
+	}
+
+	// Evicted results don't count, so a full buffer keeps the total constant
+	if v := testutil.ToFloat64(m.ProbeHistoryEntries); v != 4 {
+		t.Errorf("expected 4 history entries, got %v", v)
+	}
+
+	h.remove(m, "api")
+	h.remove(m, "unknown")
+	if _, ok := h.results("api"); ok {
+		t.Error("expected the history to be dropped")
+	}
+	if v := testutil.ToFloat64(m.ProbeHistoryEntries); v != 1 {
+		t.Errorf("expected 1 history entry after removing a service, got %v", v)
+	}
+}
+
+func TestProbeHistoryHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+
+	noProbeJitter(t)
+	m := newTestMetrics()
+	tracker := NewSLOTracker(10, defaultHistoryDepth)
+	q := newProbeQueue(10, m.ProbeCoalesced)
+	schedule := newProbeSchedule(time.Minute)
+	config := &Config{
+		UpServices:   []string{"api-gateway", "auth-service"},
+		DownServices: []string{"user-service"},
+		Probes: map[string]ProbeTarget{
+			"api-gateway":  {URL: upstream.URL + "/healthz"},
+			"user-service": {URL: upstream.URL + "/broken"},
+		},
+	}
+
+	// Three rounds of probes, each run by the worker before the next
+	ctx := probeTestContext(t)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		scheduleProbes(ctx, m, tracker, q, schedule, config, start.Add(time.Duration(i)*time.Minute))
+		for len(q.jobs) > 0 {
+			job, _ := q.next()
+			runProbeJob(m, tracker, job)
+		}
+	}
+
+	if v := testutil.ToFloat64(m.ProbeHistoryEntries); v != 6 {
+		t.Errorf("expected 6 history entries for the 2 checked services, got %v", v)
+	}
+
+	mux := NewAppServeMux(m, &ServerConfig{SLOTracker: tracker})
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/services/api-gateway/probe-history")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp probeHistoryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if resp.Service != "api-gateway" || len(resp.Results) != 3 {
+		t.Fatalf("expected 3 results for api-gateway, got %+v", resp)
+	}
+	for _, r := range resp.Results {
+		if r.Status != 1 || r.LatencyMs <= 0 || r.ErrorMessage != "" {
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+	if resp.LatencyMs.P50 <= 0 || resp.LatencyMs.P50 > resp.LatencyMs.P90 || resp.LatencyMs.P90 > resp.LatencyMs.P99 {
+		t.Errorf("unexpected latency percentiles %+v", resp.LatencyMs)
+	}
+
+	rec = get("/services/user-service/probe-history")
+	resp = probeHistoryResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(resp.Results) != 3 || resp.Results[0].Status != 0 || resp.Results[0].ErrorMessage == "" {
+		t.Errorf("expected failed checks with an error message, got %+v", resp.Results)
+	}
+
+	// Services without a probe target are not health checked
+	for _, path := range []string{"/services/auth-service/probe-history", "/services/api-gateway", "/services/a/b/probe-history"} {
+		if rec := get(path); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/services/api-gateway/probe-history", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for POST, got %d", rec.Code)
+	}
+}
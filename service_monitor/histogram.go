@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Histogram schemas selectable with histogram_schema
+const (
+	histogramSchemaClassic = "classic"
+	histogramSchemaNative  = "native"
+	histogramSchemaBoth    = "both"
+)
+
+const (
+	// defaultNativeHistogramBucketFactor lets each bucket grow by at most 10%
+	defaultNativeHistogramBucketFactor = 1.1
+
+	// Bounds on the number of native buckets and how often they may be reset
+	nativeHistogramMaxBucketNumber  = 160
+	nativeHistogramMinResetDuration = time.Hour
+)
+
+// HistogramConfig selects the bucket layout of the request duration histogram
+type HistogramConfig struct {
+	// "classic" fixed buckets (default), "native" exponential buckets or "both"
+	Schema string `toml:"histogram_schema"`
+
+	// Upper bound on the growth factor between native buckets, greater than 1
+	NativeBucketFactor float64 `toml:"native_histogram_bucket_factor"`
+}
+
+// schema returns the configured schema or the classic default
+func (c HistogramConfig) schema() string {
+	if c.Schema == "" {
+		return histogramSchemaClassic
+	}
+	return c.Schema
+}
+
+// nativeBucketFactor returns the configured factor or the default
+func (c HistogramConfig) nativeBucketFactor() float64 {
+	if c.NativeBucketFactor <= 1 {
+		return defaultNativeHistogramBucketFactor
+	}
+	return c.NativeBucketFactor
+}
+
+// problems lists the reasons the histogram config is invalid
+func (c HistogramConfig) problems() []string {
+	switch c.schema() {
+	case histogramSchemaClassic, histogramSchemaNative, histogramSchemaBoth:
+		return nil
+	default:
+		return []string{fmt.Sprintf("invalid histogram_schema %q, must be classic, native or both", c.Schema)}
+	}
+}
+
+// buildHistogramOpts returns the options of the request duration histogram
+// Native buckets are only exposed in the protobuf format, so with "both"
+// text and OpenMetrics scrapers keep getting the classic buckets
+func buildHistogramOpts(cfg HistogramConfig) prometheus.HistogramOpts {
+	opts := prometheus.HistogramOpts{
+		Name: "service_monitor_request_duration_seconds",
+		Help: "Request duration distribution",
+	}
+
+	schema := cfg.schema()
+	if schema != histogramSchemaNative {
+		opts.Buckets = prometheus.LinearBuckets(0.01, 0.05, 10)
+	}
+	if schema != histogramSchemaClassic {
+		opts.NativeHistogramBucketFactor = cfg.nativeBucketFactor()
+		opts.NativeHistogramMaxBucketNumber = nativeHistogramMaxBucketNumber
+		opts.NativeHistogramMinResetDuration = nativeHistogramMinResetDuration
+	}
+	return opts
+}
+
+// ConfigureRequestDuration replaces the request duration histogram with one
+// using the schema from cfg; observations so far are dropped
+// It must be called before the handlers start serving
+func (m *Metrics) ConfigureRequestDuration(cfg HistogramConfig) error {
+	fresh := prometheus.NewHistogram(buildHistogramOpts(cfg))
+	return swapRegistered(m.Registry, m.RequestDuration, fresh, func() { m.RequestDuration = fresh })
+}
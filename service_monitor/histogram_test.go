@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestLoadConfig_HistogramSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := "up_services = []\nhistogram_schema = \"both\"\nnative_histogram_bucket_factor = 1.05\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadConfig(RealFileSystem{}, path, newTestMetrics().ConfigBytesRead)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.HistogramConfig.schema() != histogramSchemaBoth || config.HistogramConfig.nativeBucketFactor() != 1.05 {
+		t.Errorf("unexpected histogram config: %+v", config.HistogramConfig)
+	}
+
+	if err := os.WriteFile(path, []byte("histogram_schema = \"sparse\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(RealFileSystem{}, path, newTestMetrics().ConfigBytesRead); err == nil || !strings.Contains(err.Error(), "histogram_schema") {
+		t.Errorf("expected an invalid histogram_schema error, got %v", err)
+	}
+}
+
+func TestBuildHistogramOpts(t *testing.T) {
+	tests := []struct {
+		schema      string
+		wantBuckets bool
+		wantNative  bool
+	}{
+		{"", true, false},
+		{histogramSchemaClassic, true, false},
+		{histogramSchemaNative, false, true},
+		{histogramSchemaBoth, true, true},
+	}
+
+	for _, tt := range tests {
+		opts := buildHistogramOpts(HistogramConfig{Schema: tt.schema})
+		if got := len(opts.Buckets) > 0; got != tt.wantBuckets {
+			t.Errorf("schema %q: expected classic buckets %v, got %v", tt.schema, tt.wantBuckets, opts.Buckets)
+		}
+		if got := opts.NativeHistogramBucketFactor > 1; got != tt.wantNative {
+			t.Errorf("schema %q: expected native buckets %v, got factor %v", tt.schema, tt.wantNative, opts.NativeHistogramBucketFactor)
+		}
+	}
+}
+
+// scrapeAs scrapes /metrics with the given Accept header
+func scrapeAs(t *testing.T, mux http.Handler, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", accept)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scrape with Accept %q failed with status %d", accept, rec.Code)
+	}
+	return rec
+}
+
+// requestDurationFamily decodes the request duration histogram from a protobuf scrape
+func requestDurationFamily(t *testing.T, rec *httptest.ResponseRecorder) *dto.MetricFamily {
+	t.Helper()
+	decoder := expfmt.NewDecoder(rec.Body, expfmt.ResponseFormat(rec.Header()))
+	for {
+		var mf dto.MetricFamily
+		if err := decoder.Decode(&mf); err != nil {
+			t.Fatalf("request duration histogram not found: %v", err)
+		}
+		if mf.GetName() == "service_monitor_request_duration_seconds" {
+			return &mf
+		}
+	}
+}
+
+func TestRequestDurationSchema_Scrape(t *testing.T) {
+	const (
+		openMetrics = "application/openmetrics-text; version=1.0.0"
+		textPlain   = "text/plain; version=0.0.4"
+		protobuf    = "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited"
+	)
+	classicBucket := `service_monitor_request_duration_seconds_bucket{le="0.26"}`
+
+	for _, tt := range []struct {
+		schema      string
+		wantClassic bool
+		wantNative  bool
+	}{
+		{histogramSchemaClassic, true, false},
+		{histogramSchemaNative, false, true},
+		{histogramSchemaBoth, true, true},
+	} {
+		t.Run(tt.schema, func(t *testing.T) {
+			m := newTestMetrics()
+			if err := m.ConfigureRequestDuration(HistogramConfig{Schema: tt.schema}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, v := range []float64{0.02, 0.1, 0.3} {
+				m.RequestDuration.Observe(v)
+			}
+			mux := NewMetricsServeMux(m, &ServerConfig{})
+
+			for _, accept := range []string{openMetrics, textPlain} {
+				rec := scrapeAs(t, mux, accept)
+				wantType := strings.SplitN(accept, ";", 2)[0]
+				if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, wantType) {
+					t.Errorf("Accept %q: expected Content-Type %s, got %q", accept, wantType, got)
+				}
+				body := rec.Body.String()
+				if got := strings.Contains(body, classicBucket); got != tt.wantClassic {
+					t.Errorf("Accept %q: expected classic buckets %v, got:\n%s", accept, tt.wantClassic, body)
+				}
+				if !strings.Contains(body, "service_monitor_request_duration_seconds_count 3") {
+					t.Errorf("Accept %q: expected 3 observations, got:\n%s", accept, body)
+				}
+				if accept == openMetrics && !strings.HasSuffix(body, "# EOF\n") {
+					t.Errorf("expected an OpenMetrics body terminated by # EOF")
+				}
+			}
+
+			h := requestDurationFamily(t, scrapeAs(t, mux, protobuf)).GetMetric()[0].GetHistogram()
+			if got := len(h.GetBucket()) > 0; got != tt.wantClassic {
+				t.Errorf("protobuf: expected classic buckets %v, got %d", tt.wantClassic, len(h.GetBucket()))
+			}
+			if got := len(h.GetPositiveSpan()) > 0; got != tt.wantNative {
+				t.Errorf("protobuf: expected native buckets %v, got spans %v", tt.wantNative, h.GetPositiveSpan())
+			}
+		})
+	}
+}
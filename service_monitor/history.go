@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHistoryDepth is the number of probe results kept per service
+	defaultHistoryDepth = 1000
+
+	// defaultHistoryLimit is the number of results /history returns by default
+	defaultHistoryLimit = 100
+)
+
+// probeResult is one probe outcome of a service
+type probeResult struct {
+	Timestamp time.Time `json:"timestamp"`
+	Status    int       `json:"status"`
+}
+
+func probeResultTime(r probeResult) time.Time { return r.Timestamp }
+
+// ProbeHistory keeps the most recent probe results of every service
+type ProbeHistory struct {
+	mu       sync.Mutex
+	depth    int
+	services map[string]*RingBuffer[probeResult]
+}
+
+// NewProbeHistory creates a history keeping depth results per service
+func NewProbeHistory(depth int) *ProbeHistory {
+	return &ProbeHistory{depth: depth, services: make(map[string]*RingBuffer[probeResult])}
+}
+
+// record appends a probe result for service
+func (h *ProbeHistory) record(service string, up bool, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buf, ok := h.services[service]
+	if !ok {
+		buf = NewRingBuffer(h.depth, probeResultTime)
+		h.services[service] = buf
+	}
+	status := 0
+	if up {
+		status = 1
+	}
+	buf.Push(probeResult{Timestamp: now, Status: status})
+}
+
+// remove drops the history of a service that is no longer monitored
+func (h *ProbeHistory) remove(service string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.services, service)
+}
+
+// results returns up to limit of the latest results of service at or after
+// since, oldest first, and whether the service has a history
+func (h *ProbeHistory) results(service string, since time.Time, limit int) ([]probeResult, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buf, ok := h.services[service]
+	if !ok {
+		return nil, false
+	}
+	results := buf.Filter(since)
+	if len(results) > limit {
+		results = results[len(results)-limit:]
+	}
+	return results, true
+}
+
+// historyResponse is the JSON body returned by /history/<service>
+type historyResponse struct {
+	Service string        `json:"service"`
+	Results []probeResult `json:"results"`
+}
+
+// historyHandler serves the recent probe results of the service named by the
+// rest of the path, filtered by the limit and since (unix seconds) query parameters
+func historyHandler(cfg *ServerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		service := strings.TrimPrefix(r.URL.Path, "/history/")
+		if service == "" {
+			http.Error(w, "service name required", http.StatusBadRequest)
+			return
+		}
+
+		query := r.URL.Query()
+		limit, err := positiveQueryInt(query.Get("limit"), defaultHistoryLimit)
+		if err != nil {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		var since time.Time
+		if value := query.Get("since"); value != "" {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				http.Error(w, "since must be a unix timestamp", http.StatusBadRequest)
+				return
+			}
+			since = time.Unix(seconds, 0)
+		}
+
+		if cfg.SLOTracker == nil {
+			http.Error(w, "Probes are not running", http.StatusServiceUnavailable)
+			return
+		}
+		results, ok := cfg.SLOTracker.history.results(service, since, limit)
+		if !ok {
+			http.Error(w, "Unknown service", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, historyResponse{Service: service, Results: results})
+	}
+}
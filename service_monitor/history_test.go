@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	tracker := NewSLOTracker(10, 5)
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 8; i++ {
+		tracker.history.record("api-gateway", i%2 == 0, start.Add(time.Duration(i)*time.Minute))
+	}
+	tracker.history.record("default/auth", true, start)
+	mux := NewAppServeMux(newTestMetrics(), &ServerConfig{SLOTracker: tracker})
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	tests := []struct {
+		target  string
+		want    []int
+		minutes []int
+	}{
+		// Only the last 5 of the 8 results are kept
+		{"/history/api-gateway", []int{0, 1, 0, 1, 0}, []int{3, 4, 5, 6, 7}},
+		{"/history/api-gateway?limit=2", []int{1, 0}, []int{6, 7}},
+		{"/history/api-gateway?since=1700000300", []int{0, 1, 0}, []int{5, 6, 7}},
+		{"/history/api-gateway?since=1700000300&limit=1", []int{0}, []int{7}},
+		{"/history/api-gateway?since=1800000000", []int{}, []int{}},
+		{"/history/default/auth", []int{1}, []int{0}},
+	}
+
+	for _, tt := range tests {
+		rec := get(tt.target)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d: %s", tt.target, rec.Code, rec.Body.String())
+			continue
+		}
+		var resp historyResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: invalid JSON: %v", tt.target, err)
+		}
+		if len(resp.Results) != len(tt.want) {
+			t.Errorf("%s: expected %d results, got %+v", tt.target, len(tt.want), resp.Results)
+			continue
+		}
+		for i, result := range resp.Results {
+			wantTime := start.Add(time.Duration(tt.minutes[i]) * time.Minute)
+			if result.Status != tt.want[i] || !result.Timestamp.Equal(wantTime) {
+				t.Errorf("%s: result %d = %+v, want status %d at %v", tt.target, i, result, tt.want[i], wantTime)
+			}
+		}
+	}
+
+	for target, want := range map[string]int{
+		"/history/unknown":                 http.StatusNotFound,
+		"/history/":                        http.StatusBadRequest,
+		"/history/api-gateway?limit=0":     http.StatusBadRequest,
+		"/history/api-gateway?since=today": http.StatusBadRequest,
+	} {
+		if rec := get(target); rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", target, want, rec.Code)
+		}
+	}
+}
+
+func TestProbeServices_RecordsHistory(t *testing.T) {
+	m := newTestMetrics()
+	tracker := NewSLOTracker(10, 10)
+
+	probeServices(m, tracker, &Config{UpServices: []string{"a"}, DownServices: []string{"b"}})
+	probeServices(m, tracker, &Config{UpServices: []string{"a", "b"}})
+
+	results, ok := tracker.history.results("b", time.Time{}, 10)
+	if !ok || len(results) != 2 || results[0].Status != 0 || results[1].Status != 1 {
+		t.Errorf("expected b to go from down to up, got %+v", results)
+	}
+
+	// Removed services lose their history with their SLO window
+	probeServices(m, tracker, &Config{UpServices: []string{"a"}})
+	if _, ok := tracker.history.results("b", time.Time{}, 10); ok {
+		t.Error("expected the history of a removed service to be dropped")
+	}
+}
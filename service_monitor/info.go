@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Build metadata, set with -ldflags "-X main.version=... -X main.gitCommit=... -X main.buildDate=..."
+// The commit and date fall back to the VCS info Go embeds in the binary
+var (
+	version   = "dev"
+	gitCommit string
+	buildDate string
+)
+
+// processStartTime is when the service monitor started
+var processStartTime = time.Now()
+
+// Where the service list comes from, reported by /info
+const (
+	configSourceFile       = "file"
+	configSourceRemote     = "remote"
+	configSourceKubernetes = "kubernetes"
+	configSourceInline     = "inline"
+)
+
+// infoResponse is the JSON body returned by /info
+type infoResponse struct {
+	Version        string    `json:"version"`
+	GitCommit      string    `json:"git_commit"`
+	BuildDate      string    `json:"build_date"`
+	GoVersion      string    `json:"go_version"`
+	GOOS           string    `json:"goos"`
+	GOARCH         string    `json:"goarch"`
+	Hostname       string    `json:"hostname"`
+	StartTime      time.Time `json:"start_time"`
+	ConfigPath     string    `json:"config_path"`
+	ListenAddr     string    `json:"listen_addr"`
+	MetricsAddr    string    `json:"metrics_addr"`
+	ActiveBackends []string  `json:"active_backends"`
+}
+
+// buildMetadata returns the commit and build date, preferring the values
+// set at link time over the VCS info embedded by go build
+func buildMetadata() (commit, date string) {
+	commit, date = gitCommit, buildDate
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return commit, date
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && commit == "":
+			commit = setting.Value
+		case setting.Key == "vcs.time" && date == "":
+			date = setting.Value
+		}
+	}
+	return commit, date
+}
+
+// activeBackends lists the config source and the enabled integrations
+func activeBackends(cfg *ServerConfig) []string {
+	source := cfg.ConfigSource
+	if source == "" {
+		source = configSourceFile
+	}
+	backends := []string{source}
+	if cfg.StatsD != nil {
+		backends = append(backends, "statsd")
+	}
+	if cfg.EventLog != nil {
+		backends = append(backends, "event_log")
+	}
+	if cfg.StateExportPath != "" {
+		backends = append(backends, "state_export")
+	}
+	return backends
+}
+
+// infoHandler describes the running instance for fleet inventories
+func infoHandler(cfg *ServerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		hostname, _ := os.Hostname()
+		commit, date := buildMetadata()
+		writeJSON(w, http.StatusOK, infoResponse{
+			Version:        version,
+			GitCommit:      commit,
+			BuildDate:      date,
+			GoVersion:      runtime.Version(),
+			GOOS:           runtime.GOOS,
+			GOARCH:         runtime.GOARCH,
+			Hostname:       hostname,
+			StartTime:      processStartTime,
+			ConfigPath:     cfg.ConfigPath,
+			ListenAddr:     cfg.AppAddr,
+			MetricsAddr:    cfg.MetricsAddr,
+			ActiveBackends: activeBackends(cfg),
+		})
+	}
+}
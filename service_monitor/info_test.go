@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestInfoHandler(t *testing.T) {
+	origVersion, origCommit, origDate := version, gitCommit, buildDate
+	version, gitCommit, buildDate = "1.2.3", "abc123", "2024-05-01T12:00:00Z"
+	t.Cleanup(func() { version, gitCommit, buildDate = origVersion, origCommit, origDate })
+
+	cfg := &ServerConfig{
+		ConfigPath:      "/app/config/config.toml",
+		ConfigSource:    configSourceRemote,
+		AppAddr:         ":8080",
+		MetricsAddr:     ":9090",
+		StateExportPath: "/tmp/state.json",
+	}
+	mux := NewAppServeMux(newTestMetrics(), cfg)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/info", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var info infoResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if info.Version != "1.2.3" || info.GitCommit != "abc123" || info.BuildDate != "2024-05-01T12:00:00Z" {
+		t.Errorf("unexpected build metadata: %+v", info)
+	}
+	if info.GoVersion != runtime.Version() || info.GOOS != runtime.GOOS || info.GOARCH != runtime.GOARCH {
+		t.Errorf("unexpected runtime metadata: %+v", info)
+	}
+	if info.ConfigPath != cfg.ConfigPath || info.ListenAddr != ":8080" || info.MetricsAddr != ":9090" {
+		t.Errorf("unexpected deployment metadata: %+v", info)
+	}
+	if !info.StartTime.Equal(processStartTime) {
+		t.Errorf("expected start time %v, got %v", processStartTime, info.StartTime)
+	}
+	if len(info.ActiveBackends) != 2 || info.ActiveBackends[0] != "remote" || info.ActiveBackends[1] != "state_export" {
+		t.Errorf("unexpected active backends: %v", info.ActiveBackends)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/info", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", rec.Code)
+	}
+}
+
+func TestActiveBackends_DefaultsToFile(t *testing.T) {
+	if got := activeBackends(&ServerConfig{}); len(got) != 1 || got[0] != configSourceFile {
+		t.Errorf("expected [file], got %v", got)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultInlineConfigPollInterval is how often CONFIG_INLINE is checked for changes
+const defaultInlineConfigPollInterval = 30 * time.Second
+
+// Formats CONFIG_INLINE_FORMAT accepts
+var inlineConfigFormats = map[string]bool{"toml": true, "json": true, "yaml": true}
+
+// parseConfigAs parses and validates a config in the given format
+// JSON and YAML use the same keys as the TOML file; they are converted to
+// TOML so every format goes through parseConfig
+func parseConfigAs(data []byte, format string) (*Config, error) {
+	switch format {
+	case "toml":
+		return parseConfig(data)
+	case "yaml":
+		converted, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, &invalidConfigError{fmt.Errorf("error parsing YAML config: %w", err)}
+		}
+		data = converted
+		fallthrough
+	case "json":
+		converted, err := jsonToTOML(data)
+		if err != nil {
+			return nil, &invalidConfigError{fmt.Errorf("error parsing %s config: %w", format, err)}
+		}
+		return parseConfig(converted)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+// jsonToTOML re-encodes a JSON object as TOML, keeping integers integers
+func jsonToTOML(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc map[string]any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return toml.Marshal(normalizeJSONValue(doc))
+}
+
+// normalizeJSONValue converts json.Numbers to int64 or float64 and drops
+// nulls, which TOML can't represent
+func normalizeJSONValue(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, value := range v {
+			if value == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = normalizeJSONValue(value)
+		}
+		return v
+	case []any:
+		for i, value := range v {
+			v[i] = normalizeJSONValue(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// InlineConfigSource reads the config from the base64-encoded CONFIG_INLINE
+// environment variable instead of a file
+type InlineConfigSource struct {
+	Format   string
+	Interval time.Duration
+
+	// getenv reads the variable; replaced in tests
+	getenv func(string) string
+
+	// Value of the variable last loaded, only used by the polling goroutine
+	// after the initial load
+	last string
+}
+
+// NewInlineConfigSource creates a source reading CONFIG_INLINE in format
+func NewInlineConfigSource(format string, interval time.Duration) *InlineConfigSource {
+	return &InlineConfigSource{Format: format, Interval: interval, getenv: os.Getenv}
+}
+
+// load decodes and parses the current value of CONFIG_INLINE
+func (s *InlineConfigSource) load() (*Config, error) {
+	s.last = s.getenv("CONFIG_INLINE")
+	data, err := decodeBase64Config(s.last)
+	if err != nil {
+		return nil, &invalidConfigError{err}
+	}
+	return parseConfigAs(data, s.Format)
+}
+
+// pollAndApply applies CONFIG_INLINE if it changed since the last load
+// An invalid value is reported once and the last config stays applied
+func (s *InlineConfigSource) pollAndApply(m *Metrics, cfg *ServerConfig) error {
+	if s.getenv("CONFIG_INLINE") == s.last {
+		return nil
+	}
+	config, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if computeConfigETag(config) == loadConfigETag() {
+		return nil
+	}
+	applyConfig(m, cfg, config, loadTriggerInline)
+	log.Printf("Applied config from CONFIG_INLINE: %d up services and %d down services",
+		len(config.UpServices), len(config.DownServices))
+	return nil
+}
+
+// run polls CONFIG_INLINE every interval until ctx is cancelled
+func (s *InlineConfigSource) run(ctx context.Context, m *Metrics, cfg *ServerConfig) {
+	log.Printf("Checking CONFIG_INLINE for changes every %s", s.Interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.Interval):
+		}
+
+		_, err := cfg.awaitReload(ctx, loadTriggerInline, func() (*Config, error) {
+			return nil, s.pollAndApply(m, cfg)
+		})
+		if err != nil {
+			log.Printf("Error loading CONFIG_INLINE, keeping the last config: %v", err)
+		}
+	}
+}
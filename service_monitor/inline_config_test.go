@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigAs(t *testing.T) {
+	inputs := map[string]string{
+		"toml": "up_services = [\"api\"]\ndown_services = [\"db\"]\nconfig_history_depth = 5\n\n[service_labels.api]\nteam = \"platform\"\n",
+		"json": `{"up_services":["api"],"down_services":["db"],"config_history_depth":5,"service_labels":{"api":{"team":"platform"}},"probes":null}`,
+		"yaml": "up_services: [api]\ndown_services:\n  - db\nconfig_history_depth: 5\nservice_labels:\n  api:\n    team: platform\n",
+	}
+	for format, input := range inputs {
+		t.Run(format, func(t *testing.T) {
+			config, err := parseConfigAs([]byte(input), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(config.UpServices) != 1 || config.UpServices[0] != "api" ||
+				len(config.DownServices) != 1 || config.DownServices[0] != "db" ||
+				config.ConfigHistoryDepth != 5 || config.ServiceLabels["api"]["team"] != "platform" {
+				t.Errorf("unexpected config: %+v", config)
+			}
+		})
+	}
+
+	var invalid *invalidConfigError
+	if _, err := parseConfigAs([]byte(`{"up_services":["api"],"down_services":["api"]}`), "json"); !errors.As(err, &invalid) {
+		t.Errorf("expected validation to reject JSON configs too, got %v", err)
+	}
+	if _, err := parseConfigAs([]byte(`{"up_services":`), "json"); !errors.As(err, &invalid) {
+		t.Errorf("expected an invalid config error for malformed JSON, got %v", err)
+	}
+}
+
+func TestInlineConfigSource(t *testing.T) {
+	preserveConfigState(t)
+
+	env := map[string]string{}
+	setInline := func(content string) {
+		env["CONFIG_INLINE"] = base64.StdEncoding.EncodeToString([]byte(content))
+	}
+	source := NewInlineConfigSource("json", 0)
+	source.getenv = func(key string) string { return env[key] }
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{}
+	setInline(`{"up_services":["api"],"down_services":["db"]}`)
+	config, err := source.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMutex.Lock()
+	applyConfig(m, cfg, config, loadTriggerStartup)
+	configMutex.Unlock()
+
+	statuses := func() map[string]float64 {
+		mfs, err := m.Registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gatheredServiceStatus(mfs)
+	}
+	if got := statuses(); len(got) != 2 || got["api"] != 1 || got["db"] != 0 {
+		t.Fatalf("expected the inline config in the metrics, got %v", got)
+	}
+
+	// The variable changes
+	setInline(`{"up_services":["api","db"]}`)
+	if err := source.pollAndApply(m, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses(); len(got) != 2 || got["db"] != 1 {
+		t.Errorf("expected the changed inline config in the metrics, got %v", got)
+	}
+
+	// An invalid value is reported once and the last config stays applied
+	env["CONFIG_INLINE"] = "not base64!"
+	if err := source.pollAndApply(m, cfg); err == nil {
+		t.Error("expected an error for an invalid value")
+	}
+	if err := source.pollAndApply(m, cfg); err != nil {
+		t.Errorf("expected an unchanged invalid value to be skipped, got %v", err)
+	}
+	if got := statuses(); got["db"] != 1 {
+		t.Errorf("expected the last valid config to stay applied, got %v", got)
+	}
+}
+
+func TestInlineSource_ConfigAndReload(t *testing.T) {
+	setTestServices(t, []string{"api"}, []string{"db"})
+	m := newTestMetrics()
+	cfg := &ServerConfig{
+		ConfigPath:   filepath.Join(t.TempDir(), "missing.toml"),
+		ConfigSource: configSourceInline,
+	}
+	mux := NewAppServeMux(m, cfg)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "- db") {
+		t.Errorf("expected /config to list the inline config, got %d:\n%s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "CONFIG_INLINE") {
+		t.Errorf("expected 409 naming CONFIG_INLINE, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := reloadFromSource(context.Background(), m, cfg, loadTriggerSignal); err == nil {
+		t.Error("expected a SIGHUP reload to be rejected")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// maxPooledBufferSize keeps unusually large responses from pinning memory in the pool
+const maxPooledBufferSize = 64 << 10
+
+// jsonBufferPool reuses the buffers JSON responses are encoded into
+var jsonBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// writeJSON encodes v into a pooled buffer and writes it with the given status
+// Encoding fully before writing lets encode errors still become a 500
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	writeEncoded(w, status, func(buf *bytes.Buffer) error {
+		return json.NewEncoder(buf).Encode(v)
+	})
+}
+
+// writeEncoded writes the JSON encode puts into a pooled buffer with the
+// given status
+func writeEncoded(w http.ResponseWriter, status int, encode func(*bytes.Buffer) error) {
+	buf := jsonBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			jsonBufferPool.Put(buf)
+		}
+	}()
+
+	if err := encode(buf); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
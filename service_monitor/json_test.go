@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWriteJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusCreated, map[string]int{"answer": 42})
+
+	if rec.Code != http.StatusCreated {
+		t.Errorf("expected status 201, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected JSON content type, got %q", got)
+	}
+	if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("expected Content-Length %d, got %s", rec.Body.Len(), got)
+	}
+	if got := rec.Body.String(); got != "{\"answer\":42}\n" {
+		t.Errorf("unexpected body %q", got)
+	}
+
+	// Values that can't be encoded become a 500 instead of a truncated body
+	rec = httptest.NewRecorder()
+	writeJSON(rec, http.StatusOK, map[string]interface{}{"bad": make(chan int)})
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 for an encode error, got %d", rec.Code)
+	}
+}
+
+// writeJSONUnpooled is writeJSON with a fresh buffer per response, for comparison
+func writeJSONUnpooled(w http.ResponseWriter, status int, v interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+func BenchmarkWriteJSON(b *testing.B) {
+	resp := adminServicesResponse{Total: 100, Page: 1, PerPage: 100, TotalPages: 1}
+	for i := 0; i < 100; i++ {
+		resp.Services = append(resp.Services, adminService{
+			Name:          fmt.Sprintf("service-%03d", i),
+			Status:        "up",
+			Source:        configSourceFile,
+			LastChangedAt: time.Unix(1700000000, 0),
+		})
+	}
+
+	for _, impl := range []struct {
+		name  string
+		write func(http.ResponseWriter, int, interface{})
+	}{
+		{"pooled", writeJSON},
+		{"unpooled", writeJSONUnpooled},
+	} {
+		b.Run(impl.name, func(b *testing.B) {
+			w := newDiscardResponseWriter()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				impl.write(w, http.StatusOK, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+// Kubernetes discovery defaults
+const (
+	defaultServiceMonitorPath = "/app/config/servicemonitor.yaml"
+	k8sDiscoveryInterval      = 30 * time.Second
+	k8sDiscoveryTimeout       = 10 * time.Second
+
+	// loadTriggerDiscovery labels configs built from Kubernetes discovery
+	loadTriggerDiscovery = "k8s"
+)
+
+// ServiceMonitor holds the fields of a Prometheus Operator ServiceMonitor
+// manifest that select the services to monitor
+type ServiceMonitor struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		Selector struct {
+			MatchLabels map[string]string `json:"matchLabels"`
+		} `json:"selector"`
+		NamespaceSelector struct {
+			Any        bool     `json:"any"`
+			MatchNames []string `json:"matchNames"`
+		} `json:"namespaceSelector"`
+	} `json:"spec"`
+}
+
+// loadServiceMonitor reads a ServiceMonitor manifest from path
+func loadServiceMonitor(path string) (*ServiceMonitor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ServiceMonitor: %w", err)
+	}
+
+	var sm ServiceMonitor
+	if err := yaml.Unmarshal(data, &sm); err != nil {
+		return nil, fmt.Errorf("error parsing ServiceMonitor: %w", err)
+	}
+	if sm.Metadata.Namespace == "" {
+		sm.Metadata.Namespace = metav1.NamespaceDefault
+	}
+	return &sm, nil
+}
+
+// namespaces returns the namespaces the ServiceMonitor selects services from;
+// metav1.NamespaceAll stands for every namespace
+func (sm *ServiceMonitor) namespaces() []string {
+	switch {
+	case sm.Spec.NamespaceSelector.Any:
+		return []string{metav1.NamespaceAll}
+	case len(sm.Spec.NamespaceSelector.MatchNames) > 0:
+		return sm.Spec.NamespaceSelector.MatchNames
+	default:
+		return []string{sm.Metadata.Namespace}
+	}
+}
+
+// discoverServices lists the services selected by sm and reports each one as
+// up when its Endpoints have at least one ready address
+// Services are named namespace/name so equally named services don't collide
+// A service without an Endpoints object is down; any other API error fails
+// the whole round
+func discoverServices(ctx context.Context, client kubernetes.Interface, sm *ServiceMonitor) (*Config, error) {
+	selector := labels.SelectorFromSet(sm.Spec.Selector.MatchLabels).String()
+	config := &Config{UpServices: []string{}, DownServices: []string{}}
+
+	for _, namespace := range sm.namespaces() {
+		services, err := client.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, fmt.Errorf("error listing services in namespace %q: %w", namespace, err)
+		}
+
+		for _, svc := range services.Items {
+			name := svc.Namespace + "/" + svc.Name
+			endpoints, err := client.CoreV1().Endpoints(svc.Namespace).Get(ctx, svc.Name, metav1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("error getting endpoints of service %q: %w", name, err)
+			}
+			if err == nil && hasReadyAddress(endpoints) {
+				config.UpServices = append(config.UpServices, name)
+			} else {
+				config.DownServices = append(config.DownServices, name)
+			}
+		}
+	}
+
+	sort.Strings(config.UpServices)
+	sort.Strings(config.DownServices)
+	return config, nil
+}
+
+// hasReadyAddress reports whether any subset of endpoints has a ready address
+func hasReadyAddress(endpoints *corev1.Endpoints) bool {
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// newInClusterClient creates a Kubernetes client from the pod's service account
+func newInClusterClient() (kubernetes.Interface, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading in-cluster config: %w", err)
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// discoverOnce runs a single discovery with a timeout and applies the
+// discovered services; the other sections of the applied config, which come
+// from the config file, are kept
+func discoverOnce(ctx context.Context, m *Metrics, cfg *ServerConfig, client kubernetes.Interface, sm *ServiceMonitor) error {
+	ctx, cancel := context.WithTimeout(ctx, k8sDiscoveryTimeout)
+	defer cancel()
+
+	discovered, err := discoverServices(ctx, client, sm)
+	if err != nil {
+		return err
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	config := discovered
+	if current := loadCurrentConfig(); current != nil {
+		config = current.clone()
+		config.UpServices, config.DownServices = discovered.UpServices, discovered.DownServices
+	}
+	applyConfig(m, cfg, config, loadTriggerDiscovery)
+	return nil
+}
+
+// k8sDiscovery keeps the services in sync with the ones a ServiceMonitor
+// selects in the cluster
+type k8sDiscovery struct {
+	client   kubernetes.Interface
+	sm       *ServiceMonitor
+	interval time.Duration
+}
+
+func newK8sDiscovery(interval time.Duration) *k8sDiscovery {
+	return &k8sDiscovery{interval: interval}
+}
+
+// start switches to discovering services from Kubernetes and reports whether
+// it succeeded; on failure the file-based config stays in use
+func (d *k8sDiscovery) start(m *Metrics, cfg *ServerConfig) bool {
+	path := os.Getenv("K8S_SERVICE_MONITOR_PATH")
+	if path == "" {
+		path = defaultServiceMonitorPath
+	}
+
+	sm, err := loadServiceMonitor(path)
+	if err != nil {
+		log.Printf("Kubernetes discovery disabled, using the config file: %v", err)
+		return false
+	}
+	client, err := newInClusterClient()
+	if err != nil {
+		log.Printf("Kubernetes discovery disabled, using the config file: %v", err)
+		return false
+	}
+	if err := discoverOnce(context.Background(), m, cfg, client, sm); err != nil {
+		log.Printf("Kubernetes API unreachable, using the config file: %v", err)
+		return false
+	}
+
+	log.Printf("Discovering services from ServiceMonitor %s/%s", sm.Metadata.Namespace, sm.Metadata.Name)
+	d.client, d.sm = client, sm
+	return true
+}
+
+// run repeats the discovery every interval until ctx is cancelled; failed
+// rounds keep the last applied config
+func (d *k8sDiscovery) run(ctx context.Context, m *Metrics, cfg *ServerConfig) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, err := cfg.awaitReload(ctx, loadTriggerDiscovery, func() (*Config, error) {
+			return nil, discoverOnce(ctx, m, cfg, d.client, d.sm)
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Kubernetes discovery failed, keeping the current services: %v", err)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testServiceMonitor = `apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: backend
+  namespace: monitoring
+spec:
+  selector:
+    matchLabels:
+      team: backend
+  namespaceSelector:
+    matchNames:
+      - prod
+      - staging
+  endpoints:
+    - port: metrics
+`
+
+func testService(namespace, name string, labels map[string]string) *corev1.Service {
+	return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+}
+
+func testEndpoints(namespace, name string, ready, notReady int) *corev1.Endpoints {
+	subset := corev1.EndpointSubset{}
+	for i := 0; i < ready; i++ {
+		subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{IP: fmt.Sprintf("10.0.0.%d", i)})
+	}
+	for i := 0; i < notReady; i++ {
+		subset.NotReadyAddresses = append(subset.NotReadyAddresses, corev1.EndpointAddress{IP: fmt.Sprintf("10.0.1.%d", i)})
+	}
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Subsets:    []corev1.EndpointSubset{subset},
+	}
+}
+
+func writeServiceMonitor(t *testing.T, content string) *ServiceMonitor {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "servicemonitor.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sm, err := loadServiceMonitor(path)
+	if err != nil {
+		t.Fatalf("failed to load ServiceMonitor: %v", err)
+	}
+	return sm
+}
+
+func TestLoadServiceMonitor(t *testing.T) {
+	sm := writeServiceMonitor(t, testServiceMonitor)
+
+	if sm.Metadata.Name != "backend" || sm.Spec.Selector.MatchLabels["team"] != "backend" {
+		t.Errorf("unexpected ServiceMonitor: %+v", sm)
+	}
+	if got := fmt.Sprint(sm.namespaces()); got != "[prod staging]" {
+		t.Errorf("expected namespaces [prod staging], got %s", got)
+	}
+
+	// Without a namespace selector the ServiceMonitor's own namespace is used
+	sm = writeServiceMonitor(t, "metadata:\n  name: own\nspec:\n  selector:\n    matchLabels:\n      app: x\n")
+	if got := fmt.Sprint(sm.namespaces()); got != "[default]" {
+		t.Errorf("expected the default namespace, got %s", got)
+	}
+
+	sm = writeServiceMonitor(t, "metadata:\n  name: all\nspec:\n  namespaceSelector:\n    any: true\n")
+	if got := sm.namespaces(); len(got) != 1 || got[0] != metav1.NamespaceAll {
+		t.Errorf("expected all namespaces, got %v", got)
+	}
+}
+
+func TestDiscoverServices(t *testing.T) {
+	backend := map[string]string{"team": "backend"}
+	client := fake.NewSimpleClientset(
+		testService("prod", "api", backend),
+		testEndpoints("prod", "api", 2, 0),
+		testService("prod", "worker", backend),
+		testEndpoints("prod", "worker", 0, 1),
+		testService("staging", "api", backend),
+		testEndpoints("staging", "api", 1, 1),
+		// No Endpoints object at all
+		testService("staging", "cron", backend),
+		// Not selected: other labels or namespace
+		testService("prod", "frontend", map[string]string{"team": "frontend"}),
+		testEndpoints("prod", "frontend", 1, 0),
+		testService("dev", "api", backend),
+		testEndpoints("dev", "api", 1, 0),
+	)
+
+	config, err := discoverServices(context.Background(), client, writeServiceMonitor(t, testServiceMonitor))
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+
+	if got := fmt.Sprint(config.UpServices); got != "[prod/api staging/api]" {
+		t.Errorf("unexpected up services: %s", got)
+	}
+	if got := fmt.Sprint(config.DownServices); got != "[prod/worker staging/cron]" {
+		t.Errorf("unexpected down services: %s", got)
+	}
+}
+
+func TestDiscoverOnce_KeepsConfigWhenAPIUnreachable(t *testing.T) {
+	setTestServices(t, []string{"file-service"}, nil)
+
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("list", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("connection refused")
+	})
+
+	m := newTestMetrics()
+	err := discoverOnce(context.Background(), m, &ServerConfig{}, client, writeServiceMonitor(t, testServiceMonitor))
+	if err == nil {
+		t.Fatal("expected discovery to fail")
+	}
+	if config := loadCurrentConfig(); len(config.UpServices) != 1 || config.UpServices[0] != "file-service" {
+		t.Errorf("expected the file-based config to stay applied, got %+v", config)
+	}
+}
+
+func TestDiscoverOnce_KeepsConfigWhenEndpointsFail(t *testing.T) {
+	setTestServices(t, []string{"prod/api"}, nil)
+
+	client := fake.NewSimpleClientset(testService("prod", "api", map[string]string{"team": "backend"}))
+	client.PrependReactor("get", "endpoints", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("connection refused")
+	})
+
+	err := discoverOnce(context.Background(), newTestMetrics(), &ServerConfig{}, client, writeServiceMonitor(t, testServiceMonitor))
+	if err == nil || !strings.Contains(err.Error(), "prod/api") {
+		t.Fatalf("expected discovery to fail on the endpoints of prod/api, got %v", err)
+	}
+	if config := loadCurrentConfig(); len(config.UpServices) != 1 || len(config.DownServices) != 0 {
+		t.Errorf("expected prod/api to stay up, got %+v", config)
+	}
+}
+
+func TestDiscoverOnce_KeepsFileSections(t *testing.T) {
+	preserveConfigState(t)
+	setTestServices(t, []string{"default-service"}, nil)
+	file := loadCurrentConfig().clone()
+	file.HTTPHeaders = map[string]string{"X-Frame-Options": "DENY"}
+	file.Probes = map[string]ProbeTarget{"prod/api": {URL: "http://api.prod:8080/healthz"}}
+	file.SLO.Threshold = 0.5
+	currentConfig.Store(file)
+
+	backend := map[string]string{"team": "backend"}
+	client := fake.NewSimpleClientset(testService("prod", "api", backend), testEndpoints("prod", "api", 1, 0))
+	if err := discoverOnce(context.Background(), newTestMetrics(), &ServerConfig{}, client, writeServiceMonitor(t, testServiceMonitor)); err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+
+	config := loadCurrentConfig()
+	if fmt.Sprint(config.UpServices) != "[prod/api]" || len(config.DownServices) != 0 {
+		t.Errorf("expected the discovered services, got up %v and down %v", config.UpServices, config.DownServices)
+	}
+	if config.HTTPHeaders["X-Frame-Options"] != "DENY" || config.Probes["prod/api"].URL == "" || config.SLO.Threshold != 0.5 {
+		t.Errorf("expected the sections of the config file to be kept, got %+v", config)
+	}
+	if len(file.UpServices) != 1 || file.UpServices[0] != "default-service" {
+		t.Errorf("expected the previous config to be left unchanged, got %v", file.UpServices)
+	}
+}
+
+func TestK8sDiscovery_RunStopsWithContext(t *testing.T) {
+	setTestServices(t, nil, nil)
+
+	backend := map[string]string{"team": "backend"}
+	client := fake.NewSimpleClientset(testService("prod", "api", backend), testEndpoints("prod", "api", 1, 0))
+	d := newK8sDiscovery(10 * time.Millisecond)
+	d.client, d.sm = client, writeServiceMonitor(t, testServiceMonitor)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.run(ctx, newTestMetrics(), &ServerConfig{})
+	}()
+
+	if !waitFor(t, time.Second, func() bool {
+		configMutex.RLock()
+		defer configMutex.RUnlock()
+		return len(loadCurrentConfig().UpServices) == 1
+	}) {
+		t.Error("expected a discovery round to apply prod/api")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected discovery to stop once the context is cancelled")
+	}
+}
+
+func TestDiscoverySource_NotReloadedFromFile(t *testing.T) {
+	preserveConfigState(t)
+	setTestServices(t, []string{"prod/api"}, []string{"prod/worker"})
+	m := newTestMetrics()
+	cfg := &ServerConfig{
+		ConfigPath:   writeTestConfig(t, []string{"default-service"}, nil),
+		ConfigSource: configSourceKubernetes,
+	}
+	mux := NewAppServeMux(m, cfg)
+
+	for _, target := range []string{"/reload", "/reload?dry_run=true"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "Kubernetes discovery") {
+			t.Errorf("%s: expected 409 naming Kubernetes discovery, got %d: %s", target, rec.Code, rec.Body.String())
+		}
+	}
+	if _, err := reloadFromSource(context.Background(), m, cfg, loadTriggerSignal); err == nil {
+		t.Error("expected a SIGHUP reload to be rejected")
+	}
+	if config := loadCurrentConfig(); len(config.UpServices) != 1 || config.UpServices[0] != "prod/api" {
+		t.Errorf("expected the discovered services to stay applied, got %+v", config)
+	}
+
+	// /config shows the discovered services rather than the file
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "- prod/api") ||
+		strings.Contains(rec.Body.String(), "default-service") {
+		t.Errorf("expected /config to list the applied services, got %d:\n%s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Last-Modified") != "" {
+		t.Errorf("expected no Last-Modified of the unused file, got %q", rec.Header().Get("Last-Modified"))
+	}
+}
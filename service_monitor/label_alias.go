@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// labelAliasProblems returns a description of every invalid label alias
+func labelAliasProblems(aliases map[string]string) []string {
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	aliasOf := make(map[string]string, len(aliases))
+	for _, name := range names {
+		alias := aliases[name]
+		switch {
+		case !isValidLabelName(name):
+			problems = append(problems, fmt.Sprintf("invalid label name %q in label_aliases", name))
+		case !isValidLabelName(alias):
+			problems = append(problems, fmt.Sprintf("label %q has invalid alias %q", name, alias))
+		case aliases[alias] != "":
+			problems = append(problems, fmt.Sprintf("alias %q of label %q is itself an aliased label", alias, name))
+		case aliasOf[alias] != "":
+			problems = append(problems, fmt.Sprintf("labels %q and %q both have alias %q", aliasOf[alias], name, alias))
+		default:
+			aliasOf[alias] = name
+		}
+	}
+	return problems
+}
+
+// isValidLabelName reports whether name can be used as a user-defined label
+func isValidLabelName(name string) bool {
+	return model.LabelName(name).IsValid() && !strings.HasPrefix(name, model.ReservedLabelPrefix)
+}
+
+// LabelAliasGatherer renames labels in the gathered metrics for dashboards and
+// recording rules that expect other names; the collectors keep their labels
+type LabelAliasGatherer struct {
+	gatherer prometheus.Gatherer
+
+	// Original label name to alias, replaced whole on config reload
+	aliases atomic.Pointer[map[string]string]
+
+	// Families already logged for an alias collision
+	collisions sync.Map
+}
+
+// NewLabelAliasGatherer wraps g without any aliases
+func NewLabelAliasGatherer(g prometheus.Gatherer) *LabelAliasGatherer {
+	return &LabelAliasGatherer{gatherer: g}
+}
+
+// SetAliases replaces the label aliases applied by later Gather calls
+func (g *LabelAliasGatherer) SetAliases(aliases map[string]string) {
+	if len(aliases) == 0 {
+		g.aliases.Store(nil)
+		return
+	}
+	copied := make(map[string]string, len(aliases))
+	for name, alias := range aliases {
+		copied[name] = alias
+	}
+	g.aliases.Store(&copied)
+}
+
+// Gather implements prometheus.Gatherer
+// The wrapped gatherer may cache its result, so renamed families are copies
+func (g *LabelAliasGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.gatherer.Gather()
+	aliases := g.aliases.Load()
+	if aliases == nil {
+		return mfs, err
+	}
+
+	out := make([]*dto.MetricFamily, len(mfs))
+	for i, mf := range mfs {
+		out[i] = g.aliasFamily(mf, *aliases)
+	}
+	return out, err
+}
+
+// aliasFamily returns mf with its labels renamed, or mf itself when none of
+// its labels are aliased or an alias would collide with one of its labels
+func (g *LabelAliasGatherer) aliasFamily(mf *dto.MetricFamily, aliases map[string]string) *dto.MetricFamily {
+	renamed := false
+	for _, metric := range mf.Metric {
+		present := make(map[string]bool, len(metric.Label))
+		for _, pair := range metric.Label {
+			present[pair.GetName()] = true
+		}
+		for _, pair := range metric.Label {
+			alias, ok := aliases[pair.GetName()]
+			if !ok {
+				continue
+			}
+			if present[alias] {
+				if _, logged := g.collisions.LoadOrStore(mf.GetName(), true); !logged {
+					log.Printf("Not aliasing labels of %s: alias %q of label %q is already a label", mf.GetName(), alias, pair.GetName())
+				}
+				return mf
+			}
+			renamed = true
+		}
+	}
+	if !renamed {
+		return mf
+	}
+
+	metrics := make([]*dto.Metric, len(mf.Metric))
+	for i, metric := range mf.Metric {
+		labels := make([]*dto.LabelPair, len(metric.Label))
+		for j, pair := range metric.Label {
+			labels[j] = pair
+			if alias, ok := aliases[pair.GetName()]; ok {
+				labels[j] = &dto.LabelPair{Name: &alias, Value: pair.Value}
+			}
+		}
+		sort.Slice(labels, func(a, b int) bool { return labels[a].GetName() < labels[b].GetName() })
+
+		metrics[i] = &dto.Metric{
+			Label:       labels,
+			Gauge:       metric.Gauge,
+			Counter:     metric.Counter,
+			Summary:     metric.Summary,
+			Untyped:     metric.Untyped,
+			Histogram:   metric.Histogram,
+			TimestampMs: metric.TimestampMs,
+		}
+	}
+	return &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type, Metric: metrics}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLabelAliasProblems(t *testing.T) {
+	tests := []struct {
+		name    string
+		aliases map[string]string
+		want    int
+	}{
+		{"none", nil, 0},
+		{"valid", map[string]string{"service": "job_name", "source": "origin"}, 0},
+		{"invalid alias", map[string]string{"service": "job-name"}, 1},
+		{"reserved alias", map[string]string{"service": "__name__"}, 1},
+		{"invalid name", map[string]string{"1service": "job_name"}, 1},
+		{"aliases collide", map[string]string{"service": "job_name", "source": "job_name"}, 1},
+		{"alias is aliased", map[string]string{"service": "source", "source": "origin"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := labelAliasProblems(tt.aliases); len(got) != tt.want {
+				t.Errorf("expected %d problems, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLabelAliasGatherer_Metrics(t *testing.T) {
+	setTestServices(t, []string{"api"}, []string{"db"})
+	m := newTestMetrics()
+	mux := NewMetricsServeMux(m, &ServerConfig{})
+
+	config := &Config{UpServices: []string{"api"}, DownServices: []string{"db"}, LabelAliases: map[string]string{"service": "job_name"}}
+	configMutex.Lock()
+	applyConfig(m, &ServerConfig{}, config, loadTriggerManual)
+	configMutex.Unlock()
+
+	body := scrape(t, mux)
+	if !strings.Contains(body, `service_monitor_up{job_name="api"} 1`) {
+		t.Errorf("expected the service label to be exposed as job_name:\n%s", body)
+	}
+	if strings.Contains(body, `service_monitor_up{service=`) {
+		t.Error("expected no service label on service_monitor_up")
+	}
+
+	// The collectors keep their own label names
+	if got := testutil.ToFloat64(m.ServiceStatus.WithLabelValues("api")); got != 1 {
+		t.Errorf("expected service=api to stay 1 in process, got %v", got)
+	}
+
+	config = &Config{UpServices: []string{"api"}, DownServices: []string{"db"}}
+	configMutex.Lock()
+	applyConfig(m, &ServerConfig{}, config, loadTriggerManual)
+	configMutex.Unlock()
+
+	if body := scrape(t, mux); !strings.Contains(body, `service_monitor_up{service="api"} 1`) {
+		t.Errorf("expected the service label back after removing the alias:\n%s", body)
+	}
+}
+
+func TestLabelAliasGatherer_CachedResultUnchanged(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_status", Help: "Test status"}, []string{"service"})
+	gauge.WithLabelValues("api").Set(1)
+	reg.MustRegister(gauge)
+
+	cached := NewCachedGatherer(reg, time.Hour)
+	g := NewLabelAliasGatherer(cached)
+	g.SetAliases(map[string]string{"service": "job_name"})
+
+	for i := 0; i < 2; i++ {
+		mfs, err := g.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := mfs[0].Metric[0].Label[0].GetName(); got != "job_name" {
+			t.Errorf("gather %d: expected job_name, got %q", i, got)
+		}
+	}
+
+	mfs, _ := cached.Gather()
+	if got := mfs[0].Metric[0].Label[0].GetName(); got != "service" {
+		t.Errorf("expected the cached families to keep service, got %q", got)
+	}
+}
+
+func TestLabelAliasGatherer_Collision(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_status", Help: "Test status"}, []string{"service", "job_name"})
+	gauge.WithLabelValues("api", "monitor").Set(1)
+	reg.MustRegister(gauge)
+
+	g := NewLabelAliasGatherer(reg)
+	g.SetAliases(map[string]string{"service": "job_name"})
+
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := mfs[0].Metric[0].Label
+	if labels[0].GetName() != "job_name" || labels[1].GetName() != "service" {
+		t.Errorf("expected a colliding family to be left unchanged, got %v", labels)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+)
+
+// requestLimiter is a resizable semaphore bounding concurrent requests
+type requestLimiter struct {
+	mu  sync.Mutex
+	sem chan struct{}
+}
+
+func newRequestLimiter(size int) *requestLimiter {
+	return &requestLimiter{sem: make(chan struct{}, size)}
+}
+
+// tryAcquire takes a slot without blocking; the returned release function
+// must be called once the request finishes
+func (l *requestLimiter) tryAcquire() (release func(), ok bool) {
+	l.mu.Lock()
+	sem := l.sem
+	l.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+	default:
+		return nil, false
+	}
+
+	return func() {
+		// The slot may already have been drained by a resize
+		select {
+		case <-sem:
+		default:
+		}
+	}, true
+}
+
+// resize drains the current semaphore and replaces it with one of the new size
+func (l *requestLimiter) resize(size int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if cap(l.sem) == size {
+		return
+	}
+
+	for len(l.sem) > 0 {
+		<-l.sem
+	}
+	l.sem = make(chan struct{}, size)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRootHandler_ConcurrencyLimit(t *testing.T) {
+	// Hold accepted requests until every request has been attempted
+	release := make(chan struct{})
+	origSleep := sleep
+	sleep = func(time.Duration) { <-release }
+	defer func() { sleep = origSleep }()
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{}
+	applyConfig(m, cfg, &Config{Simulation: SimulationConfig{MaxConcurrentRequests: 5}}, loadTriggerManual)
+
+	server := httptest.NewServer(NewServeMux(m, cfg))
+	defer server.Close()
+
+	const total = 100
+	var (
+		wg          sync.WaitGroup
+		mu          sync.Mutex
+		rejected    int
+		retryAfters int
+	)
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode == http.StatusServiceUnavailable {
+				mu.Lock()
+				rejected++
+				if resp.Header.Get("Retry-After") == "1" {
+					retryAfters++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(m.RejectedRequests) < total-5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if rejected != total-5 {
+		t.Errorf("expected %d rejected requests, got %d", total-5, rejected)
+	}
+	if retryAfters != rejected {
+		t.Errorf("expected Retry-After on all %d rejections, got %d", rejected, retryAfters)
+	}
+	if v := testutil.ToFloat64(m.RejectedRequests); v != total-5 {
+		t.Errorf("expected rejected counter %d, got %v", total-5, v)
+	}
+	if v := testutil.ToFloat64(m.RequestsProcessed); v != 5 {
+		t.Errorf("expected 5 processed requests, got %v", v)
+	}
+}
+
+func TestRequestLimiter_Resize(t *testing.T) {
+	limiter := newRequestLimiter(1)
+
+	releaseOld, ok := limiter.tryAcquire()
+	if !ok {
+		t.Fatal("expected first acquire to succeed")
+	}
+	if _, ok := limiter.tryAcquire(); ok {
+		t.Fatal("expected acquire beyond capacity to fail")
+	}
+
+	limiter.resize(2)
+	for i := 0; i < 2; i++ {
+		if _, ok := limiter.tryAcquire(); !ok {
+			t.Fatalf("expected acquire %d to succeed after resize", i+1)
+		}
+	}
+	if _, ok := limiter.tryAcquire(); ok {
+		t.Fatal("expected acquire beyond new capacity to fail")
+	}
+
+	// Releasing a slot from the drained semaphore must not block
+	done := make(chan struct{})
+	go func() {
+		releaseOld()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("release of a drained slot blocked")
+	}
+}
@@ -1,167 +1,101 @@
 package main
 
 import (
-	"fmt"
+	"flag"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/pelletier/go-toml/v2"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
-// Configuration structure matching the TOML file
-type Config struct {
-	UpServices   []string `toml:"up_services"`
-	DownServices []string `toml:"down_services"`
-}
-
-var (
-	requestsProcessed = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "service_monitor_requests_total",
-		Help: "The total number of processed requests",
-	})
-
-	requestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "service_monitor_request_duration_seconds",
-		Help:    "Request duration distribution",
-		Buckets: prometheus.LinearBuckets(0.01, 0.05, 10),
-	})
-
-	activeRequests = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "service_monitor_active_requests",
-		Help: "Number of active requests",
-	})
-
-	errorRate = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "service_monitor_error_rate",
-		Help: "Current error rate",
-	})
-
-	// Define service status gauge vector
-	serviceStatus = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "service_monitor_up",
-			Help: "Status of monitored services (1=up, 0=down)",
-		},
-		[]string{"service"},
-	)
-
-	// Configuration file path (default, can be overridden by environment variable)
-	configPath = "/app/config/config.toml"
-
-	// Last modification time
-	lastModTime time.Time
-
-	// Mutex for thread-safe operations
-	configMutex sync.RWMutex
-)
+// Configuration file path (default, can be overridden by environment variable)
+var configPath = "/app/config/config.toml"
 
 func init() {
-	prometheus.MustRegister(requestsProcessed)
-	prometheus.MustRegister(requestDuration)
-	prometheus.MustRegister(activeRequests)
-	prometheus.MustRegister(errorRate)
-	prometheus.MustRegister(serviceStatus)
-
 	// Seed the random number generator
 	rand.Seed(time.Now().UnixNano())
 }
 
-// loadConfig reads the configuration file and returns the Config
-// It opens and closes the file for each read to ensure we get the latest content
-func loadConfig() (*Config, error) {
-	// Open the file explicitly so it's closed after reading
-	file, err := os.Open(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("error opening config file: %w", err)
-	}
-	defer file.Close()
-
-	// Read the file content
-	configData, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("error reading config file: %w", err)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "encrypt" {
+		if err := runEncryptCommand(os.Args[2:], os.Getenv); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	var config Config
-	if err := toml.Unmarshal(configData, &config); err != nil {
-		return nil, fmt.Errorf("error parsing config file: %w", err)
-	}
+	mockServer := flag.Bool("mock-server", false, "serve /mock/{service}/up and /mock/{service}/down for integration tests")
+	flag.Parse()
 
-	return &config, nil
-}
+	configureProfiling()
+	startContinuousProfiling()
 
-// updateServiceMetrics updates the Prometheus metrics based on service status
-func updateServiceMetrics(config *Config) {
-	// Reset existing metrics
-	serviceStatus.Reset()
+	fsys := RealFileSystem{}
 
-	// Set up services as 1
-	for _, service := range config.UpServices {
-		serviceStatus.WithLabelValues(service).Set(1)
+	// Check for CONFIG_PATH environment variable
+	if envPath := os.Getenv("CONFIG_PATH"); envPath != "" {
+		configPath = envPath
+		log.Printf("Using config path from environment: %s", configPath)
 	}
 
-	// Set down services as 0
-	for _, service := range config.DownServices {
-		serviceStatus.WithLabelValues(service).Set(0)
+	// CONFIG_BASE64 takes precedence for platforms that can't mount files
+	var base64ConfigPath string
+	if encoded := os.Getenv("CONFIG_BASE64"); encoded != "" {
+		path, err := writeBase64Config(encoded)
+		if err != nil {
+			log.Printf("Invalid CONFIG_BASE64, using %s: %v", configPath, err)
+		} else {
+			configPath, base64ConfigPath = path, path
+			log.Printf("Using config from CONFIG_BASE64, written to %s", configPath)
+		}
 	}
-}
+	configPath = normalizeConfigPath(configPath)
 
-// watchConfig monitors the config file for changes and reloads it
-// The file is opened and closed on each check to ensure we detect changes
-func watchConfig() {
-	log.Printf("Starting config watcher for file: %s", configPath)
-	checkInterval := 3 * time.Second // Check more frequently (3 seconds)
-	
-	for {
-		// Check if file has been modified
-		fileInfo, err := os.Stat(configPath)
+	// An encrypted config file can't be read without its key, so a bad key is fatal
+	if key := os.Getenv("CONFIG_ENCRYPTION_KEY"); key != "" {
+		c, err := newConfigCipher(os.Getenv("CONFIG_ENCRYPTION_FORMAT"), key)
 		if err != nil {
-			log.Printf("Error checking config file: %v", err)
-			time.Sleep(checkInterval)
-			continue
+			log.Fatalf("Invalid CONFIG_ENCRYPTION_KEY: %v", err)
 		}
+		configEncryption = c
+		log.Println("Decrypting the config file with CONFIG_ENCRYPTION_KEY")
+	}
 
-		modTime := fileInfo.ModTime()
-		if modTime != lastModTime {
-			log.Println("Config file changed, reloading...")
-			
-			config, err := loadConfig()
-			if err != nil {
-				log.Printf("Error loading config: %v", err)
+	// CONFIG_INLINE replaces the config file entirely and is polled for changes
+	var inlineSource *InlineConfigSource
+	if os.Getenv("CONFIG_INLINE") != "" {
+		format := "toml"
+		if value := os.Getenv("CONFIG_INLINE_FORMAT"); value != "" {
+			if inlineConfigFormats[strings.ToLower(value)] {
+				format = strings.ToLower(value)
 			} else {
-				configMutex.Lock()
-				updateServiceMetrics(config)
-				lastModTime = modTime
-				configMutex.Unlock()
-				log.Printf("Reloaded config: %d up services and %d down services", 
-					len(config.UpServices), len(config.DownServices))
+				log.Printf("Invalid CONFIG_INLINE_FORMAT %q, using %s", value, format)
 			}
 		}
-		
-		// Short sleep to be more responsive to changes
-		time.Sleep(checkInterval)
-	}
-}
-
-func main() {
-	// Check for CONFIG_PATH environment variable
-	if envPath := os.Getenv("CONFIG_PATH"); envPath != "" {
-		configPath = envPath
-		log.Printf("Using config path from environment: %s", configPath)
+		interval := defaultInlineConfigPollInterval
+		if value := os.Getenv("CONFIG_POLL_INTERVAL"); value != "" {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				log.Printf("Invalid CONFIG_POLL_INTERVAL %q, using %s", value, interval)
+			} else {
+				interval = time.Duration(seconds) * time.Second
+			}
+		}
+		inlineSource = NewInlineConfigSource(format, interval)
+		log.Printf("Using %s config from CONFIG_INLINE instead of %s", format, configPath)
 	}
 
 	// Ensure config directory exists
 	lastSlash := strings.LastIndex(configPath, "/")
 	if lastSlash > 0 {
 		configDir := configPath[:lastSlash]
-		if _, err := os.Stat(configDir); os.IsNotExist(err) {
+		if _, err := fsys.Stat(configDir); os.IsNotExist(err) {
 			log.Printf("Config directory %s does not exist, creating it", configDir)
 			if err := os.MkdirAll(configDir, 0755); err != nil {
 				log.Printf("Error creating config directory: %v", err)
@@ -169,8 +103,25 @@ func main() {
 		}
 	}
 
-	// Check if config file exists, create default if not
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+	// Optionally wait for a config file that is provisioned after startup,
+	// which only applies when the config comes from the file
+	var startupWait time.Duration
+	if value := os.Getenv("STARTUP_WAIT_FOR_CONFIG_SECONDS"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		switch {
+		case err != nil || seconds < 0:
+			log.Printf("Invalid STARTUP_WAIT_FOR_CONFIG_SECONDS %q, not waiting for the config", value)
+		case inlineSource != nil || os.Getenv("CONFIG_URL") != "" || os.Getenv("USE_K8S_DISCOVERY") == "true":
+			log.Println("STARTUP_WAIT_FOR_CONFIG_SECONDS only applies to config files, not waiting for the config")
+		default:
+			startupWait = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// Check if config file exists, create default if not; when waiting for
+	// the config, the default is only used once the wait times out
+	// A config fetched from CONFIG_URL must not be mixed with a default file
+	if _, err := fsys.Stat(configPath); os.IsNotExist(err) && startupWait == 0 && inlineSource == nil && os.Getenv("CONFIG_URL") == "" {
 		log.Printf("Config file %s does not exist, creating default", configPath)
 		defaultConfig := `# Service Monitor Configuration
 
@@ -192,95 +143,318 @@ down_services = [
 		}
 	}
 
+	// Register metrics on a dedicated registry, keeping the standard Go and process collectors
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector())
+	registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	metrics := NewMetrics(registry)
+
+	configureMemoryLimit(metrics)
+
+	// Optionally cache gathered metrics between scrapes
+	if value := os.Getenv("GATHER_CACHE_TTL_MS"); value != "" {
+		ttl, err := strconv.Atoi(value)
+		if err != nil || ttl < 0 {
+			log.Printf("Invalid GATHER_CACHE_TTL_MS %q, gather cache disabled", value)
+		} else {
+			metrics.Gatherer.TTL = time.Duration(ttl) * time.Millisecond
+			log.Printf("Caching gathered metrics for %s", metrics.Gatherer.TTL)
+		}
+	}
+
+	if value := os.Getenv("RUNTIME_METRICS_INTERVAL_SECONDS"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			log.Printf("Invalid RUNTIME_METRICS_INTERVAL_SECONDS %q, using %s", value, defaultRuntimeMetricsInterval)
+		} else {
+			metrics.RuntimeMetrics.Interval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// Pool probe connections per endpoint
+	if value := os.Getenv("PROBE_MAX_IDLE_CONNS_PER_HOST"); value != "" {
+		conns, err := strconv.Atoi(value)
+		if err != nil || conns <= 0 {
+			log.Printf("Invalid PROBE_MAX_IDLE_CONNS_PER_HOST %q, using %d", value, defaultProbeMaxIdleConnsPerHost)
+		} else {
+			metrics.Prober.Transport.MaxIdleConnsPerHost = conns
+		}
+	}
+	if value := os.Getenv("PROBE_IDLE_CONN_TIMEOUT_SECONDS"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			log.Printf("Invalid PROBE_IDLE_CONN_TIMEOUT_SECONDS %q, using %s", value, defaultProbeIdleConnTimeout)
+		} else {
+			metrics.Prober.Transport.IdleConnTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	serverCfg := &ServerConfig{
+		ConfigPath:         configPath,
+		FS:                 fsys,
+		EnableMetricsReset: os.Getenv("ENABLE_METRICS_RESET") == "true",
+		AdminUsername:      os.Getenv("ADMIN_USERNAME"),
+		AdminPassword:      os.Getenv("ADMIN_PASSWORD"),
+		StateExportPath:    os.Getenv("STATE_EXPORT_PATH"),
+		SnapshotBaseDir:    os.Getenv("SNAPSHOT_BASE_DIR"),
+		MockServer:         *mockServer,
+		LogRequestBodies:   os.Getenv("REQUEST_LOG_BODIES") == "true",
+		RedactBodyFields:   parseRedactPaths(os.Getenv("REDACT_BODY_FIELDS")),
+	}
+	if value := os.Getenv("ACTIVE_REQUESTS_EMA_ALPHA"); value != "" {
+		alpha, err := strconv.ParseFloat(value, 64)
+		if err != nil || alpha <= 0 || alpha > 1 {
+			log.Printf("Invalid ACTIVE_REQUESTS_EMA_ALPHA %q, using %v", value, defaultEMAAlpha)
+		} else {
+			serverCfg.EMAAlpha = alpha
+		}
+	}
+	if value := os.Getenv("HEALTH_CHECK_CACHE_TTL_SECONDS"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			log.Printf("Invalid HEALTH_CHECK_CACHE_TTL_SECONDS %q, using %s", value, defaultHealthCheckCacheTTL)
+		} else {
+			serverCfg.HealthCheckCacheTTL = time.Duration(seconds) * time.Second
+		}
+	}
+	if value := os.Getenv("MAX_DIFF_SNAPSHOTS"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			log.Printf("Invalid MAX_DIFF_SNAPSHOTS %q, using %d", value, defaultMaxDiffSnapshots)
+		} else {
+			serverCfg.MaxDiffSnapshots = size
+		}
+	}
+	if value := os.Getenv("STATE_EXPORT_INTERVAL_SECONDS"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			log.Printf("Invalid STATE_EXPORT_INTERVAL_SECONDS %q, exporting on every change", value)
+		} else {
+			serverCfg.StateExportInterval = time.Duration(seconds) * time.Second
+		}
+	}
+	if path := os.Getenv("EVENT_LOG_PATH"); path != "" {
+		eventLog, err := openEventLog(path)
+		if err != nil {
+			log.Printf("Event log disabled: %v", err)
+		} else {
+			serverCfg.EventLog = eventLog
+			go eventLog.run(metrics, eventLogFlushInterval)
+			log.Printf("Appending status change events to %s", path)
+		}
+	}
+	if addr := os.Getenv("STATSD_ADDR"); addr != "" {
+		sampleRate := 1.0
+		if value := os.Getenv("STATSD_SAMPLING_RATE"); value != "" {
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				log.Printf("Invalid STATSD_SAMPLING_RATE %q, using %v", value, sampleRate)
+			} else {
+				sampleRate = rate
+			}
+		}
+		bridge, err := NewStatsDBridge(addr, os.Getenv("STATSD_PREFIX"), sampleRate)
+		if err != nil {
+			log.Printf("StatsD forwarding disabled: %v", err)
+		} else {
+			serverCfg.StatsD = bridge
+			go bridge.run(metrics, statsdFlushInterval)
+			log.Printf("Forwarding service status to StatsD at %s", addr)
+		}
+	}
+	if value := os.Getenv("MAX_REQUEST_BODY_BYTES"); value != "" {
+		limit, err := parseByteSize(value)
+		if err != nil || limit == 0 {
+			log.Printf("Invalid MAX_REQUEST_BODY_BYTES %q, using %d", value, defaultMaxRequestBodyBytes)
+		} else {
+			serverCfg.MaxRequestBodyBytes = limit
+		}
+	}
+	if serverCfg.EnableMetricsReset {
+		log.Println("WARNING: /metrics/reset is enabled, do not use this in production")
+	}
+	if serverCfg.LogRequestBodies {
+		log.Printf("Logging request bodies of /config/diff and /config/validate, redacting %d fields", len(serverCfg.RedactBodyFields))
+	}
+	if serverCfg.MockServer {
+		log.Println("Mock server mode: serving /mock/{service}/up and /mock/{service}/down")
+	}
+	if serverCfg.AdminUsername == "" || serverCfg.AdminPassword == "" {
+		log.Println("ADMIN_USERNAME or ADMIN_PASSWORD not set, /admin endpoints are disabled")
+	}
+
 	// Initial config load
-	config, err := loadConfig()
+	var config *Config
+	var err error
+	if inlineSource != nil {
+		config, err = inlineSource.load()
+	} else {
+		config, err = timedLoadConfig(metrics, fsys, configPath, loadTriggerStartup)
+	}
 	if err != nil {
-		log.Printf("Error loading initial config: %v", err)
+		if startupWait > 0 {
+			log.Printf("Error loading initial config, waiting up to %s for it: %v", startupWait, err)
+			serverCfg.StartupGate = newStartupGate()
+		} else {
+			log.Printf("Error loading initial config: %v", err)
+		}
 		config = &Config{
 			UpServices:   []string{"default-service"},
 			DownServices: []string{},
 		}
 	} else {
-		log.Printf("Loaded initial config with %d up services and %d down services", 
+		log.Printf("Loaded initial config with %d up services and %d down services",
 			len(config.UpServices), len(config.DownServices))
 	}
-	
-	// Set initial last modified time
-	fileInfo, err := os.Stat(configPath)
-	if err == nil {
-		lastModTime = fileInfo.ModTime()
-	}
-	
-	// Initialize metrics with config
-	updateServiceMetrics(config)
-	
-	// Start config watcher in background
-	go watchConfig()
-
-	// Health check endpoint
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		activeRequests.Inc()
-		defer activeRequests.Dec()
-
-		start := time.Now()
-		defer func() {
-			duration := time.Since(start).Seconds()
-			requestDuration.Observe(duration)
-			requestsProcessed.Inc()
-		}()
-
-		// Simulate some processing time
-		processingTime := rand.Float64() * 0.5
-		time.Sleep(time.Duration(processingTime * float64(time.Second)))
 
-		// Randomly generate errors (10% of the time)
-		if rand.Float64() < 0.1 {
-			errorRate.Set(0.1)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Internal Server Error"))
-			return
+	// Histograms can't change schema once they have observations, so only the
+	// initial config picks it
+	if schema := config.HistogramConfig.schema(); schema != histogramSchemaClassic {
+		if err := metrics.ConfigureRequestDuration(config.HistogramConfig); err != nil {
+			log.Printf("Error configuring %s request duration histogram: %v", schema, err)
+		} else {
+			log.Printf("Using %s request duration histogram", schema)
 		}
+	}
 
-		errorRate.Set(0.0)
-		w.Write([]byte("Service Monitor is running!"))
-	})
-	
-	// Config update endpoint
-	http.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
-		configMutex.RLock()
-		defer configMutex.RUnlock()
-		
-		config, err := loadConfig()
+	// Set initial last modified time
+	if modTime, target, err := statConfigFile(fsys, configPath); err == nil {
+		lastModTime, lastConfigTarget = modTime, target
+	}
+
+	// Keep the applied configs, persisted across restarts when CONFIG_HISTORY_PATH is set
+	serverCfg.ConfigHistory = NewConfigHistory(config.configHistoryDepth())
+	if path := os.Getenv("CONFIG_HISTORY_PATH"); path != "" {
+		history, err := openConfigHistory(path, config.configHistoryDepth())
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Error loading config: %v", err)
-			return
+			log.Printf("Config history will not be persisted: %v", err)
+		} else {
+			serverCfg.ConfigHistory = history
+			log.Printf("Persisting config history to %s", path)
+		}
+	}
+
+	// Initialize metrics and handlers with config, unless it is still awaited
+	if serverCfg.StartupGate == nil {
+		applyConfig(metrics, serverCfg, config, loadTriggerStartup)
+	}
+
+	// Write throttled state exports in the background
+	if exporter := serverCfg.stateExporter(); exporter != nil && serverCfg.StateExportInterval > 0 {
+		go exporter.run(metrics)
+	}
+
+	// Every reload, whatever its trigger, runs on the reload queue
+	serverCfg.Reloads = newConfigReloadQueue(metrics.ConfigReloadQueueDepth)
+
+	// Poll CONFIG_INLINE, discover services from Kubernetes or fetch the config
+	// from a config server when enabled, otherwise watch the config file
+	// The source is picked before SIGHUP can trigger a reload from it
+	var remoteSource *RemoteConfigSource
+	discovery := newK8sDiscovery(k8sDiscoveryInterval)
+	if inlineSource != nil {
+		serverCfg.ConfigSource = configSourceInline
+	} else if configURL := os.Getenv("CONFIG_URL"); configURL != "" {
+		interval := defaultRemoteConfigInterval
+		if value := os.Getenv("CONFIG_URL_INTERVAL_SECONDS"); value != "" {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				log.Printf("Invalid CONFIG_URL_INTERVAL_SECONDS %q, using %s", value, interval)
+			} else {
+				interval = time.Duration(seconds) * time.Second
+			}
 		}
-		
-		fmt.Fprintf(w, "UP SERVICES (%d):\n", len(config.UpServices))
-		for _, svc := range config.UpServices {
-			fmt.Fprintf(w, "- %s\n", svc)
+		remoteSource = NewRemoteConfigSource(configURL, os.Getenv("CONFIG_URL_BEARER_TOKEN"), interval)
+		serverCfg.ConfigSource, serverCfg.RemoteConfig = configSourceRemote, remoteSource
+	} else if os.Getenv("USE_K8S_DISCOVERY") == "true" && discovery.start(metrics, serverCfg) {
+		serverCfg.ConfigSource = configSourceKubernetes
+	} else {
+		serverCfg.ConfigSource = configSourceFile
+	}
+
+	// Shut down on SIGINT or SIGTERM and reload the config on SIGHUP
+	ctx, stop := handleSignals(metrics, serverCfg)
+	defer stop()
+	go serverCfg.Reloads.run(ctx)
+
+	switch serverCfg.ConfigSource {
+	case configSourceInline:
+		go inlineSource.run(ctx, metrics, serverCfg)
+	case configSourceRemote:
+		go remoteSource.run(ctx, metrics, serverCfg)
+	case configSourceKubernetes:
+		go discovery.run(ctx, metrics, serverCfg)
+	case configSourceFile:
+		go func() {
+			if serverCfg.StartupGate != nil {
+				waitForInitialConfig(ctx, metrics, serverCfg, config, startupWait)
+			}
+			watchConfig(ctx, metrics, serverCfg)
+		}()
+	}
+
+	// Adjust GOGC to memory pressure
+	go runGCTuner(metrics, gcTunerInterval)
+
+	// Track CFS throttling when running in a cgroups v2 container
+	go runCPUThrottlingMonitor(metrics, cpuThrottlingInterval)
+
+	// Watch for goroutine leaks once startup has settled
+	go runGoroutineMonitor(serverCfg.goroutineMonitor(), goroutineBaselineDelay, goroutineCheckInterval)
+
+	// Start availability probes for SLO tracking
+	sloTracker := NewSLOTracker(config.SLO.windowSize(), config.SLO.historyDepth())
+	if value := os.Getenv("PROBE_HISTORY_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			log.Printf("Invalid PROBE_HISTORY_SIZE %q, using %d", value, defaultHealthCheckHistorySize)
+		} else {
+			sloTracker.healthChecks = NewHealthCheckHistory(size)
 		}
-		
-		fmt.Fprintf(w, "\nDOWN SERVICES (%d):\n", len(config.DownServices))
-		for _, svc := range config.DownServices {
-			fmt.Fprintf(w, "- %s\n", svc)
+	}
+	probeWorkers := defaultProbeWorkers
+	if value := os.Getenv("PROBE_WORKERS"); value != "" {
+		workers, err := strconv.Atoi(value)
+		if err != nil || workers <= 0 {
+			log.Printf("Invalid PROBE_WORKERS %q, using %d", value, defaultProbeWorkers)
+		} else {
+			probeWorkers = workers
 		}
-	})
+	}
+	serverCfg.SLOTracker = sloTracker
+	go runSLOProbes(ctx, metrics, sloTracker, config.SLO.probeInterval(), probeWorkers)
 
-	// Metrics endpoint for Prometheus
-	http.Handle("/metrics", promhttp.Handler())
+	appAddr := os.Getenv("APP_ADDR")
+	if appAddr == "" {
+		appAddr = ":8080"
+	}
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = ":9090"
+	}
+	serverCfg.AppAddr, serverCfg.MetricsAddr = appAddr, metricsAddr
+	recordCapabilities(metrics, serverCfg)
+
+	appServer := &http.Server{Addr: appAddr, Handler: wrapHandler(serverCfg, NewAppServeMux(metrics, serverCfg))}
+	metricsServer := &http.Server{Addr: metricsAddr, Handler: wrapHandler(serverCfg, NewMetricsServeMux(metrics, serverCfg))}
 
 	// Start a background routine to update general metrics
-	go func() {
-		for {
-			// Simulate fluctuating load
-			load := rand.Float64() * 10
-			activeRequests.Set(load)
-			time.Sleep(5 * time.Second)
-		}
-	}()
+	go simulateLoad(metrics, serverCfg.loadAverage(), 5*time.Second)
 
-	log.Println("Starting Service Monitor on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
\ No newline at end of file
+	log.Printf("Starting Service Monitor on %s (metrics on %s)", appAddr, metricsAddr)
+	err = runServers(ctx, appServer, metricsServer)
+	if serverCfg.EventLog != nil {
+		if closeErr := serverCfg.EventLog.Close(metrics); closeErr != nil {
+			log.Printf("Error closing event log: %v", closeErr)
+		}
+	}
+	if closeErr := serverCfg.ConfigHistory.Close(); closeErr != nil {
+		log.Printf("Error closing config history: %v", closeErr)
+	}
+	if base64ConfigPath != "" {
+		os.Remove(base64ConfigPath)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
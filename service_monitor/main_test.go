@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testBinaryTimeout bounds the whole test run so a hung test can't stall CI
+const testBinaryTimeout = 5 * time.Minute
+
+func TestMain(m *testing.M) {
+	timer := time.AfterFunc(testBinaryTimeout, func() {
+		log.Printf("FATAL: tests did not finish within %s, forcing exit due to timeout", testBinaryTimeout)
+		os.Exit(1)
+	})
+
+	code := m.Run()
+	timer.Stop()
+	os.Exit(code)
+}
+
+// freeAddr returns a loopback address with a port that was free a moment ago
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// integrationGet fetches url with a short timeout and returns the status and body
+func integrationGet(url string) (int, string, error) {
+	client := &http.Client{Timeout: 500 * time.Millisecond}
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+// startServiceMonitor builds the binary and runs it with env added to the
+// environment and free listen addresses, waiting until /healthz answers
+// within 2 seconds; the process is stopped when the test ends
+func startServiceMonitor(t *testing.T, env ...string) (appAddr, metricsAddr string) {
+	t.Helper()
+	if os.Getenv("RUN_INTEGRATION_TESTS") == "" {
+		t.Skip("set RUN_INTEGRATION_TESTS=1 to run the subprocess test")
+	}
+
+	bin := filepath.Join(t.TempDir(), "service_monitor")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, out)
+	}
+
+	appAddr, metricsAddr = freeAddr(t), freeAddr(t)
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), "APP_ADDR="+appAddr, "METRICS_ADDR="+metricsAddr)
+	cmd.Env = append(cmd.Env, env...)
+	var logs syncBuffer
+	cmd.Stdout, cmd.Stderr = &logs, &logs
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		if err := cmd.Wait(); err != nil {
+			t.Logf("service_monitor exited with %v\n%s", err, logs.String())
+		}
+	})
+
+	started := waitFor(t, 2*time.Second, func() bool {
+		code, _, err := integrationGet("http://" + appAddr + "/healthz")
+		return err == nil && code == http.StatusOK
+	})
+	if !started {
+		t.Fatalf("/healthz on APP_ADDR %s not ready within 2s\n%s", appAddr, logs.String())
+	}
+	return appAddr, metricsAddr
+}
+
+func TestMain_EnvironmentVariableOverrides(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "services.toml")
+	writeConfigAt(t, configPath, "up_services = [\"env-api\"]\ndown_services = [\"env-db\"]\n", time.Now())
+
+	appAddr, metricsAddr := startServiceMonitor(t, "CONFIG_PATH="+configPath)
+
+	if code, _, err := integrationGet("http://" + metricsAddr + "/metrics"); err != nil || code != http.StatusOK {
+		t.Errorf("expected /metrics on METRICS_ADDR %s, got %d, %v", metricsAddr, code, err)
+	}
+
+	code, body, err := integrationGet("http://" + appAddr + "/config")
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("GET /config: %d, %v", code, err)
+	}
+	if !strings.Contains(body, "env-api") || !strings.Contains(body, "env-db") {
+		t.Errorf("expected the config from CONFIG_PATH, got:\n%s", body)
+	}
+}
+
+func TestMain_InlineConfig(t *testing.T) {
+	inline := base64.StdEncoding.EncodeToString([]byte(`{"up_services":["inline-api"],"down_services":["inline-db"]}`))
+	_, metricsAddr := startServiceMonitor(t,
+		"CONFIG_PATH="+filepath.Join(t.TempDir(), "missing.toml"),
+		"CONFIG_INLINE="+inline,
+		"CONFIG_INLINE_FORMAT=json",
+	)
+
+	code, body, err := integrationGet("http://" + metricsAddr + "/metrics")
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("GET /metrics: %d, %v", code, err)
+	}
+	for _, want := range []string{`service_monitor_up{service="inline-api"} 1`, `service_monitor_up{service="inline-db"} 0`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in the metrics", want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// byteSizeSuffixes maps supported size suffixes to their multipliers
+var byteSizeSuffixes = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+	{"K", 1000},
+	{"M", 1000 * 1000},
+	{"G", 1000 * 1000 * 1000},
+	{"T", 1000 * 1000 * 1000 * 1000},
+}
+
+// parseByteSize parses a byte count with an optional Kubernetes-style
+// suffix, e.g. "536870912", "512Mi" or "1Gi"
+func parseByteSize(value string) (int64, error) {
+	number := strings.TrimSpace(value)
+	multiplier := int64(1)
+	for _, s := range byteSizeSuffixes {
+		if strings.HasSuffix(number, s.suffix) {
+			number = strings.TrimSuffix(number, s.suffix)
+			multiplier = s.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", value)
+	}
+	if n > (1<<63-1)/multiplier {
+		return 0, fmt.Errorf("byte size %q overflows", value)
+	}
+	return n * multiplier, nil
+}
+
+// cgroupMemoryLimitFiles are checked in order for the container memory limit
+var cgroupMemoryLimitFiles = []string{
+	"/sys/fs/cgroup/memory.max",                   // cgroups v2
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes", // cgroups v1
+}
+
+// cgroupUnlimited is the threshold above which a cgroups v1 limit means no limit
+const cgroupUnlimited = 1 << 60
+
+// cgroupMemoryLimitFraction leaves headroom below the cgroup limit for non-heap memory
+const cgroupMemoryLimitFraction = 0.9
+
+// detectCgroupMemoryLimit returns the container memory limit and whether one is set
+func detectCgroupMemoryLimit() (int64, bool) {
+	for _, path := range cgroupMemoryLimitFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		value := strings.TrimSpace(string(data))
+		if value == "max" {
+			return 0, false
+		}
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 || limit >= cgroupUnlimited {
+			return 0, false
+		}
+		return limit, true
+	}
+	return 0, false
+}
+
+// configureMemoryLimit applies the soft memory limit from GO_MEMORY_LIMIT_BYTES,
+// or 90% of the cgroup memory limit when it isn't set, and exposes the result
+func configureMemoryLimit(m *Metrics) {
+	if value := os.Getenv("GO_MEMORY_LIMIT_BYTES"); value != "" {
+		limit, err := parseByteSize(value)
+		if err != nil {
+			log.Printf("Invalid GO_MEMORY_LIMIT_BYTES: %v", err)
+		} else {
+			debug.SetMemoryLimit(limit)
+			log.Printf("Set Go memory limit to %d bytes", limit)
+		}
+	} else if cgroupLimit, ok := detectCgroupMemoryLimit(); ok {
+		limit := int64(float64(cgroupLimit) * cgroupMemoryLimitFraction)
+		debug.SetMemoryLimit(limit)
+		log.Printf("Detected cgroup memory limit of %d bytes, set Go memory limit to %d bytes", cgroupLimit, limit)
+	}
+
+	// A negative value reads the current limit without changing it
+	m.MemoryLimit.Set(float64(debug.SetMemoryLimit(-1)))
+}
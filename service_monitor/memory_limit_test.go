@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"1024", 1024},
+		{"512Mi", 512 << 20},
+		{"1Gi", 1 << 30},
+		{"64Ki", 64 << 10},
+		{"2G", 2000000000},
+		{" 256Mi ", 256 << 20},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.value)
+		if err != nil {
+			t.Errorf("parseByteSize(%q) returned error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "Mi", "-1Gi", "1.5Gi", "abc", "99999999999Ti"} {
+		if _, err := parseByteSize(value); err == nil {
+			t.Errorf("parseByteSize(%q) expected an error", value)
+		}
+	}
+}
+
+func TestDetectCgroupMemoryLimit(t *testing.T) {
+	dir := t.TempDir()
+	v2 := filepath.Join(dir, "memory.max")
+	v1 := filepath.Join(dir, "memory.limit_in_bytes")
+
+	origFiles := cgroupMemoryLimitFiles
+	cgroupMemoryLimitFiles = []string{v2, v1}
+	defer func() { cgroupMemoryLimitFiles = origFiles }()
+
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := detectCgroupMemoryLimit(); ok {
+		t.Error("expected no limit without cgroup files")
+	}
+
+	// cgroups v1 only
+	write(v1, "536870912\n")
+	if limit, ok := detectCgroupMemoryLimit(); !ok || limit != 536870912 {
+		t.Errorf("expected v1 limit 536870912, got %d (%v)", limit, ok)
+	}
+
+	write(v1, "9223372036854771712\n")
+	if _, ok := detectCgroupMemoryLimit(); ok {
+		t.Error("expected the v1 unlimited value to mean no limit")
+	}
+
+	// cgroups v2 takes precedence
+	write(v2, "1073741824\n")
+	if limit, ok := detectCgroupMemoryLimit(); !ok || limit != 1073741824 {
+		t.Errorf("expected v2 limit 1073741824, got %d (%v)", limit, ok)
+	}
+
+	write(v2, "max\n")
+	if _, ok := detectCgroupMemoryLimit(); ok {
+		t.Error("expected max to mean no limit")
+	}
+}
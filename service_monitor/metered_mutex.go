@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// mutexWaitBuckets spans lock waits from 1µs to 1s
+var mutexWaitBuckets = prometheus.ExponentialBucketsRange(1e-6, 1, 13)
+
+// MeteredRWMutex is a sync.RWMutex that records how long callers wait to
+// acquire it and how many currently hold it
+// It is a prometheus.Collector, so one mutex can be registered with every
+// registry that should expose it
+type MeteredRWMutex struct {
+	mu sync.RWMutex
+
+	readWait     prometheus.Histogram
+	writeWait    prometheus.Histogram
+	readHolders  atomic.Int32
+	writeHolders atomic.Int32
+
+	collectors []prometheus.Collector
+}
+
+// NewMeteredRWMutex creates a mutex whose metrics are named
+// service_monitor_<name>_mutex_*
+func NewMeteredRWMutex(name string) *MeteredRWMutex {
+	m := &MeteredRWMutex{
+		readWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    fmt.Sprintf("service_monitor_%s_mutex_read_wait_seconds", name),
+			Help:    fmt.Sprintf("Time spent waiting to read-lock the %s mutex", name),
+			Buckets: mutexWaitBuckets,
+		}),
+		writeWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    fmt.Sprintf("service_monitor_%s_mutex_write_wait_seconds", name),
+			Help:    fmt.Sprintf("Time spent waiting to write-lock the %s mutex", name),
+			Buckets: mutexWaitBuckets,
+		}),
+	}
+	m.collectors = []prometheus.Collector{
+		m.readWait,
+		m.writeWait,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("service_monitor_%s_mutex_read_holders", name),
+			Help: fmt.Sprintf("Number of goroutines holding a read lock on the %s mutex", name),
+		}, func() float64 { return float64(m.readHolders.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("service_monitor_%s_mutex_write_holders", name),
+			Help: fmt.Sprintf("Number of goroutines holding the write lock on the %s mutex (0 or 1)", name),
+		}, func() float64 { return float64(m.writeHolders.Load()) }),
+	}
+	return m
+}
+
+func (m *MeteredRWMutex) Lock() {
+	start := time.Now()
+	m.mu.Lock()
+	m.writeWait.Observe(time.Since(start).Seconds())
+	m.writeHolders.Add(1)
+}
+
+func (m *MeteredRWMutex) Unlock() {
+	m.writeHolders.Add(-1)
+	m.mu.Unlock()
+}
+
+func (m *MeteredRWMutex) RLock() {
+	start := time.Now()
+	m.mu.RLock()
+	m.readWait.Observe(time.Since(start).Seconds())
+	m.readHolders.Add(1)
+}
+
+func (m *MeteredRWMutex) RUnlock() {
+	m.readHolders.Add(-1)
+	m.mu.RUnlock()
+}
+
+func (m *MeteredRWMutex) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors {
+		c.Describe(ch)
+	}
+}
+
+func (m *MeteredRWMutex) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors {
+		c.Collect(ch)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// mutexHistogram returns the current state of a wait histogram of mu
+func mutexHistogram(tb testing.TB, h prometheus.Histogram) *dto.Histogram {
+	tb.Helper()
+	var metric dto.Metric
+	if err := h.Write(&metric); err != nil {
+		tb.Fatalf("failed to read histogram: %v", err)
+	}
+	return metric.GetHistogram()
+}
+
+// histogramQuantile estimates quantile q from cumulative buckets by linear
+// interpolation, like PromQL's histogram_quantile
+func histogramQuantile(h *dto.Histogram, q float64) float64 {
+	rank := q * float64(h.GetSampleCount())
+	lower, prevCount := 0.0, 0.0
+	for _, b := range h.GetBucket() {
+		count := float64(b.GetCumulativeCount())
+		if count >= rank && count > prevCount {
+			return lower + (b.GetUpperBound()-lower)*(rank-prevCount)/(count-prevCount)
+		}
+		lower, prevCount = b.GetUpperBound(), count
+	}
+	return lower
+}
+
+func TestMeteredRWMutex_Holders(t *testing.T) {
+	mu := NewMeteredRWMutex("test")
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(mu)
+
+	mu.RLock()
+	mu.RLock()
+	if v := testutil.ToFloat64(mu.collectors[2]); v != 2 {
+		t.Errorf("expected 2 read holders, got %v", v)
+	}
+	mu.RUnlock()
+	mu.RUnlock()
+
+	mu.Lock()
+	if v := testutil.ToFloat64(mu.collectors[3]); v != 1 {
+		t.Errorf("expected 1 write holder, got %v", v)
+	}
+
+	// A reader blocked behind the writer records its wait once it gets in
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mu.RLock()
+		mu.RUnlock()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	mu.Unlock()
+	<-done
+
+	if v := testutil.ToFloat64(mu.collectors[2]) + testutil.ToFloat64(mu.collectors[3]); v != 0 {
+		t.Errorf("expected no holders after unlocking, got %v", v)
+	}
+	read := mutexHistogram(t, mu.readWait)
+	if read.GetSampleCount() != 3 || read.GetSampleSum() < 0.01 {
+		t.Errorf("expected 3 read waits including the blocked one, got %d summing to %vs", read.GetSampleCount(), read.GetSampleSum())
+	}
+	if n := mutexHistogram(t, mu.writeWait).GetSampleCount(); n != 1 {
+		t.Errorf("expected 1 write wait, got %d", n)
+	}
+
+	if n, err := testutil.GatherAndCount(reg); err != nil || n != 4 {
+		t.Errorf("expected 4 metrics from the mutex, got %d (%v)", n, err)
+	}
+}
+
+// BenchmarkMeteredRWMutex_Contention has 10 readers and 1 writer compete for
+// the mutex and reports the wait quantiles recorded by its histograms
+func BenchmarkMeteredRWMutex_Contention(b *testing.B) {
+	const readers = 10
+	mu := NewMeteredRWMutex("bench")
+	state := 0
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < b.N; n++ {
+				mu.RLock()
+				_ = state
+				time.Sleep(time.Microsecond)
+				mu.RUnlock()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < b.N; n++ {
+			mu.Lock()
+			state++
+			mu.Unlock()
+		}
+	}()
+	wg.Wait()
+	b.StopTimer()
+
+	for name, h := range map[string]prometheus.Histogram{"read": mu.readWait, "write": mu.writeWait} {
+		hist := mutexHistogram(b, h)
+		p50, p99 := histogramQuantile(hist, 0.5), histogramQuantile(hist, 0.99)
+		if p50 <= 0 || p99 <= 0 {
+			b.Fatalf("expected non-zero %s wait quantiles, got p50=%v p99=%v", name, p50, p99)
+		}
+		b.ReportMetric(p50*1e9, name+"-p50-ns")
+		b.ReportMetric(p99*1e9, name+"-p99-ns")
+	}
+}
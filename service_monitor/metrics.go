@@ -0,0 +1,570 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Metrics holds the Prometheus collectors exposed by the service monitor
+type Metrics struct {
+	// Registry the collectors are registered on
+	Registry *prometheus.Registry
+
+	// Gatherer serves the registry's metrics, optionally cached
+	Gatherer *CachedGatherer
+
+	// Exposition renames labels of the gathered metrics for /metrics
+	Exposition *LabelAliasGatherer
+
+	RequestsProcessed prometheus.Counter
+	RejectedRequests  prometheus.Counter
+	RequestDuration   prometheus.Histogram
+	ActiveRequests    prometheus.Gauge
+	ErrorRate         prometheus.Gauge
+
+	// Status of monitored services (1=up, 0=down)
+	ServiceStatus *prometheus.GaugeVec
+
+	// Status series deleted because their service left the config
+	StaleMarkers prometheus.Counter
+
+	// Status taking upstream dependencies into account (1=up, 0=down)
+	EffectiveStatus *prometheus.GaugeVec
+
+	// Custom labels of each service, always 1
+	ServiceLabels *serviceLabelsCollector
+
+	// Number of status updates that found a dependency cycle
+	DependencyCycles prometheus.Counter
+
+	// Fraction of probes in the SLO window that found the service up
+	AvailabilityRatio *prometheus.GaugeVec
+
+	// SLO probes that panicked
+	ProbeErrors prometheus.Counter
+
+	// Probes dropped because the service was still queued from an earlier round
+	ProbeCoalesced prometheus.Counter
+
+	// Time the probe worker waited for the next probe after finishing one
+	ProbeWorkerIdle prometheus.Histogram
+
+	// Probe workers running a probe, compared to ProbeWorkerCount
+	ActiveProbeGoroutines prometheus.Gauge
+
+	// Probe workers started
+	ProbeWorkerCount prometheus.Gauge
+
+	// Services whose first probe was delayed by a random jitter
+	ProbeJitterApplied prometheus.Counter
+
+	// Probes run through /probe by probe type and result
+	OnDemandProbes *prometheus.CounterVec
+
+	// Open probe connections, including idle ones kept for reuse
+	ProbeConnectionsActive prometheus.Gauge
+
+	// Connections opened by probes
+	ProbeTCPConnections prometheus.Counter
+
+	// Probes that failed to connect, by error type
+	ProbeConnectionErrors *prometheus.CounterVec
+
+	// Traffic of the probe connections by service
+	ProbeBytesSent     *prometheus.CounterVec
+	ProbeBytesReceived *prometheus.CounterVec
+
+	// Runs the probes over a shared transport tracked by the connection metrics
+	Prober *Prober
+
+	// Health check results kept across all services
+	ProbeHistoryEntries prometheus.Gauge
+
+	// Time /metrics was held back waiting for the initial config
+	StartupWait prometheus.Gauge
+
+	// Optional features listed by /capabilities (1=enabled, 0=disabled)
+	FeatureEnabled *prometheus.GaugeVec
+
+	// Shape of the currently loaded config file
+	ConfigFileSize  prometheus.Gauge
+	ConfigFileLines prometheus.Gauge
+	ConfigServices  *prometheus.GaugeVec
+
+	// GOGC value currently set by the GC tuner
+	GCTargetPercent prometheus.Gauge
+
+	// Soft memory limit of the Go runtime
+	MemoryLimit prometheus.Gauge
+
+	// Time spent loading the config file, by trigger and result
+	ConfigLoadDuration *prometheus.HistogramVec
+
+	// Time taken by each [health_checks] URL, by URL
+	HealthCheckDuration *prometheus.HistogramVec
+
+	// Bytes actually read from the config file across all loads
+	ConfigBytesRead prometheus.Counter
+
+	// Config loads that left more file descriptors open than before (Linux only)
+	ConfigFDLeaks prometheus.Counter
+
+	// Time the config watcher last checked the file
+	ConfigWatcherHeartbeat prometheus.Gauge
+
+	// Reloads waiting for the reload in progress to finish
+	ConfigReloadQueueDepth prometheus.Gauge
+
+	// Fetches of the config from CONFIG_URL and how many of them failed
+	RemoteConfigFetches     prometheus.Counter
+	RemoteConfigFetchErrors prometheus.Counter
+
+	// Latency of /config and the part of it spent waiting on configMutex
+	ConfigHandlerDuration prometheus.Summary
+	ConfigHandlerLockWait prometheus.Histogram
+
+	// /config requests answered with 304 Not Modified
+	ConfigCacheHits prometheus.Counter
+
+	// CFS throttling of the container's cgroup
+	CPUThrottledPeriods prometheus.Counter
+	CPUThrottledSeconds prometheus.Counter
+
+	// Writes of the STATE_EXPORT_PATH file
+	StateExportWrites      prometheus.Counter
+	StateExportWriteErrors prometheus.Counter
+
+	// Records appended to the EVENT_LOG_PATH file
+	EventLogWrites      prometheus.Counter
+	EventLogWriteErrors prometheus.Counter
+
+	// Datagrams the StatsD bridge failed to send
+	StatsDSendErrors prometheus.Counter
+
+	// Metric snapshots written by /metrics/snapshot
+	Snapshots prometheus.Counter
+
+	// Mutex contention and scheduling latency read from runtime/metrics
+	RuntimeMetrics *RuntimeMetricsCollector
+
+	// Counters and gauges that /metrics/reset replaces with fresh instances
+	resettable []resettable
+	resetMu    sync.Mutex
+}
+
+// NewMetrics creates the service monitor collectors and registers them on reg
+func NewMetrics(reg *prometheus.Registry) *Metrics {
+	requestsProcessed := newResettableCounter(prometheus.CounterOpts{
+		Name: "service_monitor_requests_total",
+		Help: "The total number of processed requests",
+	})
+
+	rejectedRequests := newResettableCounter(prometheus.CounterOpts{
+		Name: "service_monitor_rejected_requests_total",
+		Help: "The total number of requests rejected by the concurrency limit",
+	})
+
+	dependencyCycles := newResettableCounter(prometheus.CounterOpts{
+		Name: "service_monitor_dependency_cycles_total",
+		Help: "The total number of status updates that detected a dependency cycle",
+	})
+
+	activeRequests := newResettableGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_active_requests",
+		Help: "Number of active requests",
+	})
+
+	errorRate := newResettableGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_error_rate",
+		Help: "Current error rate",
+	})
+
+	gatherer := NewCachedGatherer(reg, 0)
+	m := &Metrics{
+		Registry:   reg,
+		Gatherer:   gatherer,
+		Exposition: NewLabelAliasGatherer(gatherer),
+
+		RequestsProcessed: requestsProcessed,
+		RejectedRequests:  rejectedRequests,
+		ActiveRequests:    activeRequests,
+		ErrorRate:         errorRate,
+		DependencyCycles:  dependencyCycles,
+
+		RequestDuration: prometheus.NewHistogram(buildHistogramOpts(HistogramConfig{})),
+
+		ServiceStatus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "service_monitor_up",
+				Help: "Status of monitored services (1=up, 0=down)",
+			},
+			[]string{"service"},
+		),
+
+		EffectiveStatus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "service_monitor_effective_up",
+				Help: "Status of monitored services including their dependencies (1=up, 0=down)",
+			},
+			[]string{"service"},
+		),
+
+		ServiceLabels: newServiceLabelsCollector(),
+
+		AvailabilityRatio: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "service_monitor_availability_ratio",
+				Help: "Fraction of probes in the SLO window that found the service up (-1=insufficient data)",
+			},
+			[]string{"service"},
+		),
+	}
+
+	// Every other counter and gauge is created with newCounter, newGauge or
+	// newCounterVec, which make it resettable too
+	// Gauges that describe the config, such as the service status gauges, and
+	// gauges set once at startup are not resettable
+	for _, metric := range []resettable{requestsProcessed, rejectedRequests, dependencyCycles, activeRequests, errorRate} {
+		m.addResettable(metric)
+	}
+
+	reg.MustRegister(m.RequestDuration)
+	reg.MustRegister(m.ServiceStatus)
+	reg.MustRegister(newServiceAggregateCollector(m.ServiceStatus))
+	reg.MustRegister(m.EffectiveStatus)
+	reg.MustRegister(m.ServiceLabels)
+	reg.MustRegister(m.AvailabilityRatio)
+
+	m.ProbeErrors = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_probe_errors_total",
+		Help: "The total number of SLO probes that panicked",
+	})
+
+	m.StaleMarkers = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_stale_markers_sent_total",
+		Help: "The total number of service status series deleted because the service was removed from the config",
+	})
+
+	m.ProbeCoalesced = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_probe_coalesced_total",
+		Help: "The total number of SLO probes dropped because the service was already queued",
+	})
+
+	// Mostly close to the probe interval when the worker keeps up, and close
+	// to zero when probes queue up behind each other
+	m.ProbeWorkerIdle = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "service_monitor_probe_worker_idle_seconds",
+		Help:    "Time the SLO probe worker waited for the next probe after finishing one",
+		Buckets: prometheus.ExponentialBucketsRange(0.001, 600, 12),
+	})
+	reg.MustRegister(m.ProbeWorkerIdle)
+
+	m.ActiveProbeGoroutines = m.newGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_active_probe_goroutines",
+		Help: "Number of SLO probe workers currently running a probe",
+	})
+
+	m.ProbeWorkerCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_probe_worker_count",
+		Help: "Number of SLO probe workers",
+	})
+	reg.MustRegister(m.ProbeWorkerCount)
+
+	m.ProbeJitterApplied = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_probe_jitter_applied_total",
+		Help: "The total number of services whose first SLO probe was delayed by a random jitter",
+	})
+
+	m.OnDemandProbes = m.newCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_monitor_on_demand_probes_total",
+			Help: "The total number of probes run through /probe",
+		},
+		[]string{"probe_type", "result"},
+	)
+
+	m.ProbeConnectionsActive = m.newGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_probe_connections_active",
+		Help: "Number of open probe connections, including idle ones kept for reuse",
+	})
+
+	m.ProbeTCPConnections = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_probe_tcp_connections_total",
+		Help: "The total number of TCP connections opened by probes",
+	})
+
+	m.ProbeConnectionErrors = m.newCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_monitor_probe_connection_errors_total",
+			Help: "The total number of probes that failed to connect, by error type",
+		},
+		[]string{"error_type"},
+	)
+
+	m.ProbeBytesSent = m.newCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_monitor_probe_bytes_sent_total",
+			Help: "The total number of bytes sent over probe connections",
+		},
+		[]string{"service"},
+	)
+
+	m.ProbeBytesReceived = m.newCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_monitor_probe_bytes_received_total",
+			Help: "The total number of bytes received over probe connections",
+		},
+		[]string{"service"},
+	)
+
+	m.Prober = NewProber(m)
+
+	m.ProbeHistoryEntries = m.newGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_probe_history_entries",
+		Help: "Number of health check results kept for /services/<name>/probe-history across all services",
+	})
+
+	m.StartupWait = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_startup_wait_seconds",
+		Help: "Seconds /metrics was held back at startup waiting for the config file (STARTUP_WAIT_FOR_CONFIG_SECONDS)",
+	})
+	reg.MustRegister(m.StartupWait)
+
+	m.FeatureEnabled = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "service_monitor_feature_enabled",
+			Help: "Whether an optional feature listed by /capabilities is enabled (1=enabled, 0=disabled)",
+		},
+		[]string{"feature"},
+	)
+	reg.MustRegister(m.FeatureEnabled)
+	reg.MustRegister(newGCCollector())
+
+	m.ConfigFileSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_config_file_size_bytes",
+		Help: "Size of the currently loaded config file",
+	})
+	m.ConfigFileLines = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_config_file_lines_total",
+		Help: "Number of lines in the currently loaded config file",
+	})
+	m.ConfigServices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "service_monitor_config_services_total",
+			Help: "Number of services in the currently loaded config by status",
+		},
+		[]string{"status"},
+	)
+	reg.MustRegister(m.ConfigFileSize, m.ConfigFileLines, m.ConfigServices)
+
+	m.GCTargetPercent = m.newGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_gc_target_percent",
+		Help: "Current GOGC garbage collection target percentage",
+	})
+
+	m.MemoryLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_memory_limit_bytes",
+		Help: "Soft memory limit configured for the Go runtime",
+	})
+	reg.MustRegister(m.MemoryLimit)
+
+	// Internal process timing is kept apart from the service state metrics
+	m.ConfigLoadDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "service_monitor_config_load_duration_seconds",
+			Help:    "Time taken to open, read and parse the config file",
+			Buckets: prometheus.ExponentialBucketsRange(0.0001, 10, 11),
+		},
+		[]string{"trigger", "result"},
+	)
+	reg.MustRegister(m.ConfigLoadDuration)
+
+	m.HealthCheckDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "service_monitor_health_check_duration_seconds",
+			Help:    "Time taken by the requests to the [health_checks] URLs behind /health",
+			Buckets: prometheus.ExponentialBucketsRange(0.001, healthCheckTimeout.Seconds(), 10),
+		},
+		[]string{"url"},
+	)
+	reg.MustRegister(m.HealthCheckDuration)
+
+	m.ConfigBytesRead = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_config_bytes_read_total",
+		Help: "The total number of bytes read from the config file",
+	})
+
+	m.ConfigFDLeaks = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_config_fd_leaks_detected_total",
+		Help: "The total number of config loads after which more file descriptors were open than before",
+	})
+
+	m.ConfigWatcherHeartbeat = m.newGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_config_watcher_last_check_timestamp_seconds",
+		Help: "Unix time the config watcher last checked the config file",
+	})
+
+	m.ConfigReloadQueueDepth = m.newGauge(prometheus.GaugeOpts{
+		Name: "service_monitor_config_reload_queue_depth",
+		Help: "Config reloads that wait for the reload in progress (0 or 1)",
+	})
+
+	m.RemoteConfigFetches = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_remote_config_fetches_total",
+		Help: "The total number of attempts to fetch the config from CONFIG_URL",
+	})
+	m.RemoteConfigFetchErrors = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_remote_config_fetch_errors_total",
+		Help: "The total number of config fetches from CONFIG_URL that failed",
+	})
+
+	// /config is dominated by file I/O and lock waits rather than simulated work,
+	// so it gets quantiles of its own instead of sharing RequestDuration
+	m.ConfigHandlerDuration = prometheus.NewSummary(prometheus.SummaryOpts{
+		Name:       "service_monitor_config_handler_duration_seconds",
+		Help:       "Time taken to serve /config",
+		Objectives: map[float64]float64{0.5: 0.05, 0.95: 0.01, 0.99: 0.005},
+	})
+	m.ConfigHandlerLockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "service_monitor_config_handler_lock_wait_seconds",
+		Help:    "Time /config spent waiting to read-lock the config state",
+		Buckets: prometheus.ExponentialBucketsRange(0.00001, 1, 11),
+	})
+	reg.MustRegister(m.ConfigHandlerDuration, m.ConfigHandlerLockWait)
+
+	// configMutex is shared by every Metrics instance and records its own waits
+	reg.MustRegister(configMutex)
+
+	m.ConfigCacheHits = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_config_cache_hits_total",
+		Help: "The total number of /config requests answered with 304 Not Modified",
+	})
+
+	m.CPUThrottledPeriods = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_cpu_throttled_periods_total",
+		Help: "The total number of CFS periods in which the cgroup was throttled",
+	})
+	m.CPUThrottledSeconds = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_cpu_throttled_seconds_total",
+		Help: "The total time the cgroup was throttled by the CFS bandwidth limit",
+	})
+
+	m.StateExportWrites = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_state_export_writes_total",
+		Help: "The total number of successful writes of the service state export file",
+	})
+	m.StateExportWriteErrors = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_state_export_write_errors_total",
+		Help: "The total number of failed writes of the service state export file",
+	})
+
+	m.EventLogWrites = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_event_log_writes_total",
+		Help: "The total number of status change events written to the event log",
+	})
+	m.EventLogWriteErrors = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_event_log_write_errors_total",
+		Help: "The total number of failed event log writes and flushes",
+	})
+
+	m.StatsDSendErrors = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_statsd_send_errors_total",
+		Help: "The total number of StatsD datagrams that could not be sent",
+	})
+
+	m.Snapshots = m.newCounter(prometheus.CounterOpts{
+		Name: "service_monitor_snapshots_total",
+		Help: "The total number of metric snapshots written to disk",
+	})
+
+	m.RuntimeMetrics = NewRuntimeMetricsCollector(defaultRuntimeMetricsInterval)
+	reg.MustRegister(m.RuntimeMetrics)
+
+	return m
+}
+
+// updateServiceMetrics updates the Prometheus metrics based on service status
+// and publishes a copy of config, so later changes by the caller never reach readers
+// The caller must hold configMutex for writing when other goroutines are running
+func updateServiceMetrics(m *Metrics, config *Config) {
+	recordStatusChanges(loadCurrentConfig(), config, time.Now())
+	currentConfig.Store(config.clone())
+
+	configured := configuredServices(config)
+	deleteRemovedServices(m, configured)
+	cancelRemovedProbes(configured)
+
+	// Set up services as 1
+	for _, service := range config.UpServices {
+		m.ServiceStatus.WithLabelValues(service).Set(1)
+	}
+
+	// Set down services as 0
+	for _, service := range config.DownServices {
+		m.ServiceStatus.WithLabelValues(service).Set(0)
+	}
+
+	updateEffectiveMetrics(m, config)
+	updateServiceLabels(m, config)
+
+	// Service status must be visible on the very next scrape
+	m.Gatherer.Invalidate()
+}
+
+// deleteRemovedServices deletes the status series of services that are no
+// longer configured, so Prometheus marks them stale on its next scrape
+// Series of the remaining services are kept rather than reset, so a scrape
+// during the update never misses one and marks it stale by mistake
+func deleteRemovedServices(m *Metrics, configured map[string]bool) {
+	for _, service := range statusServices(m.ServiceStatus) {
+		if !configured[service] && m.ServiceStatus.DeleteLabelValues(service) {
+			m.StaleMarkers.Inc()
+		}
+	}
+}
+
+// configuredServices returns the set of services listed as up or down
+func configuredServices(config *Config) map[string]bool {
+	configured := make(map[string]bool, len(config.UpServices)+len(config.DownServices))
+	for _, service := range config.UpServices {
+		configured[service] = true
+	}
+	for _, service := range config.DownServices {
+		configured[service] = true
+	}
+	return configured
+}
+
+// statusServices returns the service label of every series in status
+func statusServices(status *prometheus.GaugeVec) []string {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		status.Collect(metrics)
+		close(metrics)
+	}()
+
+	var services []string
+	for metric := range metrics {
+		var d dto.Metric
+		if err := metric.Write(&d); err != nil {
+			continue
+		}
+		for _, label := range d.GetLabel() {
+			if label.GetName() == "service" {
+				services = append(services, label.GetValue())
+			}
+		}
+	}
+	return services
+}
+
+// updateConfigFileMetrics records the size and service counts of a loaded config
+func updateConfigFileMetrics(m *Metrics, config *Config) {
+	m.ConfigFileSize.Set(float64(config.fileSize))
+	m.ConfigFileLines.Set(float64(config.fileLines))
+	m.ConfigServices.WithLabelValues("up").Set(float64(len(config.UpServices)))
+	m.ConfigServices.WithLabelValues("down").Set(float64(len(config.DownServices)))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// defaultMaxDiffSnapshots is the number of snapshots kept for /metrics/diff
+const defaultMaxDiffSnapshots = 10
+
+// seriesKey identifies a flattened sample by its name and sorted labels
+func seriesKey(s exportSample) string {
+	var b strings.Builder
+	b.WriteString(s.name)
+	for _, l := range s.labels {
+		b.WriteByte(0)
+		b.WriteString(l.name)
+		b.WriteByte(0)
+		b.WriteString(l.value)
+	}
+	return b.String()
+}
+
+// metricsSnapshot is the state of every series when a snapshot was taken
+type metricsSnapshot struct {
+	id      string
+	samples map[string]exportSample // by seriesKey
+}
+
+// newMetricsSnapshot flattens mfs into a snapshot
+func newMetricsSnapshot(id string, mfs []*dto.MetricFamily) *metricsSnapshot {
+	samples := flattenSamples(mfs, time.Now())
+	snapshot := &metricsSnapshot{id: id, samples: make(map[string]exportSample, len(samples))}
+	for _, s := range samples {
+		snapshot.samples[seriesKey(s)] = s
+	}
+	return snapshot
+}
+
+// MetricsSnapshotStore keeps the most recently used snapshots in memory for
+// /metrics/diff, evicting the least recently used one beyond its size
+type MetricsSnapshotStore struct {
+	size int
+
+	mu    sync.Mutex
+	order *list.List // of *metricsSnapshot, most recently used first
+	byID  map[string]*list.Element
+}
+
+// NewMetricsSnapshotStore creates a store keeping at most size snapshots
+func NewMetricsSnapshotStore(size int) *MetricsSnapshotStore {
+	return &MetricsSnapshotStore{size: size, order: list.New(), byID: make(map[string]*list.Element)}
+}
+
+// add stores snapshot, evicting the least recently used snapshots if full
+func (s *MetricsSnapshotStore) add(snapshot *metricsSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byID[snapshot.id] = s.order.PushFront(snapshot)
+	for s.order.Len() > s.size {
+		oldest := s.order.Remove(s.order.Back()).(*metricsSnapshot)
+		delete(s.byID, oldest.id)
+	}
+}
+
+// get returns the snapshot with id and marks it as recently used
+func (s *MetricsSnapshotStore) get(id string) (*metricsSnapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.byID[id]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(elem)
+	return elem.Value.(*metricsSnapshot), true
+}
+
+// seriesDiff is one series whose value changed since the snapshot; old and
+// delta are null for a new series, new and delta for a removed one
+type seriesDiff struct {
+	Metric string            `json:"metric"`
+	Labels map[string]string `json:"labels"`
+	Old    *float64          `json:"old"`
+	New    *float64          `json:"new"`
+	Delta  *float64          `json:"delta"`
+}
+
+// diffSnapshots returns the series that changed, appeared or disappeared
+// between old and current, sorted by metric and labels
+func diffSnapshots(old, current *metricsSnapshot) []seriesDiff {
+	keys := make([]string, 0, len(current.samples))
+	for key := range current.samples {
+		keys = append(keys, key)
+	}
+	for key := range old.samples {
+		if _, ok := current.samples[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	diffs := []seriesDiff{}
+	for _, key := range keys {
+		before, hadBefore := old.samples[key]
+		after, hasAfter := current.samples[key]
+		if hadBefore && hasAfter && before.value == after.value {
+			continue
+		}
+
+		sample := after
+		if !hasAfter {
+			sample = before
+		}
+		diff := seriesDiff{Metric: sample.name, Labels: sample.toJSON().Labels}
+		if hadBefore {
+			diff.Old = &before.value
+		}
+		if hasAfter {
+			diff.New = &after.value
+		}
+		if hadBefore && hasAfter {
+			delta := after.value - before.value
+			diff.Delta = &delta
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+// metricsDiffHandler compares the current metrics with the snapshot named by
+// ?snapshot_id=, as returned by POST /metrics/snapshot
+func metricsDiffHandler(m *Metrics, store *MetricsSnapshotStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Query().Get("snapshot_id")
+		if id == "" {
+			http.Error(w, "snapshot_id is required", http.StatusBadRequest)
+			return
+		}
+		snapshot, ok := store.get(id)
+		if !ok {
+			http.Error(w, "Unknown or evicted snapshot", http.StatusNotFound)
+			return
+		}
+
+		// Gather from the registry directly, like the snapshot, so cached results never show up as unchanged
+		mfs, err := m.Registry.Gather()
+		if err != nil {
+			log.Printf("Metrics diff gathered with errors: %v", err)
+		}
+		writeJSON(w, http.StatusOK, diffSnapshots(snapshot, newMetricsSnapshot("", mfs)))
+	}
+}
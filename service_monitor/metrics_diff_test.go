@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsDiff(t *testing.T) {
+	m := newTestMetrics()
+	updateServiceMetrics(m, &Config{UpServices: []string{"api-gateway"}, DownServices: []string{"user-service"}})
+	mux := NewMetricsServeMux(m, &ServerConfig{SnapshotBaseDir: t.TempDir()})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics/snapshot", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var snapshot snapshotResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &snapshot); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if snapshot.SnapshotID == "" {
+		t.Fatal("expected a snapshot_id")
+	}
+
+	updateServiceMetrics(m, &Config{DownServices: []string{"api-gateway", "user-service"}})
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/diff?snapshot_id="+snapshot.SnapshotID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var diffs []seriesDiff
+	if err := json.Unmarshal(rec.Body.Bytes(), &diffs); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	var found bool
+	for _, diff := range diffs {
+		if diff.Metric == "service_monitor_up" && diff.Labels["service"] == "user-service" {
+			t.Errorf("unchanged series user-service is in the diff: %+v", diff)
+		}
+		if diff.Metric != "service_monitor_up" || diff.Labels["service"] != "api-gateway" {
+			continue
+		}
+		found = true
+		if diff.Old == nil || *diff.Old != 1 || diff.New == nil || *diff.New != 0 || diff.Delta == nil || *diff.Delta != -1 {
+			t.Errorf("expected api-gateway to go from 1 to 0, got %+v", diff)
+		}
+	}
+	if !found {
+		t.Errorf("expected service_monitor_up{service=\"api-gateway\"} in the diff, got %s", rec.Body.String())
+	}
+}
+
+func TestMetricsDiff_UnknownSnapshot(t *testing.T) {
+	mux := NewMetricsServeMux(newTestMetrics(), &ServerConfig{SnapshotBaseDir: t.TempDir()})
+
+	for url, want := range map[string]int{
+		"/metrics/diff":                  http.StatusBadRequest,
+		"/metrics/diff?snapshot_id=nope": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", url, want, rec.Code)
+		}
+	}
+}
+
+func TestDiffSnapshots_AddedAndRemovedSeries(t *testing.T) {
+	m := newTestMetrics()
+	updateServiceMetrics(m, &Config{UpServices: []string{"api-gateway"}})
+	mfs, _ := m.Registry.Gather()
+	old := newMetricsSnapshot("old", mfs)
+
+	updateServiceMetrics(m, &Config{UpServices: []string{"user-service"}})
+	mfs, _ = m.Registry.Gather()
+	diffs := diffSnapshots(old, newMetricsSnapshot("", mfs))
+
+	var added, removed bool
+	for _, diff := range diffs {
+		if diff.Metric != "service_monitor_up" {
+			continue
+		}
+		switch diff.Labels["service"] {
+		case "user-service":
+			added = diff.Old == nil && diff.New != nil && *diff.New == 1 && diff.Delta == nil
+		case "api-gateway":
+			removed = diff.Old != nil && *diff.Old == 1 && diff.New == nil && diff.Delta == nil
+		}
+	}
+	if !added || !removed {
+		t.Errorf("expected user-service added and api-gateway removed, got %+v", diffs)
+	}
+}
+
+func TestMetricsSnapshotStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewMetricsSnapshotStore(2)
+	store.add(&metricsSnapshot{id: "a"})
+	store.add(&metricsSnapshot{id: "b"})
+	if _, ok := store.get("a"); !ok {
+		t.Fatal("expected snapshot a")
+	}
+	store.add(&metricsSnapshot{id: "c"})
+
+	if _, ok := store.get("b"); ok {
+		t.Error("expected b to be evicted as least recently used")
+	}
+	for _, id := range []string{"a", "c"} {
+		if _, ok := store.get(id); !ok {
+			t.Errorf("expected snapshot %s to be kept", id)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// MetricFormatter serialises gathered metric families for a system that
+// can't scrape the Prometheus exposition format
+type MetricFormatter interface {
+	Format(mfs []*dto.MetricFamily, w io.Writer) error
+	ContentType() string
+}
+
+// metricFormatters are the formats served by /metrics/export
+var metricFormatters = map[string]MetricFormatter{
+	"json":     JSONFormatter{},
+	"influx":   InfluxFormatter{},
+	"graphite": GraphiteFormatter{},
+}
+
+// exportLabel is one label of an exported sample
+type exportLabel struct {
+	name, value string
+}
+
+// exportSample is a single value of a metric family, with summaries and
+// histograms split into their _sum, _count, quantile and _bucket samples
+type exportSample struct {
+	name   string
+	typ    string
+	labels []exportLabel // sorted by name
+	value  float64
+	time   time.Time
+}
+
+// flattenSamples returns the samples of mfs, skipping NaN and infinite values
+// that the export formats can't represent; samples without a timestamp get now
+func flattenSamples(mfs []*dto.MetricFamily, now time.Time) []exportSample {
+	var samples []exportSample
+	for _, mf := range mfs {
+		typ := strings.ToLower(mf.GetType().String())
+		for _, metric := range mf.GetMetric() {
+			ts := now
+			if metric.TimestampMs != nil {
+				ts = time.UnixMilli(metric.GetTimestampMs())
+			}
+			labels := make([]exportLabel, 0, len(metric.GetLabel())+1)
+			for _, lp := range metric.GetLabel() {
+				labels = append(labels, exportLabel{lp.GetName(), lp.GetValue()})
+			}
+
+			add := func(suffix string, value float64, extra ...exportLabel) {
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					return
+				}
+				all := append(append([]exportLabel{}, labels...), extra...)
+				sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+				samples = append(samples, exportSample{
+					name: mf.GetName() + suffix, typ: typ, labels: all, value: value, time: ts,
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", metric.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := metric.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), exportLabel{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := metric.GetHistogram()
+				for _, b := range h.GetBucket() {
+					add("_bucket", float64(b.GetCumulativeCount()), exportLabel{"le", formatFloat(b.GetUpperBound())})
+				}
+				add("_bucket", float64(h.GetSampleCount()), exportLabel{"le", "+Inf"})
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return samples
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// JSONFormatter writes a JSON array with one object per sample, timestamps
+// in unix milliseconds
+type JSONFormatter struct{}
+
+// jsonSample is one element of the JSONFormatter output
+type jsonSample struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels"`
+	Value     float64           `json:"value"`
+	Type      string            `json:"type"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+// toJSON converts s to its JSONFormatter representation
+func (s exportSample) toJSON() jsonSample {
+	labels := make(map[string]string, len(s.labels))
+	for _, l := range s.labels {
+		labels[l.name] = l.value
+	}
+	return jsonSample{Name: s.name, Labels: labels, Value: s.value, Type: s.typ, Timestamp: s.time.UnixMilli()}
+}
+
+func (JSONFormatter) ContentType() string { return "application/json" }
+
+func (JSONFormatter) Format(mfs []*dto.MetricFamily, w io.Writer) error {
+	samples := flattenSamples(mfs, time.Now())
+	out := make([]jsonSample, len(samples))
+	for i, s := range samples {
+		out[i] = s.toJSON()
+	}
+	return json.NewEncoder(w).Encode(out)
+}
+
+// InfluxFormatter writes InfluxDB line protocol, one point per sample with the
+// labels as tags, the value in the "value" field and a nanosecond timestamp
+type InfluxFormatter struct{}
+
+// influxEscaper escapes measurement names, tag keys and tag values
+var influxEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+
+func (InfluxFormatter) ContentType() string { return "text/plain; charset=utf-8" }
+
+func (InfluxFormatter) Format(mfs []*dto.MetricFamily, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range flattenSamples(mfs, time.Now()) {
+		bw.WriteString(influxEscaper.Replace(s.name))
+		for _, l := range s.labels {
+			// Line protocol has no empty tag values
+			if l.value == "" {
+				continue
+			}
+			fmt.Fprintf(bw, ",%s=%s", influxEscaper.Replace(l.name), influxEscaper.Replace(l.value))
+		}
+		fmt.Fprintf(bw, " value=%s %d\n", formatFloat(s.value), s.time.UnixNano())
+	}
+	return bw.Flush()
+}
+
+// GraphiteFormatter writes the Graphite plaintext protocol, appending each
+// label to the metric path as .<name>.<value>, with timestamps in unix seconds
+type GraphiteFormatter struct{}
+
+// graphitePathComponent replaces everything but letters, digits, _ and - so
+// label values can't add path separators or break the line format
+func graphitePathComponent(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func (GraphiteFormatter) ContentType() string { return "text/plain; charset=utf-8" }
+
+func (GraphiteFormatter) Format(mfs []*dto.MetricFamily, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range flattenSamples(mfs, time.Now()) {
+		bw.WriteString(graphitePathComponent(s.name))
+		for _, l := range s.labels {
+			fmt.Fprintf(bw, ".%s.%s", graphitePathComponent(l.name), graphitePathComponent(l.value))
+		}
+		fmt.Fprintf(bw, " %s %d\n", formatFloat(s.value), s.time.Unix())
+	}
+	return bw.Flush()
+}
+
+// metricsExportHandler serves the current metrics in the format named by
+// ?format=json|influx|graphite
+func metricsExportHandler(m *Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		formatter, ok := metricFormatters[r.URL.Query().Get("format")]
+		if !ok {
+			http.Error(w, "format must be json, influx or graphite", http.StatusBadRequest)
+			return
+		}
+
+		mfs, err := m.Exposition.Gather()
+		if err != nil {
+			log.Printf("Metrics export gathered with errors: %v", err)
+		}
+
+		w.Header().Set("Content-Type", formatter.ContentType())
+		if err := formatter.Format(mfs, w); err != nil {
+			log.Printf("Error exporting metrics: %v", err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/line-protocol/v2/lineprotocol"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// exportTestFamilies gathers a counter with awkward label values, a gauge,
+// a histogram and a summary
+func exportTestFamilies(t *testing.T) []*dto.MetricFamily {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "export_requests_total", Help: "h"}, []string{"service", "path"})
+	counter.WithLabelValues("api gateway", "/a,b=c").Add(3)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "export_temperature", Help: "h"})
+	gauge.Set(-1.5)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "export_duration_seconds", Help: "h", Buckets: []float64{0.1, 1}})
+	histogram.Observe(0.5)
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "export_size_bytes", Help: "h", Objectives: map[float64]float64{0.5: 0.05}})
+	summary.Observe(42)
+	reg.MustRegister(counter, gauge, histogram, summary)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mfs
+}
+
+// wantExportValues are the samples every format must carry, keyed by name
+// and the label values that identify them
+var wantExportValues = map[string]float64{
+	"export_requests_total{/a,b=c,api gateway}": 3,
+	"export_temperature{}":                      -1.5,
+	"export_duration_seconds_bucket{0.1}":       0,
+	"export_duration_seconds_bucket{1}":         1,
+	"export_duration_seconds_bucket{+Inf}":      1,
+	"export_duration_seconds_sum{}":             0.5,
+	"export_size_bytes{0.5}":                    42,
+	"export_size_bytes_count{}":                 1,
+}
+
+func TestJSONFormatter_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (JSONFormatter{}).Format(exportTestFamilies(t), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var samples []jsonSample
+	if err := json.Unmarshal(buf.Bytes(), &samples); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	got := map[string]float64{}
+	types := map[string]string{}
+	for _, s := range samples {
+		got[s.Name+"{"+joinLabelValues(s.Labels)+"}"] = s.Value
+		types[s.Name] = s.Type
+		if time.Since(time.UnixMilli(s.Timestamp)) > time.Minute {
+			t.Errorf("unexpected timestamp %d", s.Timestamp)
+		}
+	}
+	checkExportValues(t, got)
+	if types["export_requests_total"] != "counter" || types["export_duration_seconds_bucket"] != "histogram" {
+		t.Errorf("unexpected types %v", types)
+	}
+}
+
+func TestInfluxFormatter_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (InfluxFormatter{}).Format(exportTestFamilies(t), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]float64{}
+	dec := lineprotocol.NewDecoderWithBytes(buf.Bytes())
+	for dec.Next() {
+		name, err := dec.Measurement()
+		if err != nil {
+			t.Fatalf("invalid measurement: %v\n%s", err, buf.String())
+		}
+		measurement := string(name)
+		labels := map[string]string{}
+		for {
+			key, value, err := dec.NextTag()
+			if err != nil {
+				t.Fatalf("invalid tag: %v\n%s", err, buf.String())
+			}
+			if key == nil {
+				break
+			}
+			labels[string(key)] = string(value)
+		}
+		key, value, err := dec.NextField()
+		if err != nil || string(key) != "value" {
+			t.Fatalf("invalid field %q: %v\n%s", key, err, buf.String())
+		}
+		if _, err := dec.Time(lineprotocol.Nanosecond, time.Time{}); err != nil {
+			t.Fatalf("invalid timestamp: %v", err)
+		}
+		got[measurement+"{"+joinLabelValues(labels)+"}"] = value.FloatV()
+	}
+	checkExportValues(t, got)
+}
+
+func TestGraphiteFormatter_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (GraphiteFormatter{}).Format(exportTestFamilies(t), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Carbon's plaintext receiver splits each line into path, value and timestamp
+	got := map[string]float64{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			t.Fatalf("expected path, value and timestamp, got %q", scanner.Text())
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			t.Fatalf("invalid value in %q: %v", scanner.Text(), err)
+		}
+		if _, err := strconv.ParseInt(fields[2], 10, 64); err != nil {
+			t.Fatalf("invalid timestamp in %q: %v", scanner.Text(), err)
+		}
+		got[fields[0]] = value
+	}
+
+	want := map[string]float64{
+		"export_requests_total.path._a_b_c.service.api_gateway": 3,
+		"export_temperature":                     -1.5,
+		"export_duration_seconds_bucket.le._Inf": 1,
+		"export_size_bytes.quantile.0_5":         42,
+	}
+	for path, value := range want {
+		if v, ok := got[path]; !ok || v != value {
+			t.Errorf("expected %s = %v, got %v (present: %v)", path, value, v, ok)
+		}
+	}
+}
+
+func TestMetricsExportHandler(t *testing.T) {
+	mux := NewMetricsServeMux(newTestMetrics(), &ServerConfig{})
+
+	tests := []struct {
+		format      string
+		code        int
+		contentType string
+	}{
+		{"json", http.StatusOK, "application/json"},
+		{"influx", http.StatusOK, "text/plain; charset=utf-8"},
+		{"graphite", http.StatusOK, "text/plain; charset=utf-8"},
+		{"", http.StatusBadRequest, ""},
+		{"csv", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/export?format="+tt.format, nil))
+		if rec.Code != tt.code {
+			t.Errorf("format %q: expected %d, got %d", tt.format, tt.code, rec.Code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("format %q: expected Content-Type %q, got %q", tt.format, tt.contentType, ct)
+		}
+		if !strings.Contains(rec.Body.String(), "service_monitor_requests_total") {
+			t.Errorf("format %q: expected the service metrics in the export", tt.format)
+		}
+	}
+}
+
+// joinLabelValues returns the label values sorted by label name, comma separated
+func joinLabelValues(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = labels[name]
+	}
+	return strings.Join(values, ",")
+}
+
+func checkExportValues(t *testing.T, got map[string]float64) {
+	t.Helper()
+	for key, want := range wantExportValues {
+		if v, ok := got[key]; !ok || v != want {
+			t.Errorf("expected %s = %v, got %v (present: %v)", key, want, v, ok)
+		}
+	}
+}
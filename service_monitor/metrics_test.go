@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUpdateServiceMetrics_DeletesRemovedServices(t *testing.T) {
+	preserveConfigState(t)
+	m := newTestMetrics()
+
+	updateServiceMetrics(m, &Config{UpServices: []string{"api", "auth"}, DownServices: []string{"db"}})
+	updateServiceMetrics(m, &Config{UpServices: []string{"api", "db"}})
+
+	mfs, err := m.Registry.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	statuses := gatheredServiceStatus(mfs)
+	if len(statuses) != 2 || statuses["api"] != 1 || statuses["db"] != 1 {
+		t.Errorf("expected api and db up, got %v", statuses)
+	}
+	if _, ok := statuses["auth"]; ok {
+		t.Error("expected the series of the removed service to be deleted")
+	}
+	if got := testutil.ToFloat64(m.StaleMarkers); got != 1 {
+		t.Errorf("expected 1 stale marker, got %v", got)
+	}
+
+	// Services that stay configured are never deleted
+	updateServiceMetrics(m, &Config{UpServices: []string{"api", "db"}})
+	if got := testutil.ToFloat64(m.StaleMarkers); got != 1 {
+		t.Errorf("expected no new stale markers, got %v", got)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// discardResponseWriter captures the headers and status of a response and
+// counts, but drops, its body
+type discardResponseWriter struct {
+	header http.Header
+	status int
+	size   int
+}
+
+func newDiscardResponseWriter() *discardResponseWriter {
+	return &discardResponseWriter{header: make(http.Header)}
+}
+
+func (d *discardResponseWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardResponseWriter) Write(b []byte) (int, error) {
+	if d.status == 0 {
+		d.status = http.StatusOK
+	}
+	// Sniff the content type like net/http does for real responses
+	if d.size == 0 && d.header.Get("Content-Type") == "" {
+		d.header.Set("Content-Type", http.DetectContentType(b))
+	}
+	d.size += len(b)
+	return len(b), nil
+}
+
+func (d *discardResponseWriter) WriteHeader(status int) {
+	if d.status == 0 {
+		d.status = status
+	}
+}
+
+// headMiddleware answers HEAD requests with the headers the GET response
+// would have, including its exact Content-Length, but without a body
+func headMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Render the GET response so handlers that only allow GET behave the same
+		get := r.Clone(r.Context())
+		get.Method = http.MethodGet
+
+		discard := newDiscardResponseWriter()
+		next.ServeHTTP(discard, get)
+
+		for key, values := range discard.header {
+			w.Header()[key] = values
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(discard.size))
+
+		if discard.status == 0 {
+			discard.status = http.StatusOK
+		}
+		w.WriteHeader(discard.status)
+	})
+}
+
+// securityHeadersMiddleware adds the headers from the [http_headers] config
+// section to every response, picking up changes on config reload
+func securityHeadersMiddleware(cfg *ServerConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if headers := cfg.responseHeaders.Load(); headers != nil {
+			for key, values := range *headers {
+				w.Header()[key] = values
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// defaultMaxRequestBodyBytes bounds request bodies unless MAX_REQUEST_BODY_BYTES is set
+const defaultMaxRequestBodyBytes = 1 << 20
+
+// maxBodyMiddleware limits the body of every request that may carry one
+func maxBodyMiddleware(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// decodeJSONBody decodes the request body into v, answering 413 when the body
+// exceeds the maxBodyMiddleware limit and 400 when it isn't valid JSON
+// It returns false when a response has already been written
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{
+			"error": fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit),
+		})
+		return false
+	}
+
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid JSON body: %v", err)})
+	return false
+}
+
+// etagMiddleware sets ETag and Last-Modified on GET and HEAD responses and
+// answers 304 Not Modified when the client's cached copy is still current
+// If-None-Match takes precedence over If-Modified-Since, as in RFC 7232
+func etagMiddleware(getEtag func() string, getLastMod func() time.Time) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			etag := getEtag()
+			lastMod := getLastMod()
+			if etag != "" {
+				w.Header().Set("ETag", etag)
+			}
+			if !lastMod.IsZero() {
+				w.Header().Set("Last-Modified", lastMod.UTC().Format(http.TimeFormat))
+			}
+
+			if isNotModified(r, etag, lastMod) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isNotModified evaluates the conditional headers of r against the current
+// ETag and modification time of the resource
+func isNotModified(r *http.Request, etag string, lastMod time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastMod.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		// HTTP dates have second precision
+		return !lastMod.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// countNotModified increments hits for every 304 Not Modified response
+func countNotModified(hits prometheus.Counter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == http.StatusNotModified {
+				hits.Inc()
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeadMiddleware_ContentLengthMatchesGet(t *testing.T) {
+	noSleep(t)
+
+	m := newTestMetrics()
+	mux := NewServeMux(m, &ServerConfig{ConfigPath: writeTestConfig(t, nil, nil)})
+	updateServiceMetrics(m, &Config{UpServices: []string{"api-gateway"}, DownServices: []string{"user-service"}})
+
+	for _, path := range []string{"/metrics", "/health"} {
+		get := httptest.NewRecorder()
+		mux.ServeHTTP(get, httptest.NewRequest(http.MethodGet, path, nil))
+
+		head := httptest.NewRecorder()
+		mux.ServeHTTP(head, httptest.NewRequest(http.MethodHead, path, nil))
+
+		if head.Code != get.Code {
+			t.Errorf("HEAD %s: expected status %d, got %d", path, get.Code, head.Code)
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("HEAD %s: expected empty body, got %d bytes", path, head.Body.Len())
+		}
+
+		want := strconv.Itoa(get.Body.Len())
+		if got := head.Header().Get("Content-Length"); got != want {
+			t.Errorf("HEAD %s: expected Content-Length %s, got %q", path, want, got)
+		}
+		if got, want := head.Header().Get("Content-Type"), get.Header().Get("Content-Type"); got != want {
+			t.Errorf("HEAD %s: expected Content-Type %q, got %q", path, want, got)
+		}
+	}
+}
+
+func TestHeadMiddleware_PassesThroughOtherMethods(t *testing.T) {
+	handler := headMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Body.String() != http.MethodPost {
+		t.Errorf("expected POST to reach the handler unchanged, got %q", rec.Body.String())
+	}
+}
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := `up_services = ["api-gateway"]
+
+[http_headers]
+X-Frame-Options = "DENY"
+Strict-Transport-Security = "max-age=63072000"
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(RealFileSystem{}, path, newTestMetrics().ConfigBytesRead)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{ConfigPath: path}
+	applyConfig(m, cfg, config, loadTriggerManual)
+
+	// Both the app and the metrics server get the headers
+	tests := []struct {
+		handler http.Handler
+		path    string
+	}{
+		{securityHeadersMiddleware(cfg, NewAppServeMux(m, cfg)), "/health"},
+		{securityHeadersMiddleware(cfg, NewMetricsServeMux(m, cfg)), "/metrics"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tt.path, rec.Code)
+		}
+		if got := rec.Header().Get("X-Frame-Options"); got != "DENY" {
+			t.Errorf("%s: expected X-Frame-Options DENY, got %q", tt.path, got)
+		}
+		if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=63072000" {
+			t.Errorf("%s: expected Strict-Transport-Security header, got %q", tt.path, got)
+		}
+	}
+}
+
+func TestMaxBodyMiddleware(t *testing.T) {
+	// Echo back the size of a decoded JSON body
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"fields": len(body)})
+	})
+	server := httptest.NewServer(wrapHandler(&ServerConfig{MaxRequestBodyBytes: 64}, echo))
+	defer server.Close()
+
+	post := func(body string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	large := `{"data":"` + strings.Repeat("x", 1024) + `"}`
+	if resp := post(large); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for an oversized body, got %d", resp.StatusCode)
+	} else if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected a JSON error body, got %q", got)
+	}
+
+	// The server keeps serving normal requests afterwards
+	if resp := post(`{"a":"b"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 after the oversized request, got %d", resp.StatusCode)
+	}
+	if resp := post(`{`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid JSON, got %d", resp.StatusCode)
+	}
+}
+
+func TestEtagMiddleware(t *testing.T) {
+	const etag = `"0123456789abcdef"`
+	lastMod := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	handler := etagMiddleware(
+		func() string { return etag },
+		func() time.Time { return lastMod },
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("body"))
+	}))
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"unconditional", nil, http.StatusOK},
+		{"matching etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"matching weak etag in list", map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified},
+		{"wildcard", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"non-matching etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"modified since earlier", map[string]string{"If-Modified-Since": lastMod.Add(-time.Second).Format(http.TimeFormat)}, http.StatusOK},
+		{"not modified since", map[string]string{"If-Modified-Since": lastMod.Format(http.TimeFormat)}, http.StatusNotModified},
+		{"not modified since later", map[string]string{"If-Modified-Since": lastMod.Add(time.Hour).Format(http.TimeFormat)}, http.StatusNotModified},
+		{"invalid date", map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+		{"etag takes precedence", map[string]string{
+			"If-None-Match":     `"other"`,
+			"If-Modified-Since": lastMod.Format(http.TimeFormat),
+		}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/config", nil)
+		for key, value := range tt.headers {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, rec.Code)
+		}
+		if tt.want == http.StatusNotModified && rec.Body.Len() != 0 {
+			t.Errorf("%s: expected empty body, got %q", tt.name, rec.Body.String())
+		}
+		if got := rec.Header().Get("ETag"); got != etag {
+			t.Errorf("%s: expected ETag %s, got %q", tt.name, etag, got)
+		}
+		if got := rec.Header().Get("Last-Modified"); got != lastMod.Format(http.TimeFormat) {
+			t.Errorf("%s: expected Last-Modified %q, got %q", tt.name, lastMod.Format(http.TimeFormat), got)
+		}
+	}
+}
+
+func TestEtagMiddleware_SubSecondModTime(t *testing.T) {
+	// File systems record sub-second modification times that HTTP dates can't carry
+	lastMod := time.Date(2024, 5, 1, 12, 0, 0, 500*int(time.Millisecond), time.UTC)
+	handler := etagMiddleware(
+		func() string { return "" },
+		func() time.Time { return lastMod },
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/config", nil)
+	req.Header.Set("If-Modified-Since", lastMod.Format(http.TimeFormat))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("ETag"); got != "" {
+		t.Errorf("expected no ETag, got %q", got)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// mockServiceStatuses maps the last path element under /mock/{service}/ to
+// the status code the mock endpoint answers with
+var mockServiceStatuses = map[string]int{
+	"up":   http.StatusOK,
+	"down": http.StatusServiceUnavailable,
+}
+
+// mockServiceHandler answers /mock/{service}/up with 200 and
+// /mock/{service}/down with 503, so integration tests can point probes at
+// the service monitor itself instead of running mock services
+func mockServiceHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/mock/")
+		slash := strings.LastIndex(path, "/")
+		if slash <= 0 || strings.Contains(path[:slash], "/") {
+			http.NotFound(w, r)
+			return
+		}
+		status, ok := mockServiceStatuses[path[slash+1:]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.WriteHeader(status)
+		w.Write([]byte(http.StatusText(status)))
+	}
+}
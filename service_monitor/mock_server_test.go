@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMockServiceHandler(t *testing.T) {
+	mux := NewAppServeMux(newTestMetrics(), &ServerConfig{MockServer: true})
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/mock/payment-service/up", http.StatusOK},
+		{http.MethodGet, "/mock/payment-service/down", http.StatusServiceUnavailable},
+		{http.MethodHead, "/mock/api/up", http.StatusOK},
+		{http.MethodHead, "/mock/api/down", http.StatusServiceUnavailable},
+		{http.MethodGet, "/mock/api/degraded", http.StatusNotFound},
+		{http.MethodGet, "/mock/api", http.StatusNotFound},
+		{http.MethodGet, "/mock/a/b/up", http.StatusNotFound},
+		{http.MethodPost, "/mock/api/up", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestMockServiceHandler_AsProbeTarget(t *testing.T) {
+	srv := httptest.NewServer(NewAppServeMux(newTestMetrics(), &ServerConfig{MockServer: true}))
+	defer srv.Close()
+
+	prober := newTestMetrics().Prober
+	up := prober.runProbe(context.Background(), "api", ProbeTarget{URL: srv.URL + "/mock/api/up"})
+	down := prober.runProbe(context.Background(), "db", ProbeTarget{URL: srv.URL + "/mock/db/down"})
+	if up.Status != 1 || down.Status != 0 {
+		t.Errorf("expected the up mock to probe up and the down mock down, got %+v and %+v", up, down)
+	}
+}
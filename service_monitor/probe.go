@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// defaultProbeTimeout bounds a probe whose target sets no timeout_ms
+const defaultProbeTimeout = 5 * time.Second
+
+// maxDrainedProbeBody bounds the response body read to keep a probe
+// connection reusable; the connection of a longer response is closed
+const maxDrainedProbeBody = 64 << 10
+
+const (
+	probeTypeHTTP = "http"
+	probeTypeTCP  = "tcp"
+)
+
+// ProbeTarget is the endpoint /probe checks for a service; exactly one of
+// URL and TCPAddress is set
+type ProbeTarget struct {
+	// Up when a GET returns a status below 400
+	URL string `toml:"url"`
+
+	// Up when a TCP connection can be established
+	TCPAddress string `toml:"tcp_address"`
+
+	// Time a probe may take; values below 1 use the default
+	TimeoutMs int `toml:"timeout_ms"`
+}
+
+// timeout returns the time a probe of the target may take
+func (p ProbeTarget) timeout() time.Duration {
+	if p.TimeoutMs < 1 {
+		return defaultProbeTimeout
+	}
+	return time.Duration(p.TimeoutMs) * time.Millisecond
+}
+
+// probeType returns whether the target is probed over HTTP or TCP
+func (p ProbeTarget) probeType() string {
+	if p.URL != "" {
+		return probeTypeHTTP
+	}
+	return probeTypeTCP
+}
+
+// probeTargetProblems returns a description of every invalid probe target
+func probeTargetProblems(targets map[string]ProbeTarget) []string {
+	services := make([]string, 0, len(targets))
+	for service := range targets {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	var problems []string
+	for _, service := range services {
+		target := targets[service]
+		switch {
+		case (target.URL == "") == (target.TCPAddress == ""):
+			problems = append(problems, fmt.Sprintf("probe of service %q must set exactly one of url and tcp_address", service))
+		case target.URL != "":
+			if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problems = append(problems, fmt.Sprintf("probe of service %q has invalid url %q", service, target.URL))
+			}
+		default:
+			if _, _, err := net.SplitHostPort(target.TCPAddress); err != nil {
+				problems = append(problems, fmt.Sprintf("probe of service %q has invalid tcp_address %q", service, target.TCPAddress))
+			}
+		}
+	}
+	return problems
+}
+
+// probeResponse is the JSON body returned by /probe
+type probeResponse struct {
+	Service    string  `json:"service"`
+	Status     int     `json:"status"`
+	DurationMs float64 `json:"duration_ms"`
+	ProbeType  string  `json:"probe_type"`
+	StatusCode int     `json:"status_code,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// runProbe checks target once; the probe is aborted once its timeout passes
+// or ctx is cancelled
+func (p *Prober) runProbe(ctx context.Context, service string, target ProbeTarget) probeResponse {
+	resp := probeResponse{Service: service, ProbeType: target.probeType()}
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(withProbeService(ctx, service), target.timeout())
+	defer cancel()
+
+	var err error
+	switch resp.ProbeType {
+	case probeTypeHTTP:
+		var req *http.Request
+		var r *http.Response
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, target.URL, nil); err == nil {
+			r, err = p.client.Do(req)
+		}
+		if err == nil {
+			// Drain the body so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(r.Body, maxDrainedProbeBody))
+			r.Body.Close()
+			resp.StatusCode = r.StatusCode
+			if r.StatusCode >= http.StatusBadRequest {
+				err = fmt.Errorf("unexpected status %s", r.Status)
+			}
+		} else if errorType := probeConnectionErrorType(err); errorType != "" {
+			p.m.ProbeConnectionErrors.WithLabelValues(errorType).Inc()
+		}
+	case probeTypeTCP:
+		var conn net.Conn
+		if conn, err = p.dialer.DialContext(ctx, "tcp", target.TCPAddress); err == nil {
+			conn.Close()
+		} else if errorType := probeConnectionErrorType(err); errorType != "" {
+			p.m.ProbeConnectionErrors.WithLabelValues(errorType).Inc()
+		}
+	}
+
+	resp.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Status = 1
+	}
+	return resp
+}
+
+// probeHandler probes the service named by ?service= once and returns the
+// outcome; it is for diagnostics only and leaves service_monitor_up untouched
+func probeHandler(m *Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		service := r.URL.Query().Get("service")
+		if service == "" {
+			http.Error(w, "Missing service parameter", http.StatusBadRequest)
+			return
+		}
+
+		config := loadCurrentConfig()
+		if config == nil {
+			http.Error(w, "No config loaded", http.StatusServiceUnavailable)
+			return
+		}
+		target, ok := config.Probes[service]
+		if !ok {
+			http.Error(w, fmt.Sprintf("No probe configured for service %q", service), http.StatusNotFound)
+			return
+		}
+
+		resp := m.Prober.runProbe(r.Context(), service, target)
+		result := "success"
+		if resp.Status == 0 {
+			result = "failure"
+		}
+		m.OnDemandProbes.WithLabelValues(resp.ProbeType, result).Inc()
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// serviceProbeContext lets the probes of one service be cancelled
+type serviceProbeContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Probe contexts of the services the scheduler has seen, by service name
+// A service's context is cancelled as soon as a config without it is applied,
+// so its queued and running probes stop without waiting for the next round
+var serviceProbeContexts sync.Map
+
+// probeContext returns the probe context of service, derived from parent
+// The first time it is created, cleanup is scheduled to run once the service
+// is removed; cancelling parent on shutdown leaves the probe state alone
+func probeContext(parent context.Context, service string, cleanup func()) context.Context {
+	if entry, ok := serviceProbeContexts.Load(service); ok {
+		existing := entry.(*serviceProbeContext)
+		if existing.ctx.Err() == nil {
+			return existing.ctx
+		}
+		// Cancelled but its cleanup hasn't removed it yet
+		serviceProbeContexts.CompareAndDelete(service, existing)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	entry := &serviceProbeContext{ctx: ctx, cancel: cancel}
+	if actual, loaded := serviceProbeContexts.LoadOrStore(service, entry); loaded {
+		cancel()
+		return actual.(*serviceProbeContext).ctx
+	}
+	context.AfterFunc(ctx, func() {
+		serviceProbeContexts.CompareAndDelete(service, entry)
+		if parent.Err() != nil {
+			return
+		}
+		// Leave a service that was added back in the meantime alone
+		if _, ok := serviceProbeContexts.Load(service); !ok {
+			cleanup()
+		}
+	})
+	return ctx
+}
+
+// cancelRemovedProbes cancels the probe contexts of services not in configured
+func cancelRemovedProbes(configured map[string]bool) {
+	serviceProbeContexts.Range(func(key, value any) bool {
+		if !configured[key.(string)] {
+			value.(*serviceProbeContext).cancel()
+		}
+		return true
+	})
+}
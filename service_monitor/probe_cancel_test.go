@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// probeTestContext returns a scheduler context cancelled when the test ends,
+// which also cancels the probe contexts derived from it
+func probeTestContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestProbeContext_CancelledOnRemoval(t *testing.T) {
+	preserveConfigState(t)
+	m := newTestMetrics()
+	parent := probeTestContext(t)
+
+	cleaned := make(chan struct{})
+	api := probeContext(parent, "api", func() { close(cleaned) })
+	db := probeContext(parent, "db", func() {})
+	if probeContext(parent, "api", nil) != api {
+		t.Fatal("expected the existing context of the service to be reused")
+	}
+
+	updateServiceMetrics(m, &Config{UpServices: []string{"db"}})
+
+	if api.Err() == nil {
+		t.Error("expected the probe context of the removed service to be cancelled")
+	}
+	select {
+	case <-cleaned:
+	case <-time.After(time.Second):
+		t.Fatal("expected the cleanup of the removed service to run")
+	}
+	if db.Err() != nil {
+		t.Error("expected the probe context of a configured service to stay live")
+	}
+
+	// A service that is added back gets a fresh context
+	if again := probeContext(parent, "api", func() {}); again == api || again.Err() != nil {
+		t.Error("expected a new live context for the re-added service")
+	}
+}
+
+func TestRunProbeJob_DropsRemovedService(t *testing.T) {
+	preserveConfigState(t)
+	noProbeJitter(t)
+	m := newTestMetrics()
+	tracker := NewSLOTracker(10, defaultHistoryDepth)
+	q := newProbeQueue(10, m.ProbeCoalesced)
+	config := &Config{UpServices: []string{"api", "db"}}
+
+	updateServiceMetrics(m, config)
+	scheduleProbes(probeTestContext(t), m, tracker, q, newProbeSchedule(time.Minute), config, time.Now())
+	if got := len(q.jobs); got != 2 {
+		t.Fatalf("expected 2 queued probes, got %d", got)
+	}
+
+	// api is removed while its probe is still queued
+	updateServiceMetrics(m, &Config{UpServices: []string{"db"}})
+	for len(q.jobs) > 0 {
+		job, _ := q.next()
+		runProbeJob(m, tracker, job)
+	}
+
+	tracker.mu.Lock()
+	_, apiTracked := tracker.windows["api"]
+	_, dbTracked := tracker.windows["db"]
+	tracker.mu.Unlock()
+	if apiTracked || !dbTracked {
+		t.Errorf("expected only db to be probed, got api=%v db=%v", apiTracked, dbTracked)
+	}
+	if got := testutil.CollectAndCount(m.AvailabilityRatio); got != 1 {
+		t.Errorf("expected 1 availability series, got %d", got)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// probeQueueSize bounds the number of probes waiting for the workers; since a
+// service is queued at most once it only limits how many distinct services
+// can be pending before the scheduler blocks
+const probeQueueSize = 1024
+
+// defaultProbeWorkers is the number of workers running queued probes when
+// PROBE_WORKERS is unset; a single worker keeps the probes of a service in
+// order
+const defaultProbeWorkers = 1
+
+// probeJob is a scheduled probe of one service
+type probeJob struct {
+	service      string
+	configuredUp bool
+
+	// Probe context of the service, cancelled when it leaves the config
+	ctx context.Context
+
+	// Health check of the service, nil without a [probes] entry
+	target *ProbeTarget
+}
+
+// probeQueue hands scheduled probes to the probe workers, dropping a probe of
+// a service that is still waiting in the queue from an earlier round
+type probeQueue struct {
+	jobs   chan probeJob
+	queued sync.Map // service name -> true while a probe of it is queued
+
+	coalesced prometheus.Counter
+}
+
+func newProbeQueue(size int, coalesced prometheus.Counter) *probeQueue {
+	return &probeQueue{
+		jobs:      make(chan probeJob, size),
+		coalesced: coalesced,
+	}
+}
+
+// schedule queues job and reports whether it was queued; it blocks while
+// the queue is full
+func (q *probeQueue) schedule(job probeJob) bool {
+	if _, queued := q.queued.LoadOrStore(job.service, true); queued {
+		q.coalesced.Inc()
+		return false
+	}
+	q.jobs <- job
+	return true
+}
+
+// next blocks until a probe is queued and returns it, or false once the
+// queue is closed; the service can be scheduled again as soon as it is picked up
+func (q *probeQueue) next() (probeJob, bool) {
+	job, ok := <-q.jobs
+	if ok {
+		q.queued.Delete(job.service)
+	}
+	return job, ok
+}
+
+// close stops the workers after the queued probes have run
+func (q *probeQueue) close() {
+	close(q.jobs)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestProbeQueue_CoalescesQueuedService(t *testing.T) {
+	m := newTestMetrics()
+	q := newProbeQueue(10, m.ProbeCoalesced)
+
+	if !q.schedule(probeJob{service: "api-gateway", configuredUp: true}) {
+		t.Fatal("expected the first probe to be queued")
+	}
+	if q.schedule(probeJob{service: "api-gateway", configuredUp: true}) {
+		t.Error("expected a duplicate probe to be dropped")
+	}
+	if !q.schedule(probeJob{service: "auth-service"}) {
+		t.Error("expected a probe of another service to be queued")
+	}
+	if v := testutil.ToFloat64(m.ProbeCoalesced); v != 1 {
+		t.Errorf("expected 1 coalesced probe, got %v", v)
+	}
+
+	// Once picked up by the worker the service can be queued again
+	job, ok := q.next()
+	if !ok || job.service != "api-gateway" {
+		t.Fatalf("expected api-gateway to be picked up first, got %+v", job)
+	}
+	if !q.schedule(probeJob{service: "api-gateway", configuredUp: true}) {
+		t.Error("expected a probe to be queued after pickup")
+	}
+	if got := len(q.jobs); got != 2 {
+		t.Errorf("expected 2 queued probes, got %d", got)
+	}
+
+	q.close()
+	for i := 0; i < 2; i++ {
+		if _, ok := q.next(); !ok {
+			t.Fatal("expected the queued probes to be drained after close")
+		}
+	}
+	if _, ok := q.next(); ok {
+		t.Error("expected next to report a closed queue")
+	}
+}
+
+func TestScheduleProbes_SlowWorker(t *testing.T) {
+	m := newTestMetrics()
+	tracker := NewSLOTracker(10, defaultHistoryDepth)
+	q := newProbeQueue(10, m.ProbeCoalesced)
+	config := &Config{UpServices: []string{"a", "b"}, DownServices: []string{"c"}}
+	noProbeJitter(t)
+	schedule := newProbeSchedule(time.Minute)
+
+	// Three rounds while the worker is stuck queue each service only once
+	ctx := probeTestContext(t)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		scheduleProbes(ctx, m, tracker, q, schedule, config, start.Add(time.Duration(i)*time.Minute))
+	}
+	if got := len(q.jobs); got != 3 {
+		t.Errorf("expected 3 queued probes, got %d", got)
+	}
+	if v := testutil.ToFloat64(m.ProbeCoalesced); v != 6 {
+		t.Errorf("expected 6 coalesced probes, got %v", v)
+	}
+
+	q.close()
+	runSLOProbeWorker(m, tracker, q)
+	for _, service := range []string{"a", "b", "c"} {
+		if n := probeCount(tracker, service); n != 1 {
+			t.Errorf("expected %s to be probed once, got %d", service, n)
+		}
+	}
+}
+
+func TestRunSLOProbeWorker_RecordsIdleTime(t *testing.T) {
+	m := newTestMetrics()
+	tracker := NewSLOTracker(10, defaultHistoryDepth)
+	q := newProbeQueue(10, m.ProbeCoalesced)
+	ctx := probeTestContext(t)
+
+	q.schedule(probeJob{service: "a", ctx: ctx})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runSLOProbeWorker(m, tracker, q)
+	}()
+
+	// The worker finishes a and waits for b
+	idle := 50 * time.Millisecond
+	time.Sleep(idle)
+	q.schedule(probeJob{service: "b", ctx: ctx})
+	q.close()
+	<-done
+
+	var metric dto.Metric
+	if err := m.ProbeWorkerIdle.Write(&metric); err != nil {
+		t.Fatalf("failed to read histogram: %v", err)
+	}
+	h := metric.GetHistogram()
+	if h.GetSampleCount() != 1 {
+		t.Fatalf("expected 1 idle observation, got %d", h.GetSampleCount())
+	}
+	if h.GetSampleSum() < idle.Seconds()/2 {
+		t.Errorf("expected an idle time close to %s, got %vs", idle, h.GetSampleSum())
+	}
+}
+
+func TestRunSLOProbeWorker_ActiveProbes(t *testing.T) {
+	m := newTestMetrics()
+	tracker := NewSLOTracker(10, defaultHistoryDepth)
+	q := newProbeQueue(10, m.ProbeCoalesced)
+	ctx := probeTestContext(t)
+
+	// The health check blocks the worker until it is released
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer upstream.Close()
+
+	q.schedule(probeJob{service: "a", ctx: ctx, target: &ProbeTarget{URL: upstream.URL}})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runSLOProbeWorker(m, tracker, q)
+	}()
+
+	<-started
+	if v := testutil.ToFloat64(m.ActiveProbeGoroutines); v != 1 {
+		t.Errorf("expected 1 active probe while the check runs, got %v", v)
+	}
+	close(release)
+	q.close()
+	<-done
+	if v := testutil.ToFloat64(m.ActiveProbeGoroutines); v != 0 {
+		t.Errorf("expected no active probes once the worker is done, got %v", v)
+	}
+}
+
+func TestRunSLOProbes_WorkerCount(t *testing.T) {
+	m := newTestMetrics()
+	tracker := NewSLOTracker(10, defaultHistoryDepth)
+	preserveConfigState(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runSLOProbes(ctx, m, tracker, time.Minute, 4)
+	if v := testutil.ToFloat64(m.ProbeWorkerCount); v != 4 {
+		t.Errorf("expected 4 probe workers, got %v", v)
+	}
+}
+
+func TestRunSLOProbes_ConcurrentWorkers(t *testing.T) {
+	preserveConfigState(t)
+	noProbeJitter(t)
+
+	// Health checks block until released, so both workers end up busy
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer upstream.Close()
+	currentConfig.Store(&Config{
+		UpServices: []string{"a", "b"},
+		Probes:     map[string]ProbeTarget{"a": {URL: upstream.URL}, "b": {URL: upstream.URL}},
+	})
+
+	m := newTestMetrics()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runSLOProbes(ctx, m, NewSLOTracker(10, defaultHistoryDepth), time.Minute, 2)
+	}()
+
+	if !waitFor(t, 5*time.Second, func() bool { return testutil.ToFloat64(m.ActiveProbeGoroutines) == 2 }) {
+		t.Errorf("expected 2 probes to run at once, got %v", testutil.ToFloat64(m.ActiveProbeGoroutines))
+	}
+	close(release)
+	cancel()
+	<-done
+}
+
+// panickingProber makes the probes of service panic in the HTTP client
+func panickingProber(m *Metrics, service string) {
+	m.Prober.client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Context().Value(probeServiceKey{}) == service {
+			panic("custom probe type")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	})}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestRunSLOProbeWorker_RecoversFromPanickingJob(t *testing.T) {
+	m := newTestMetrics()
+	panickingProber(m, "a")
+	tracker := NewSLOTracker(10, defaultHistoryDepth)
+	q := newProbeQueue(10, m.ProbeCoalesced)
+	ctx := probeTestContext(t)
+
+	target := &ProbeTarget{URL: "http://probe.test/healthz"}
+	q.schedule(probeJob{service: "a", configuredUp: true, ctx: ctx, target: target})
+	q.schedule(probeJob{service: "b", configuredUp: true, ctx: ctx, target: target})
+	q.close()
+	runSLOProbeWorker(m, tracker, q)
+
+	if v := testutil.ToFloat64(m.ProbeErrors); v != 1 {
+		t.Errorf("expected 1 probe error, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.ActiveProbeGoroutines); v != 0 {
+		t.Errorf("expected the active probes gauge to drop back to 0, got %v", v)
+	}
+	if n := probeCount(tracker, "b"); n != 1 {
+		t.Errorf("expected the worker to go on probing b, got %d probes", n)
+	}
+}
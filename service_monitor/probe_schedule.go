@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// probeJitter returns how long after a service first appears its first probe
+// runs, a random duration in [0, interval); replaced in tests
+var probeJitter = func(interval time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(interval)))
+}
+
+// probeSchedule tracks when each service is next due for a probe
+// Every service keeps its own phase, so services that appear together are
+// spread over the interval instead of all being probed at the same moment
+type probeSchedule struct {
+	interval time.Duration
+	next     map[string]time.Time
+}
+
+func newProbeSchedule(interval time.Duration) *probeSchedule {
+	return &probeSchedule{
+		interval: interval,
+		next:     make(map[string]time.Time),
+	}
+}
+
+// due reports whether service is due for a probe at now and advances its
+// next probe by one interval if so; a service seen for the first time is
+// given a jittered first probe
+func (s *probeSchedule) due(m *Metrics, service string, now time.Time) bool {
+	next, ok := s.next[service]
+	if !ok {
+		next = now.Add(probeJitter(s.interval))
+		m.ProbeJitterApplied.Inc()
+	}
+	if next.After(now) {
+		s.next[service] = next
+		return false
+	}
+
+	next = next.Add(s.interval)
+	if !next.After(now) {
+		// The scheduler fell behind by more than an interval
+		next = now.Add(s.interval)
+	}
+	s.next[service] = next
+	return true
+}
+
+// until returns the time from now until the next probe is due, at most one interval
+func (s *probeSchedule) until(now time.Time) time.Duration {
+	wait := s.interval
+	for _, next := range s.next {
+		if d := next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// retain forgets the services not in seen
+func (s *probeSchedule) retain(seen map[string]bool) {
+	for service := range s.next {
+		if !seen[service] {
+			delete(s.next, service)
+		}
+	}
+}
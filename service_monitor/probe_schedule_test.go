@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// noProbeJitter makes every first probe due as soon as the service appears
+func noProbeJitter(t *testing.T) {
+	t.Helper()
+	orig := probeJitter
+	probeJitter = func(time.Duration) time.Duration { return 0 }
+	t.Cleanup(func() { probeJitter = orig })
+}
+
+func TestProbeSchedule_JitteredFirstProbe(t *testing.T) {
+	jitters := map[string]time.Duration{"a": 0, "b": 10 * time.Second, "c": 45 * time.Second}
+	var next []time.Duration
+	for _, d := range jitters {
+		next = append(next, d)
+	}
+	orig := probeJitter
+	probeJitter = func(time.Duration) time.Duration {
+		d := next[0]
+		next = next[1:]
+		return d
+	}
+	t.Cleanup(func() { probeJitter = orig })
+
+	m := newTestMetrics()
+	s := newProbeSchedule(time.Minute)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Collect the probe times of every service over three intervals
+	probes := map[string][]time.Duration{}
+	for offset := time.Duration(0); offset < 3*time.Minute; offset += time.Second {
+		for _, service := range []string{"a", "b", "c"} {
+			if s.due(m, service, start.Add(offset)) {
+				probes[service] = append(probes[service], offset)
+			}
+		}
+	}
+
+	if v := testutil.ToFloat64(m.ProbeJitterApplied); v != 3 {
+		t.Errorf("expected jitter to be applied to 3 services, got %v", v)
+	}
+
+	// Each service is probed once per interval, shifted by its own jitter
+	for service, probed := range probes {
+		if len(probed) != 3 {
+			t.Fatalf("%s: expected 3 probes, got %v", service, probed)
+		}
+		for i := 1; i < len(probed); i++ {
+			if gap := probed[i] - probed[i-1]; gap != time.Minute {
+				t.Errorf("%s: expected probes one interval apart, got %v", service, probed)
+				break
+			}
+		}
+	}
+	if len(probes) != 3 || probes["a"][0] == probes["b"][0] || probes["b"][0] == probes["c"][0] {
+		t.Errorf("expected the first probes to be spread out, got %v", probes)
+	}
+}
+
+func TestProbeSchedule_UntilAndRetain(t *testing.T) {
+	noProbeJitter(t)
+	m := newTestMetrics()
+	s := newProbeSchedule(time.Minute)
+	now := time.Now()
+
+	if d := s.until(now); d != time.Minute {
+		t.Errorf("expected an empty schedule to wait one interval, got %v", d)
+	}
+
+	s.due(m, "a", now)
+	s.due(m, "b", now.Add(20*time.Second))
+	if d := s.until(now.Add(30 * time.Second)); d != 30*time.Second {
+		t.Errorf("expected to wait for the probe of a, got %v", d)
+	}
+
+	// A scheduler that fell behind probes right away and keeps an interval after that
+	if !s.due(m, "a", now.Add(5*time.Minute)) {
+		t.Fatal("expected an overdue probe to be due")
+	}
+	if got := s.next["a"]; !got.Equal(now.Add(6 * time.Minute)) {
+		t.Errorf("expected the next probe one interval after the late one, got %v", got.Sub(now))
+	}
+
+	s.retain(map[string]bool{"a": true})
+	if _, ok := s.next["b"]; ok {
+		t.Error("expected a removed service to be forgotten")
+	}
+
+	// A service that comes back is jittered again
+	s.due(m, "b", now)
+	if v := testutil.ToFloat64(m.ProbeJitterApplied); v != 3 {
+		t.Errorf("expected jitter to be applied 3 times, got %v", v)
+	}
+}
+
+func TestProbeJitter_Distribution(t *testing.T) {
+	const (
+		services = 100
+		bins     = 10
+		interval = 30 * time.Second
+		step     = 100 * time.Millisecond
+	)
+
+	config := &Config{}
+	for i := 0; i < services; i++ {
+		config.UpServices = append(config.UpServices, fmt.Sprintf("service-%03d", i))
+	}
+	m := newTestMetrics()
+	tracker := NewSLOTracker(10, defaultHistoryDepth)
+	q := newProbeQueue(services, m.ProbeCoalesced)
+	schedule := newProbeSchedule(interval)
+
+	// Step through the first interval and note when each service is first
+	// queued; a probe due just before the end is queued at the end
+	ctx := probeTestContext(t)
+	start := time.Now()
+	first := map[string]time.Duration{}
+	for offset := time.Duration(0); offset <= interval; offset += step {
+		scheduleProbes(ctx, m, tracker, q, schedule, config, start.Add(offset))
+		for len(q.jobs) > 0 {
+			job, _ := q.next()
+			if _, ok := first[job.service]; !ok {
+				first[job.service] = offset
+			}
+		}
+	}
+	if len(first) != services {
+		t.Fatalf("expected all %d services to be probed within one interval, got %d", services, len(first))
+	}
+
+	observed := make([]float64, bins)
+	for _, offset := range first {
+		observed[min(int(offset*bins/interval), bins-1)]++
+	}
+	expected := make([]float64, bins)
+	for i := range expected {
+		expected[i] = float64(services) / bins
+	}
+
+	// Every part of the window gets probes, and the counts per bin are
+	// consistent with a uniform spread at the 0.1% significance level
+	for i, n := range observed {
+		if n == 0 {
+			t.Errorf("no first probes in bin %d of %d: %v", i, bins, observed)
+		}
+	}
+	chi2 := stat.ChiSquare(observed, expected)
+	if p := (distuv.ChiSquared{K: bins - 1}).Survival(chi2); p < 0.001 {
+		t.Errorf("first probes are not spread evenly (chi2=%.2f, p=%.5f): %v", chi2, p, observed)
+	}
+}
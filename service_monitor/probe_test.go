@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProbeHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer upstream.Close()
+
+	// A listener that was closed gives an address nothing accepts on
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	preserveConfigState(t)
+	currentConfig.Store(&Config{
+		UpServices: []string{"api-gateway", "auth-service", "cache", "queue"},
+		Probes: map[string]ProbeTarget{
+			"api-gateway":  {URL: upstream.URL + "/healthz"},
+			"auth-service": {URL: upstream.URL + "/broken"},
+			"cache":        {TCPAddress: upstream.Listener.Addr().String()},
+			"queue":        {TCPAddress: closedAddr},
+		},
+	})
+
+	m := newTestMetrics()
+	mux := NewAppServeMux(m, &ServerConfig{AdminUsername: "admin", AdminPassword: "secret"})
+	probe := func(service string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/probe?service="+service, nil)
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		service    string
+		probeType  string
+		status     int
+		statusCode int
+	}{
+		{"api-gateway", probeTypeHTTP, 1, http.StatusOK},
+		{"auth-service", probeTypeHTTP, 0, http.StatusInternalServerError},
+		{"cache", probeTypeTCP, 1, 0},
+		{"queue", probeTypeTCP, 0, 0},
+	}
+	for _, tt := range tests {
+		rec := probe(tt.service)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", tt.service, rec.Code, rec.Body.String())
+		}
+		var resp probeResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: invalid JSON: %v", tt.service, err)
+		}
+		if resp.Service != tt.service || resp.ProbeType != tt.probeType || resp.Status != tt.status || resp.StatusCode != tt.statusCode {
+			t.Errorf("%s: unexpected response %+v", tt.service, resp)
+		}
+		if (resp.Status == 0) != (resp.Error != "") {
+			t.Errorf("%s: expected an error exactly when the probe failed, got %+v", tt.service, resp)
+		}
+		if resp.DurationMs <= 0 {
+			t.Errorf("%s: expected a positive duration, got %v", tt.service, resp.DurationMs)
+		}
+	}
+
+	for _, c := range []struct {
+		probeType, result string
+	}{{probeTypeHTTP, "success"}, {probeTypeHTTP, "failure"}, {probeTypeTCP, "success"}, {probeTypeTCP, "failure"}} {
+		if v := testutil.ToFloat64(m.OnDemandProbes.WithLabelValues(c.probeType, c.result)); v != 1 {
+			t.Errorf("expected 1 %s %s probe, got %v", c.probeType, c.result, v)
+		}
+	}
+
+	// Diagnostic probes never touch the status gauges
+	if n := testutil.CollectAndCount(m.ServiceStatus); n != 0 {
+		t.Errorf("expected no service_monitor_up series, got %d", n)
+	}
+
+	if rec := probe("user-service"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a service without a probe, got %d", rec.Code)
+	}
+	if rec := probe(""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a service, got %d", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?service=api-gateway", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without credentials, got %d", rec.Code)
+	}
+}
+
+func TestRunProbe_Timeout(t *testing.T) {
+	// The upstream answers only after the probe has given up, and reports
+	// whether the probe's request was cancelled
+	cancelled := make(chan bool, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			cancelled <- true
+		case <-time.After(5 * time.Second):
+			cancelled <- false
+		}
+	}))
+	defer upstream.Close()
+
+	assertAborted := func(name string, resp probeResponse, elapsed time.Duration) {
+		t.Helper()
+		if resp.Status != 0 || resp.Error == "" {
+			t.Errorf("%s: expected a failed probe, got %+v", name, resp)
+		}
+		if elapsed > time.Second {
+			t.Errorf("%s: expected the probe to return promptly, took %s", name, elapsed)
+		}
+		if !<-cancelled {
+			t.Errorf("%s: expected the upstream request to be cancelled", name)
+		}
+	}
+
+	m := newTestMetrics()
+	start := time.Now()
+	resp := m.Prober.runProbe(context.Background(), "api", ProbeTarget{URL: upstream.URL, TimeoutMs: 50})
+	assertAborted("timeout", resp, time.Since(start))
+	if !strings.Contains(resp.Error, "deadline exceeded") {
+		t.Errorf("timeout: expected a deadline error, got %q", resp.Error)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	resp = m.Prober.runProbe(ctx, "api", ProbeTarget{URL: upstream.URL, TimeoutMs: 10000})
+	assertAborted("cancelled", resp, time.Since(start))
+	if !strings.Contains(resp.Error, "canceled") {
+		t.Errorf("cancelled: expected a cancellation error, got %q", resp.Error)
+	}
+
+	// Only the probe that ran out of time failed to connect
+	if v := testutil.ToFloat64(m.ProbeConnectionErrors.WithLabelValues(probeConnectionErrorTimeout)); v != 1 {
+		t.Errorf("expected 1 timeout connection error, got %v", v)
+	}
+	if n := testutil.CollectAndCount(m.ProbeConnectionErrors); n != 1 {
+		t.Errorf("expected only timeout connection errors, got %d series", n)
+	}
+}
+
+func TestProbeTarget_Timeout(t *testing.T) {
+	for _, tt := range []struct {
+		timeoutMs int
+		want      time.Duration
+	}{{0, defaultProbeTimeout}, {-1, defaultProbeTimeout}, {250, 250 * time.Millisecond}} {
+		if got := (ProbeTarget{TimeoutMs: tt.timeoutMs}).timeout(); got != tt.want {
+			t.Errorf("timeout_ms %d: expected %s, got %s", tt.timeoutMs, tt.want, got)
+		}
+	}
+}
+
+func TestProbeTargetProblems(t *testing.T) {
+	problems := probeTargetProblems(map[string]ProbeTarget{
+		"a": {URL: "http://a:8080/healthz"},
+		"b": {TCPAddress: "b:5432"},
+		"c": {},
+		"d": {URL: "http://d", TCPAddress: "d:80"},
+		"e": {URL: "ftp://e/"},
+		"f": {TCPAddress: "f"},
+	})
+
+	want := []string{
+		`probe of service "c" must set exactly one of url and tcp_address`,
+		`probe of service "d" must set exactly one of url and tcp_address`,
+		`probe of service "e" has invalid url "ftp://e/"`,
+		`probe of service "f" has invalid tcp_address "f"`,
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected problems:\n%s", strings.Join(problems, "\n"))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProbeMaxIdleConnsPerHost = 2
+	defaultProbeIdleConnTimeout     = 90 * time.Second
+)
+
+// Values of the error_type label of service_monitor_probe_connection_errors_total
+const (
+	probeConnectionErrorDial    = "dial"
+	probeConnectionErrorTLS     = "tls"
+	probeConnectionErrorTimeout = "timeout"
+)
+
+// Prober runs the /probe checks and SLO health checks over a shared
+// transport, so probes of the same endpoint reuse idle connections
+type Prober struct {
+	// Transport of the HTTP probes; its pool settings may be changed before
+	// the first probe
+	Transport *http.Transport
+
+	client *http.Client
+	dialer *countingDialer
+	m      *Metrics
+}
+
+// NewProber returns a prober whose connections are tracked in m
+func NewProber(m *Metrics) *Prober {
+	dialer := &countingDialer{dialer: net.Dialer{Timeout: defaultProbeTimeout}, m: m}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		MaxIdleConnsPerHost: defaultProbeMaxIdleConnsPerHost,
+		IdleConnTimeout:     defaultProbeIdleConnTimeout,
+		TLSHandshakeTimeout: defaultProbeTimeout,
+	}
+	return &Prober{
+		Transport: transport,
+		client:    &http.Client{Transport: transport},
+		dialer:    dialer,
+		m:         m,
+	}
+}
+
+// probeServiceKey is the context key of the service a probe checks
+type probeServiceKey struct{}
+
+// withProbeService returns ctx carrying the service connections dialed with it
+// are counted against
+func withProbeService(ctx context.Context, service string) context.Context {
+	return context.WithValue(ctx, probeServiceKey{}, service)
+}
+
+// countingDialer dials probe connections, tracks the open ones and counts
+// their traffic
+// The bytes of a connection are counted against the service whose probe
+// dialed it, so services probed at the same host:port share their traffic
+// through reused connections
+type countingDialer struct {
+	dialer net.Dialer
+	m      *Metrics
+}
+
+// DialContext dials addr and counts the connection until it is closed
+func (d *countingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	d.m.ProbeTCPConnections.Inc()
+	d.m.ProbeConnectionsActive.Inc()
+	service, _ := ctx.Value(probeServiceKey{}).(string)
+	return &countingConn{Conn: conn, m: d.m, service: service}, nil
+}
+
+// countingConn is a probe connection that counts the bytes it transfers and
+// leaves the active connections gauge when it is closed
+// The byte counters are looked up on every read and write, so the traffic of
+// open connections is still counted after /metrics/reset clears them
+type countingConn struct {
+	net.Conn
+	m         *Metrics
+	service   string
+	closeOnce sync.Once
+}
+
+// Read reads from the connection, counting the bytes received
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.m.ProbeBytesReceived.WithLabelValues(c.service).Add(float64(n))
+	return n, err
+}
+
+// Write writes to the connection, counting the bytes sent
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.m.ProbeBytesSent.WithLabelValues(c.service).Add(float64(n))
+	return n, err
+}
+
+// Close closes the connection, counting it as closed only once
+func (c *countingConn) Close() error {
+	c.closeOnce.Do(c.m.ProbeConnectionsActive.Dec)
+	return c.Conn.Close()
+}
+
+// probeConnectionErrorType returns the error_type of a probe that failed to
+// connect, or "" when err isn't a connection error
+// Timeouts are reported as such whichever step of the probe ran out of time
+func probeConnectionErrorType(err error) string {
+	var netErr net.Error
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return probeConnectionErrorTimeout
+	case errors.As(err, &recordErr) || errors.As(err, &verifyErr):
+		return probeConnectionErrorTLS
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		// An alert sent by the server during the handshake
+		return probeConnectionErrorTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return probeConnectionErrorDial
+	default:
+		return ""
+	}
+}
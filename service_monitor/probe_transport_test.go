@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProber_ReusesConnections(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	m := newTestMetrics()
+	for i := 0; i < 3; i++ {
+		if resp := m.Prober.runProbe(context.Background(), "api", ProbeTarget{URL: upstream.URL}); resp.Status != 1 {
+			t.Fatalf("expected a successful probe, got %+v", resp)
+		}
+	}
+	if v := testutil.ToFloat64(m.ProbeTCPConnections); v != 1 {
+		t.Errorf("expected the probes to share 1 connection, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.ProbeConnectionsActive); v != 1 {
+		t.Errorf("expected 1 idle connection to stay open, got %v", v)
+	}
+
+	m.Prober.Transport.CloseIdleConnections()
+	if v := testutil.ToFloat64(m.ProbeConnectionsActive); v != 0 {
+		t.Errorf("expected no open connections after closing idle ones, got %v", v)
+	}
+
+	// TCP probes close their connection right away
+	if resp := m.Prober.runProbe(context.Background(), "cache", ProbeTarget{TCPAddress: upstream.Listener.Addr().String()}); resp.Status != 1 {
+		t.Fatalf("expected a successful TCP probe, got %+v", resp)
+	}
+	if v := testutil.ToFloat64(m.ProbeTCPConnections); v != 2 {
+		t.Errorf("expected 2 connections, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.ProbeConnectionsActive); v != 0 {
+		t.Errorf("expected the TCP probe connection to be closed, got %v", v)
+	}
+}
+
+func TestProber_ConnectionErrors(t *testing.T) {
+	// A listener that was closed gives an address nothing accepts on
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	// The probe transport doesn't trust the test server's certificate
+	tlsUpstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tlsUpstream.Config.ErrorLog = log.New(io.Discard, "", 0)
+	tlsUpstream.StartTLS()
+	defer tlsUpstream.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	m := newTestMetrics()
+	for _, target := range []ProbeTarget{
+		{URL: "http://" + closedAddr},
+		{TCPAddress: closedAddr},
+		{URL: tlsUpstream.URL},
+		{URL: upstream.URL},
+	} {
+		if resp := m.Prober.runProbe(context.Background(), "api", target); resp.Status != 0 {
+			t.Errorf("expected the probe of %+v to fail, got %+v", target, resp)
+		}
+	}
+
+	// The 503 answer is not a connection error
+	for errorType, want := range map[string]float64{probeConnectionErrorDial: 2, probeConnectionErrorTLS: 1, probeConnectionErrorTimeout: 0} {
+		if v := testutil.ToFloat64(m.ProbeConnectionErrors.WithLabelValues(errorType)); v != want {
+			t.Errorf("expected %v %s errors, got %v", want, errorType, v)
+		}
+	}
+}
+
+func TestProber_CountsBytes(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	m := newTestMetrics()
+	if resp := m.Prober.runProbe(context.Background(), "api", ProbeTarget{URL: upstream.URL + "/healthz"}); resp.Status != 1 {
+		t.Fatalf("expected a successful probe, got %+v", resp)
+	}
+	sent := testutil.ToFloat64(m.ProbeBytesSent.WithLabelValues("api"))
+	received := testutil.ToFloat64(m.ProbeBytesReceived.WithLabelValues("api"))
+	if sent < float64(len("GET /healthz HTTP/1.1\r\n")) {
+		t.Errorf("expected the request to be counted as sent, got %v bytes", sent)
+	}
+	if received < float64(len(body)) {
+		t.Errorf("expected the response to be counted as received, got %v bytes", received)
+	}
+
+	// A second probe of another service reuses the connection, whose traffic
+	// stays with the service that dialed it
+	m.Prober.runProbe(context.Background(), "web", ProbeTarget{URL: upstream.URL + "/healthz"})
+	if v := testutil.ToFloat64(m.ProbeBytesReceived.WithLabelValues("api")); v < 2*float64(len(body)) {
+		t.Errorf("expected both responses counted against api, got %v bytes", v)
+	}
+}
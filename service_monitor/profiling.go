@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/grafana/pyroscope-go"
+)
+
+// registerPprofHandlers exposes the runtime profiles under /debug/pprof/
+func registerPprofHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/pprof/mutex", pprof.Handler("mutex"))
+	mux.Handle("/debug/pprof/block", pprof.Handler("block"))
+}
+
+// configureProfiling enables optional runtime profiling from environment variables
+func configureProfiling() {
+	// MUTEX_PROFILE_FRACTION samples 1/n contended lock events; 1 records
+	// every event and is expensive in production
+	if value := os.Getenv("MUTEX_PROFILE_FRACTION"); value != "" {
+		fraction, err := strconv.Atoi(value)
+		if err != nil || fraction < 0 {
+			log.Printf("Invalid MUTEX_PROFILE_FRACTION %q, mutex profiling disabled", value)
+		} else {
+			runtime.SetMutexProfileFraction(fraction)
+			log.Printf("Mutex profiling enabled with fraction %d", fraction)
+		}
+	}
+
+	// BLOCK_PROFILE_RATE_NS samples one blocking event per n nanoseconds spent
+	// blocked; 1 records every event and can add around 30% CPU overhead
+	if value := os.Getenv("BLOCK_PROFILE_RATE_NS"); value != "" {
+		rate, err := strconv.Atoi(value)
+		if err != nil || rate < 0 {
+			log.Printf("Invalid BLOCK_PROFILE_RATE_NS %q, block profiling disabled", value)
+		} else {
+			runtime.SetBlockProfileRate(rate)
+			log.Printf("Block profiling enabled with rate %dns", rate)
+		}
+	}
+}
+
+// startContinuousProfiling streams CPU and allocation profiles to the Pyroscope
+// server at PYROSCOPE_SERVER_ADDR, if set
+func startContinuousProfiling() {
+	serverAddr := os.Getenv("PYROSCOPE_SERVER_ADDR")
+	if serverAddr == "" {
+		return
+	}
+
+	environment := os.Getenv("ENVIRONMENT")
+	if environment == "" {
+		environment = "development"
+	}
+
+	_, err := pyroscope.Start(pyroscope.Config{
+		ApplicationName: "service_monitor",
+		ServerAddress:   serverAddr,
+		Tags: map[string]string{
+			"application": "service_monitor",
+			"environment": environment,
+		},
+		ProfileTypes: []pyroscope.ProfileType{
+			pyroscope.ProfileCPU,
+			pyroscope.ProfileAllocObjects,
+			pyroscope.ProfileAllocSpace,
+		},
+	})
+	if err != nil {
+		log.Printf("Error starting Pyroscope profiler: %v", err)
+		return
+	}
+	log.Printf("Continuous profiling enabled, sending profiles to %s (environment=%s)", serverAddr, environment)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// labelMatcher is one name<op>"value" term of a series selector
+type labelMatcher struct {
+	name  string
+	op    string
+	value string
+
+	// Compiled value of =~ and !~, anchored at both ends like PromQL
+	re *regexp.Regexp
+}
+
+// matches reports whether value satisfies the matcher
+func (lm labelMatcher) matches(value string) bool {
+	switch lm.op {
+	case "=":
+		return value == lm.value
+	case "!=":
+		return value != lm.value
+	case "=~":
+		return lm.re.MatchString(value)
+	default:
+		return !lm.re.MatchString(value)
+	}
+}
+
+// selectorParser parses a PromQL instant vector selector such as
+// service_monitor_up{service="api",status!~"5.."}
+type selectorParser struct {
+	expr string
+	pos  int
+}
+
+// parseSelector returns the matchers of expr, the metric name becoming a
+// __name__ matcher; functions, operators and range selectors are rejected
+func parseSelector(expr string) ([]labelMatcher, error) {
+	p := &selectorParser{expr: expr}
+	var matchers []labelMatcher
+
+	p.skipSpaces()
+	if name := p.identifier(true); name != "" {
+		matchers = append(matchers, labelMatcher{name: "__name__", op: "=", value: name})
+	}
+	p.skipSpaces()
+	if p.consume("{") {
+		for {
+			p.skipSpaces()
+			if p.consume("}") {
+				break
+			}
+			matcher, err := p.matcher()
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, matcher)
+
+			p.skipSpaces()
+			if p.consume("}") {
+				break
+			}
+			if !p.consume(",") {
+				return nil, p.unexpected("expected , or }")
+			}
+		}
+		p.skipSpaces()
+	}
+
+	if p.pos < len(p.expr) {
+		return nil, p.unexpected("only label selectors like name{label=\"value\"} are supported")
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("selector must have a metric name or at least one label matcher")
+	}
+	return matchers, nil
+}
+
+// matcher parses label<op>"value"
+func (p *selectorParser) matcher() (labelMatcher, error) {
+	name := p.identifier(false)
+	if name == "" {
+		return labelMatcher{}, p.unexpected("expected a label name")
+	}
+	p.skipSpaces()
+
+	var op string
+	for _, candidate := range []string{"=~", "!~", "!=", "="} {
+		if p.consume(candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return labelMatcher{}, p.unexpected("expected =, !=, =~ or !~")
+	}
+	p.skipSpaces()
+
+	value, err := p.quoted()
+	if err != nil {
+		return labelMatcher{}, err
+	}
+	matcher := labelMatcher{name: name, op: op, value: value}
+	if op == "=~" || op == "!~" {
+		if matcher.re, err = regexp.Compile("^(?:" + value + ")$"); err != nil {
+			return labelMatcher{}, fmt.Errorf("invalid regular expression %q for label %q: %w", value, name, err)
+		}
+	}
+	return matcher, nil
+}
+
+// identifier consumes a label name, or a metric name which may contain colons
+func (p *selectorParser) identifier(metricName bool) string {
+	start := p.pos
+	for p.pos < len(p.expr) {
+		c := p.expr[p.pos]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && p.pos > start:
+		case c == ':' && metricName:
+		default:
+			return p.expr[start:p.pos]
+		}
+		p.pos++
+	}
+	return p.expr[start:p.pos]
+}
+
+// quoted consumes a string in double quotes, single quotes or backticks
+func (p *selectorParser) quoted() (string, error) {
+	if p.pos >= len(p.expr) || !strings.ContainsRune(`"'`+"`", rune(p.expr[p.pos])) {
+		return "", p.unexpected("expected a quoted label value")
+	}
+	quote := p.expr[p.pos]
+	start := p.pos
+	for p.pos++; p.pos < len(p.expr); p.pos++ {
+		switch p.expr[p.pos] {
+		case '\\':
+			if quote != '`' {
+				p.pos++
+			}
+		case quote:
+			p.pos++
+			content := p.expr[start+1 : p.pos-1]
+			if quote == '`' {
+				return content, nil
+			}
+			var value strings.Builder
+			for content != "" {
+				r, _, tail, err := strconv.UnquoteChar(content, quote)
+				if err != nil {
+					return "", fmt.Errorf("invalid label value %s: %w", p.expr[start:p.pos], err)
+				}
+				value.WriteRune(r)
+				content = tail
+			}
+			return value.String(), nil
+		}
+	}
+	return "", fmt.Errorf("unterminated label value starting at position %d", start)
+}
+
+// skipSpaces advances past whitespace
+func (p *selectorParser) skipSpaces() {
+	for p.pos < len(p.expr) && strings.ContainsRune(" \t\n\r", rune(p.expr[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume advances past token if the input continues with it
+func (p *selectorParser) consume(token string) bool {
+	if strings.HasPrefix(p.expr[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+// unexpected describes the input at the current position
+func (p *selectorParser) unexpected(hint string) error {
+	if p.pos >= len(p.expr) {
+		return fmt.Errorf("unexpected end of expression: %s", hint)
+	}
+	return fmt.Errorf("unexpected %q at position %d: %s", p.expr[p.pos], p.pos, hint)
+}
+
+// selectSamples returns the samples whose labels satisfy every matcher,
+// a missing label counting as the empty string like in PromQL
+func selectSamples(samples []exportSample, matchers []labelMatcher) []exportSample {
+	var selected []exportSample
+	for _, s := range samples {
+		labels := make(map[string]string, len(s.labels)+1)
+		for _, l := range s.labels {
+			labels[l.name] = l.value
+		}
+		labels["__name__"] = s.name
+
+		ok := true
+		for _, matcher := range matchers {
+			if !matcher.matches(labels[matcher.name]) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			selected = append(selected, s)
+		}
+	}
+	return selected
+}
+
+// queryHandler evaluates the selector in ?expr= against the local registry
+// and returns the matching samples in the /metrics/export JSON format
+func queryHandler(m *Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		expr := r.URL.Query().Get("expr")
+		if expr == "" {
+			http.Error(w, "expr is required", http.StatusBadRequest)
+			return
+		}
+		matchers, err := parseSelector(expr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid expr: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		mfs, err := m.Exposition.Gather()
+		if err != nil {
+			log.Printf("Query gathered with errors: %v", err)
+		}
+
+		selected := selectSamples(flattenSamples(mfs, time.Now()), matchers)
+		result := make([]jsonSample, len(selected))
+		for i, s := range selected {
+			result[i] = s.toJSON()
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    []labelMatcher
+		wantErr bool
+	}{
+		{expr: "service_monitor_up", want: []labelMatcher{{name: "__name__", op: "=", value: "service_monitor_up"}}},
+		{expr: ` up { service = "api" , env!='prod', } `, want: []labelMatcher{
+			{name: "__name__", op: "=", value: "up"},
+			{name: "service", op: "=", value: "api"},
+			{name: "env", op: "!=", value: "prod"},
+		}},
+		{expr: "{job=~`a|b`}", want: []labelMatcher{{name: "job", op: "=~", value: "a|b"}}},
+		{expr: `x{path="C:\\tmp \"q\""}`, want: []labelMatcher{
+			{name: "__name__", op: "=", value: "x"},
+			{name: "path", op: "=", value: `C:\tmp "q"`},
+		}},
+		{expr: "job:requests:rate5m", want: []labelMatcher{{name: "__name__", op: "=", value: "job:requests:rate5m"}}},
+		{expr: "", wantErr: true},
+		{expr: "{}", wantErr: true},
+		{expr: `rate(up[5m])`, wantErr: true},
+		{expr: `sum(up)`, wantErr: true},
+		{expr: `up[5m]`, wantErr: true},
+		{expr: `up{service="api"`, wantErr: true},
+		{expr: `up{service=api}`, wantErr: true},
+		{expr: `up{service="api}`, wantErr: true},
+		{expr: `up{service=~"("}`, wantErr: true},
+		{expr: `up{1service="api"}`, wantErr: true},
+		{expr: `up > 0`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSelector(tt.expr)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tt.expr, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.expr, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: expected %d matchers, got %v", tt.expr, len(tt.want), got)
+			continue
+		}
+		for i := range got {
+			if got[i].name != tt.want[i].name || got[i].op != tt.want[i].op || got[i].value != tt.want[i].value {
+				t.Errorf("%q: matcher %d: expected %+v, got %+v", tt.expr, i, tt.want[i], got[i])
+			}
+		}
+	}
+}
+
+func TestQueryHandler(t *testing.T) {
+	m := newTestMetrics()
+	setTestServices(t, []string{"api", "payment-service"}, []string{"db"})
+	updateServiceMetrics(m, loadCurrentConfig())
+	mux := NewMetricsServeMux(m, &ServerConfig{})
+
+	query := func(expr string) (int, []jsonSample) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/query?expr="+url.QueryEscape(expr), nil))
+		var samples []jsonSample
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &samples); err != nil {
+				t.Fatalf("%q: invalid JSON: %v", expr, err)
+			}
+		}
+		return rec.Code, samples
+	}
+
+	code, samples := query(`service_monitor_up{service="payment-service"}`)
+	if code != http.StatusOK || len(samples) != 1 {
+		t.Fatalf("expected one sample, got %d: %+v", code, samples)
+	}
+	if s := samples[0]; s.Name != "service_monitor_up" || s.Labels["service"] != "payment-service" || s.Value != 1 || s.Type != "gauge" {
+		t.Errorf("unexpected sample: %+v", s)
+	}
+
+	code, samples = query(`service_monitor_up{service=~"a.*|d.*"}`)
+	var services []string
+	for _, s := range samples {
+		services = append(services, s.Labels["service"])
+	}
+	sort.Strings(services)
+	if code != http.StatusOK || len(services) != 2 || services[0] != "api" || services[1] != "db" {
+		t.Errorf("expected api and db, got %d: %v", code, services)
+	}
+
+	// Regular expressions are anchored, so "pay" matches nothing
+	if code, samples = query(`service_monitor_up{service=~"pay"}`); code != http.StatusOK || len(samples) != 0 {
+		t.Errorf("expected no samples, got %d: %+v", code, samples)
+	}
+
+	// A label the series doesn't have matches the empty string
+	if code, samples = query(`service_monitor_up{env="",service!="api",service!="db"}`); code != http.StatusOK || len(samples) != 1 {
+		t.Errorf("expected only payment-service, got %d: %+v", code, samples)
+	}
+
+	if code, _ = query(`sum(service_monitor_up)`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an aggregation, got %d", code)
+	}
+	if code, _ = query(""); code != http.StatusBadRequest {
+		t.Errorf("expected 400 without expr, got %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query?expr=up", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("expected 405 with Allow: GET, got %d", rec.Code)
+	}
+}
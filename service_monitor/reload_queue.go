@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errReloadsStopped is returned for reloads requested after shutdown
+var errReloadsStopped = errors.New("config reloads have stopped")
+
+// reloadResult is the outcome of a queued reload
+type reloadResult struct {
+	config *Config
+	err    error
+}
+
+// reloadRequest is a config reload waiting for the reload worker
+type reloadRequest struct {
+	trigger string
+	reload  func() (*Config, error)
+
+	// Receives the result when the requester waits for it, otherwise nil
+	done chan reloadResult
+}
+
+// configReloadQueue runs the config reloads of every trigger one at a time
+// At most one reload waits behind the one in progress; a reload requested
+// without waiting while another is pending is merged into it, since both
+// load the config from the same source
+type configReloadQueue struct {
+	pending chan reloadRequest
+	depth   prometheus.Gauge
+
+	// Closed once run returns
+	stopped chan struct{}
+}
+
+func newConfigReloadQueue(depth prometheus.Gauge) *configReloadQueue {
+	return &configReloadQueue{
+		pending: make(chan reloadRequest, 1),
+		depth:   depth,
+		stopped: make(chan struct{}),
+	}
+}
+
+// request queues a reload without waiting for it, returning false if one
+// was already pending
+func (q *configReloadQueue) request(trigger string, reload func() (*Config, error)) bool {
+	queued := false
+	select {
+	case q.pending <- reloadRequest{trigger: trigger, reload: reload}:
+		queued = true
+	default:
+	}
+	q.depth.Set(float64(len(q.pending)))
+	return queued
+}
+
+// await queues a reload once the pending one has been picked up and returns
+// its result; it gives up when ctx is done or the queue has stopped
+func (q *configReloadQueue) await(ctx context.Context, trigger string, reload func() (*Config, error)) (*Config, error) {
+	req := reloadRequest{trigger: trigger, reload: reload, done: make(chan reloadResult, 1)}
+	select {
+	case q.pending <- req:
+		q.depth.Set(float64(len(q.pending)))
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-q.stopped:
+		return nil, errReloadsStopped
+	}
+
+	select {
+	case result := <-req.done:
+		return result.config, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-q.stopped:
+		return nil, errReloadsStopped
+	}
+}
+
+// run runs the queued reloads until ctx is cancelled
+func (q *configReloadQueue) run(ctx context.Context) {
+	defer close(q.stopped)
+	for {
+		var req reloadRequest
+		select {
+		case <-ctx.Done():
+			return
+		case req = <-q.pending:
+			q.depth.Set(float64(len(q.pending)))
+		}
+
+		config, err := req.reload()
+		if req.done != nil {
+			req.done <- reloadResult{config: config, err: err}
+		}
+	}
+}
+
+// awaitReload runs reload through the reload queue and returns its result,
+// or runs it directly when there is no queue
+func (c *ServerConfig) awaitReload(ctx context.Context, trigger string, reload func() (*Config, error)) (*Config, error) {
+	if c.Reloads == nil {
+		return reload()
+	}
+	return c.Reloads.await(ctx, trigger, reload)
+}
+
+// requestReload queues reload without waiting for it, or runs it directly
+// when there is no queue
+func (c *ServerConfig) requestReload(trigger string, reload func() (*Config, error)) {
+	if c.Reloads == nil {
+		reload()
+		return
+	}
+	if !c.Reloads.request(trigger, reload) {
+		log.Printf("Config reload already pending, merging the %s reload into it", trigger)
+	}
+}
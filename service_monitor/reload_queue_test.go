@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// runReloadQueue runs q until the test ends
+func runReloadQueue(t *testing.T, q *configReloadQueue) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	go q.run(ctx)
+	t.Cleanup(func() {
+		cancel()
+		<-q.stopped
+	})
+}
+
+func TestConfigReloadQueue_MergesPendingReloads(t *testing.T) {
+	m := newTestMetrics()
+	q := newConfigReloadQueue(m.ConfigReloadQueueDepth)
+
+	var reloads atomic.Int64
+	reload := func() (*Config, error) {
+		reloads.Add(1)
+		return nil, nil
+	}
+	if !q.request(loadTriggerSignal, reload) {
+		t.Fatal("expected the first reload to be queued")
+	}
+	if q.request(loadTriggerSignal, reload) {
+		t.Error("expected a second reload to be merged into the pending one")
+	}
+	if v := testutil.ToFloat64(m.ConfigReloadQueueDepth); v != 1 {
+		t.Errorf("expected a queue depth of 1, got %v", v)
+	}
+
+	runReloadQueue(t, q)
+	if !waitFor(t, 5*time.Second, func() bool { return reloads.Load() == 1 }) {
+		t.Fatal("expected the pending reload to run")
+	}
+	if v := testutil.ToFloat64(m.ConfigReloadQueueDepth); v != 0 {
+		t.Errorf("expected a queue depth of 0 after the reload, got %v", v)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := reloads.Load(); n != 1 {
+		t.Errorf("expected the merged requests to reload once, got %d reloads", n)
+	}
+}
+
+func TestConfigReloadQueue_AwaitWaitsBehindReloadInProgress(t *testing.T) {
+	m := newTestMetrics()
+	q := newConfigReloadQueue(m.ConfigReloadQueueDepth)
+	runReloadQueue(t, q)
+
+	// A watcher reload holds the worker
+	release := make(chan struct{})
+	started := make(chan struct{})
+	q.request(loadTriggerWatch, func() (*Config, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	want := &Config{UpServices: []string{"api-gateway"}}
+	result := make(chan *Config)
+	go func() {
+		config, err := q.await(context.Background(), loadTriggerManual, func() (*Config, error) { return want, nil })
+		if err != nil {
+			t.Errorf("await failed: %v", err)
+		}
+		result <- config
+	}()
+
+	if !waitFor(t, 5*time.Second, func() bool { return testutil.ToFloat64(m.ConfigReloadQueueDepth) == 1 }) {
+		t.Fatal("expected the manual reload to wait in the queue")
+	}
+	close(release)
+	select {
+	case config := <-result:
+		if config != want {
+			t.Errorf("expected the result of the manual reload, got %+v", config)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the manual reload to run after the watcher reload")
+	}
+
+	// Reloads requested after shutdown fail instead of hanging
+	stopped := newConfigReloadQueue(m.ConfigReloadQueueDepth)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stopped.run(ctx)
+	stopped.request(loadTriggerSignal, func() (*Config, error) { return nil, nil })
+	if _, err := stopped.await(context.Background(), loadTriggerManual, func() (*Config, error) { return nil, nil }); !errors.Is(err, errReloadsStopped) {
+		t.Errorf("expected errReloadsStopped, got %v", err)
+	}
+}
+
+func TestReloadHandler_UsesReloadQueue(t *testing.T) {
+	preserveConfigState(t)
+	m := newTestMetrics()
+	cfg := &ServerConfig{
+		ConfigPath: writeTestConfig(t, []string{"api-gateway"}, []string{"auth-service"}),
+		Reloads:    newConfigReloadQueue(m.ConfigReloadQueueDepth),
+	}
+	mux := NewAppServeMux(m, cfg)
+
+	// Without a running worker the request times out in the queue
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cfg.awaitReload(ctx, loadTriggerManual, func() (*Config, error) { return nil, nil }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the reload to wait for the worker, got %v", err)
+	}
+	<-cfg.Reloads.pending
+
+	runReloadQueue(t, cfg.Reloads)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusOK || loadCurrentConfig() == nil || len(loadCurrentConfig().DownServices) != 1 {
+		t.Errorf("expected the reload to be applied through the queue, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
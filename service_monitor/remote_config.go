@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRemoteConfigInterval is how often CONFIG_URL is fetched
+	defaultRemoteConfigInterval = 60 * time.Second
+
+	// remoteConfigTimeout bounds a single fetch of CONFIG_URL
+	remoteConfigTimeout = 10 * time.Second
+
+	// maxRemoteConfigBytes caps the size of a fetched config
+	maxRemoteConfigBytes = 10 << 20
+)
+
+// remoteConfigMinBackoff is the wait after the first failed fetch; it
+// doubles with every further failure up to the fetch interval; replaced in tests
+var remoteConfigMinBackoff = time.Second
+
+// RemoteConfigSource fetches the config from a central config server
+type RemoteConfigSource struct {
+	URL         string
+	BearerToken string
+	Interval    time.Duration
+
+	client *http.Client
+}
+
+// NewRemoteConfigSource creates a source fetching rawURL every interval
+func NewRemoteConfigSource(rawURL, bearerToken string, interval time.Duration) *RemoteConfigSource {
+	return &RemoteConfigSource{
+		URL:         rawURL,
+		BearerToken: bearerToken,
+		Interval:    interval,
+		client:      &http.Client{Timeout: remoteConfigTimeout},
+	}
+}
+
+// remoteConfigFormat detects the format of a fetched config from its
+// Content-Type, falling back to the extension of the URL path
+// Responses that declare neither are assumed to be TOML
+func remoteConfigFormat(contentType, rawURL string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch {
+		case strings.HasSuffix(mediaType, "toml"):
+			return "toml"
+		case strings.HasSuffix(mediaType, "json"):
+			return "json"
+		case strings.HasSuffix(mediaType, "yaml"):
+			return "yaml"
+		}
+	}
+
+	if u, err := url.Parse(rawURL); err == nil {
+		switch path.Ext(u.Path) {
+		case ".json":
+			return "json"
+		case ".yaml", ".yml":
+			return "yaml"
+		}
+	}
+	return "toml"
+}
+
+// fetch downloads and parses the config once
+func (s *RemoteConfigSource) fetch(ctx context.Context) (*Config, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.BearerToken)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+	if len(data) > maxRemoteConfigBytes {
+		return nil, fmt.Errorf("config is larger than %d bytes", maxRemoteConfigBytes)
+	}
+	return parseConfigAs(data, remoteConfigFormat(resp.Header.Get("Content-Type"), s.URL))
+}
+
+// fetchAndApply fetches the config and applies it if it differs from the
+// current one, returning the fetched config
+func (s *RemoteConfigSource) fetchAndApply(ctx context.Context, m *Metrics, cfg *ServerConfig, trigger string) (*Config, error) {
+	m.RemoteConfigFetches.Inc()
+	config, err := s.fetch(ctx)
+	if err != nil {
+		m.RemoteConfigFetchErrors.Inc()
+		return nil, err
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if computeConfigETag(config) == loadConfigETag() {
+		return config, nil
+	}
+	applyConfig(m, cfg, config, trigger)
+	log.Printf("Applied config from %s: %d up services and %d down services",
+		s.URL, len(config.UpServices), len(config.DownServices))
+	return config, nil
+}
+
+// run fetches the config every interval until ctx is cancelled
+// Failed fetches are retried with exponential backoff, keeping the last config
+func (s *RemoteConfigSource) run(ctx context.Context, m *Metrics, cfg *ServerConfig) {
+	log.Printf("Fetching config from %s every %s", s.URL, s.Interval)
+
+	backoff := time.Duration(0)
+	for {
+		wait := s.Interval
+		_, err := cfg.awaitReload(ctx, loadTriggerRemote, func() (*Config, error) {
+			return s.fetchAndApply(ctx, m, cfg, loadTriggerRemote)
+		})
+		if err != nil {
+			if backoff == 0 {
+				backoff = remoteConfigMinBackoff
+			} else {
+				backoff = min(2*backoff, s.Interval)
+			}
+			wait = backoff
+			log.Printf("Error fetching config from %s, retrying in %s: %v", s.URL, wait, err)
+		} else {
+			backoff = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRemoteConfigSource_LoadsTOML(t *testing.T) {
+	preserveConfigState(t)
+	origBackoff := remoteConfigMinBackoff
+	remoteConfigMinBackoff = time.Millisecond
+	t.Cleanup(func() { remoteConfigMinBackoff = origBackoff })
+
+	// The server fails twice before serving the config
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if requests.Add(1) <= 2 {
+			http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/toml")
+		w.Write([]byte("up_services = [\"api-gateway\"]\ndown_services = [\"auth-service\"]\n"))
+	}))
+	defer server.Close()
+
+	m := newTestMetrics()
+	source := NewRemoteConfigSource(server.URL+"/config", "s3cret", time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		source.run(ctx, m, &ServerConfig{})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	loaded := waitFor(t, 5*time.Second, func() bool {
+		config := loadCurrentConfig()
+		return config != nil && len(config.DownServices) == 1 && config.DownServices[0] == "auth-service"
+	})
+	if !loaded {
+		t.Fatal("expected the remote config to be applied")
+	}
+	if v := testutil.ToFloat64(m.ServiceStatus.WithLabelValues("auth-service")); v != 0 {
+		t.Errorf("expected auth-service to be down, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.RemoteConfigFetches); v != 3 {
+		t.Errorf("expected 3 fetches, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.RemoteConfigFetchErrors); v != 2 {
+		t.Errorf("expected 2 fetch errors, got %v", v)
+	}
+}
+
+func TestRemoteConfigSource_FetchErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/invalid.json":
+			w.Write([]byte(`{"up_services":["api"]`))
+		case "/invalid.toml":
+			w.Write([]byte("up_services = ["))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/invalid.json", "error parsing json config"},
+		{"/invalid.toml", "error parsing config file"},
+		{"/missing.toml", "unexpected status 404"},
+	}
+	for _, tt := range tests {
+		_, err := NewRemoteConfigSource(server.URL+tt.path, "", time.Minute).fetch(context.Background())
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.path, tt.want, err)
+		}
+	}
+}
+
+func TestRemoteConfigFormat(t *testing.T) {
+	tests := []struct {
+		contentType, url, want string
+	}{
+		{"application/toml", "http://config/services.json", "toml"},
+		{"application/json; charset=utf-8", "http://config/services", "json"},
+		{"application/yaml", "http://config/services", "yaml"},
+		{"text/plain", "http://config/services.yml", "yaml"},
+		{"", "http://config/services.json?node=1", "json"},
+		{"", "http://config/services.toml", "toml"},
+		{"application/octet-stream", "http://config/services", "toml"},
+	}
+	for _, tt := range tests {
+		if got := remoteConfigFormat(tt.contentType, tt.url); got != tt.want {
+			t.Errorf("remoteConfigFormat(%q, %q) = %q, want %q", tt.contentType, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestRemoteConfigSource_DetectsFormat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.json":
+			w.Write([]byte(`{"up_services":["api-gateway"],"down_services":["auth-service"]}`))
+		case "/config":
+			w.Header().Set("Content-Type", "application/yaml")
+			w.Write([]byte("up_services: [api-gateway]\ndown_services: [auth-service]\n"))
+		}
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/config.json", "/config"} {
+		config, err := NewRemoteConfigSource(server.URL+path, "", time.Minute).fetch(context.Background())
+		if err != nil {
+			t.Errorf("%s: fetch failed: %v", path, err)
+			continue
+		}
+		if len(config.UpServices) != 1 || len(config.DownServices) != 1 || config.DownServices[0] != "auth-service" {
+			t.Errorf("%s: unexpected config %+v", path, config)
+		}
+	}
+}
+
+func TestReload_RefetchesRemoteConfig(t *testing.T) {
+	preserveConfigState(t)
+	var body atomic.Value
+	body.Store("up_services = [\"api-gateway\"]\n")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer server.Close()
+
+	m := newTestMetrics()
+	source := NewRemoteConfigSource(server.URL+"/config.toml", "", time.Hour)
+	cfg := &ServerConfig{
+		ConfigPath:   writeTestConfig(t, []string{"default-service"}, nil),
+		ConfigSource: configSourceRemote,
+		RemoteConfig: source,
+	}
+	if _, err := source.fetchAndApply(context.Background(), m, cfg, loadTriggerRemote); err != nil {
+		t.Fatal(err)
+	}
+	mux := NewAppServeMux(m, cfg)
+
+	// The dry run fetches without applying
+	body.Store("up_services = [\"api-gateway\", \"auth-service\"]\n")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload?dry_run=true", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"would_change":true`) {
+		t.Errorf("expected a dry run reporting a change, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if config := loadCurrentConfig(); len(config.UpServices) != 1 {
+		t.Errorf("expected the dry run not to apply the config, got %+v", config)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if config := loadCurrentConfig(); len(config.UpServices) != 2 {
+		t.Errorf("expected the refetched config to be applied instead of the file, got %+v", config)
+	}
+	// Like a dry run of the file, the dry run fetch records no metrics
+	if v := testutil.ToFloat64(m.RemoteConfigFetches); v != 2 {
+		t.Errorf("expected 2 counted fetches, got %v", v)
+	}
+
+	// /config lists the fetched services rather than the file
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if !strings.Contains(rec.Body.String(), "- auth-service") || strings.Contains(rec.Body.String(), "default-service") {
+		t.Errorf("expected /config to list the fetched services, got:\n%s", rec.Body.String())
+	}
+}
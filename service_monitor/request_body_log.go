@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+const (
+	// maxLoggedBodyBytes is where logged request bodies are cut off
+	maxLoggedBodyBytes = 4 << 10
+
+	// redactedValue replaces the fields named by REDACT_BODY_FIELDS
+	redactedValue = "[REDACTED]"
+)
+
+// parseRedactPaths parses comma-separated paths such as $.up_services,
+// probes.*.url or down_services[0] into their elements
+func parseRedactPaths(value string) [][]string {
+	var paths [][]string
+	for _, expr := range strings.Split(value, ",") {
+		expr = strings.TrimSpace(expr)
+		expr = strings.TrimPrefix(strings.TrimPrefix(expr, "$"), ".")
+		expr = strings.ReplaceAll(strings.ReplaceAll(expr, "[", "."), "]", "")
+		if expr == "" {
+			continue
+		}
+		paths = append(paths, strings.Split(expr, "."))
+	}
+	return paths
+}
+
+// redactPath replaces the values at path in v, where * matches every key or
+// element and a number matches that element of an array
+func redactPath(v any, path []string) any {
+	if len(path) == 0 {
+		return redactedValue
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if path[0] == "*" || path[0] == key {
+				v[key] = redactPath(value, path[1:])
+			}
+		}
+	case []any:
+		for i, value := range v {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				v[i] = redactPath(value, path[1:])
+			}
+		}
+	}
+	return v
+}
+
+// redactBody returns body with the fields at paths redacted, decoding it as
+// JSON or TOML by content type; ok is false when the body can't be decoded
+func redactBody(contentType string, body []byte, paths [][]string) (redacted []byte, ok bool) {
+	if len(paths) == 0 {
+		return body, true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var doc map[string]any
+	var err error
+	if mediaType == "application/json" {
+		if err = json.Unmarshal(body, &doc); err == nil {
+			for _, path := range paths {
+				redactPath(doc, path)
+			}
+			redacted, err = json.Marshal(doc)
+		}
+	} else {
+		if err = toml.Unmarshal(body, &doc); err == nil {
+			for _, path := range paths {
+				redactPath(doc, path)
+			}
+			redacted, err = toml.Marshal(doc)
+		}
+	}
+	return redacted, err == nil
+}
+
+// requestBodyLogMiddleware logs the bodies of requests to next after it has
+// handled them, with the fields at redactPaths redacted and cut off at 4 KB
+// Bodies that can't be decoded for redaction are left out of the log
+func requestBodyLogMiddleware(enabled bool, redactPaths [][]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The handler reads the body as usual while a copy is kept for the log
+			var captured bytes.Buffer
+			body := r.Body
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(body, &captured), body}
+			next.ServeHTTP(w, r)
+
+			if captured.Len() == 0 {
+				return
+			}
+			logged, ok := redactBody(r.Header.Get("Content-Type"), captured.Bytes(), redactPaths)
+			if !ok {
+				log.Printf("Request body of %s %s (%d bytes): not logged, it can't be decoded for redaction",
+					r.Method, r.URL.Path, captured.Len())
+				return
+			}
+			suffix := ""
+			if len(logged) > maxLoggedBodyBytes {
+				logged, suffix = logged[:maxLoggedBodyBytes], "... (truncated)"
+			}
+			log.Printf("Request body of %s %s (%d bytes): %q%s", r.Method, r.URL.Path, captured.Len(), logged, suffix)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRedactPaths(t *testing.T) {
+	got := parseRedactPaths(" $.down_services, probes.*.url ,,up_services[0]")
+	want := [][]string{{"down_services"}, {"probes", "*", "url"}, {"up_services", "0"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got := parseRedactPaths(""); got != nil {
+		t.Errorf("expected no paths, got %v", got)
+	}
+}
+
+func TestRequestBodyLogMiddleware(t *testing.T) {
+	var logs syncBuffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	setTestServices(t, []string{"api"}, nil)
+
+	mux := NewAppServeMux(newTestMetrics(), &ServerConfig{
+		LogRequestBodies: true,
+		RedactBodyFields: parseRedactPaths("$.down_services,probes.*.url"),
+	})
+
+	body := "up_services = [\"api\"]\ndown_services = [\"secret-db\"]\n\n[probes]\napi = { url = \"http://internal-host/health\" }\n"
+	req := httptest.NewRequest(http.MethodPost, "/config/validate", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/toml")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	// The handler still reads the whole body
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"valid":true`) {
+		t.Fatalf("expected a valid config, got %d: %s", rec.Code, rec.Body.String())
+	}
+	out := logs.String()
+	if !strings.Contains(out, "Request body of POST /config/validate") || !strings.Contains(out, "api") {
+		t.Errorf("expected the body to be logged, got %q", out)
+	}
+	if strings.Contains(out, "secret-db") || strings.Contains(out, "internal-host") {
+		t.Errorf("expected redacted fields to be left out, got %q", out)
+	}
+	if strings.Count(out, redactedValue) != 2 {
+		t.Errorf("expected 2 redacted values, got %q", out)
+	}
+
+	// JSON bodies are redacted as JSON
+	req = httptest.NewRequest(http.MethodPost, "/config/diff", strings.NewReader(`{"up_services":["api"],"down_services":["secret-db"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 from /config/diff, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if out := logs.String(); !strings.Contains(out, "Request body of POST /config/diff") || strings.Contains(out, "secret-db") {
+		t.Errorf("expected the redacted JSON body to be logged, got %q", out)
+	}
+}
+
+func TestRequestBodyLogMiddleware_LimitsAndFallbacks(t *testing.T) {
+	var logs syncBuffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	var received int
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		received = len(data)
+	})
+	post := func(h http.Handler, contentType, body string) {
+		req := httptest.NewRequest(http.MethodPost, "/config/validate", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Long bodies are cut off at 4 KB
+	long := strings.Repeat("x", 2*maxLoggedBodyBytes)
+	post(requestBodyLogMiddleware(true, nil)(handler), "text/plain", long)
+	if received != len(long) {
+		t.Errorf("expected the handler to read %d bytes, got %d", len(long), received)
+	}
+	if out := logs.String(); !strings.Contains(out, "(truncated)") || strings.Count(out, "x") != maxLoggedBodyBytes {
+		t.Errorf("expected a truncated body, got %d bytes of log", len(out))
+	}
+
+	// Bodies that can't be redacted aren't logged
+	mark := len(logs.String())
+	post(requestBodyLogMiddleware(true, parseRedactPaths("down_services"))(handler), "application/json", `{"down_services": [`)
+	if out := logs.String()[mark:]; !strings.Contains(out, "not logged") || strings.Contains(out, "down_services") {
+		t.Errorf("expected the undecodable body to be left out, got %q", out)
+	}
+
+	// Disabled logging leaves the handler alone
+	mark = len(logs.String())
+	post(requestBodyLogMiddleware(false, nil)(handler), "text/plain", "body")
+	if out := logs.String()[mark:]; out != "" {
+		t.Errorf("expected no logs, got %q", out)
+	}
+}
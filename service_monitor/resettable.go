@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// resettable is a metric that can be replaced by a zero-value instance
+type resettable interface {
+	name() string
+	register(reg *prometheus.Registry)
+	reset(reg *prometheus.Registry) error
+}
+
+// resettableCounter forwards to a counter that can be swapped out on reset
+type resettableCounter struct {
+	opts    prometheus.CounterOpts
+	current atomic.Pointer[prometheus.Counter]
+}
+
+func newResettableCounter(opts prometheus.CounterOpts) *resettableCounter {
+	c := &resettableCounter{opts: opts}
+	inner := prometheus.NewCounter(opts)
+	c.current.Store(&inner)
+	return c
+}
+
+func (c *resettableCounter) load() prometheus.Counter { return *c.current.Load() }
+
+func (c *resettableCounter) Desc() *prometheus.Desc              { return c.load().Desc() }
+func (c *resettableCounter) Describe(ch chan<- *prometheus.Desc) { c.load().Describe(ch) }
+func (c *resettableCounter) Collect(ch chan<- prometheus.Metric) { c.load().Collect(ch) }
+func (c *resettableCounter) Inc()                                { c.load().Inc() }
+func (c *resettableCounter) Add(v float64)                       { c.load().Add(v) }
+func (c *resettableCounter) Write(out *dto.Metric) error         { return c.load().Write(out) }
+func (c *resettableCounter) name() string                        { return c.opts.Name }
+func (c *resettableCounter) register(reg *prometheus.Registry)   { reg.MustRegister(c.load()) }
+func (c *resettableCounter) reset(reg *prometheus.Registry) error {
+	fresh := prometheus.NewCounter(c.opts)
+	return swapRegistered(reg, c.load(), fresh, func() { c.current.Store(&fresh) })
+}
+
+// resettableGauge forwards to a gauge that can be swapped out on reset
+type resettableGauge struct {
+	opts    prometheus.GaugeOpts
+	current atomic.Pointer[prometheus.Gauge]
+}
+
+func newResettableGauge(opts prometheus.GaugeOpts) *resettableGauge {
+	g := &resettableGauge{opts: opts}
+	inner := prometheus.NewGauge(opts)
+	g.current.Store(&inner)
+	return g
+}
+
+func (g *resettableGauge) load() prometheus.Gauge { return *g.current.Load() }
+
+func (g *resettableGauge) Desc() *prometheus.Desc              { return g.load().Desc() }
+func (g *resettableGauge) Describe(ch chan<- *prometheus.Desc) { g.load().Describe(ch) }
+func (g *resettableGauge) Collect(ch chan<- prometheus.Metric) { g.load().Collect(ch) }
+func (g *resettableGauge) Set(v float64)                       { g.load().Set(v) }
+func (g *resettableGauge) Inc()                                { g.load().Inc() }
+func (g *resettableGauge) Dec()                                { g.load().Dec() }
+func (g *resettableGauge) Add(v float64)                       { g.load().Add(v) }
+func (g *resettableGauge) Sub(v float64)                       { g.load().Sub(v) }
+func (g *resettableGauge) SetToCurrentTime()                   { g.load().SetToCurrentTime() }
+func (g *resettableGauge) Write(out *dto.Metric) error         { return g.load().Write(out) }
+func (g *resettableGauge) name() string                        { return g.opts.Name }
+func (g *resettableGauge) register(reg *prometheus.Registry)   { reg.MustRegister(g.load()) }
+func (g *resettableGauge) reset(reg *prometheus.Registry) error {
+	fresh := prometheus.NewGauge(g.opts)
+	return swapRegistered(reg, g.load(), fresh, func() { g.current.Store(&fresh) })
+}
+
+// resettableCounterVec is a counter vector whose children are deleted on
+// reset; they are created again, starting at zero, on their next use
+type resettableCounterVec struct {
+	vec  *prometheus.CounterVec
+	opts prometheus.CounterOpts
+}
+
+func (v *resettableCounterVec) name() string                         { return v.opts.Name }
+func (v *resettableCounterVec) register(reg *prometheus.Registry)    { reg.MustRegister(v.vec) }
+func (v *resettableCounterVec) reset(reg *prometheus.Registry) error { v.vec.Reset(); return nil }
+
+// newCounter registers a counter that /metrics/reset replaces
+func (m *Metrics) newCounter(opts prometheus.CounterOpts) prometheus.Counter {
+	c := newResettableCounter(opts)
+	m.addResettable(c)
+	return c
+}
+
+// newGauge registers a gauge that /metrics/reset replaces
+func (m *Metrics) newGauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	g := newResettableGauge(opts)
+	m.addResettable(g)
+	return g
+}
+
+// newCounterVec registers a counter vector that /metrics/reset clears
+func (m *Metrics) newCounterVec(opts prometheus.CounterOpts, labelNames []string) *prometheus.CounterVec {
+	v := &resettableCounterVec{vec: prometheus.NewCounterVec(opts, labelNames), opts: opts}
+	m.addResettable(v)
+	return v.vec
+}
+
+// addResettable registers metric on the registry and includes it in resets
+func (m *Metrics) addResettable(metric resettable) {
+	metric.register(m.Registry)
+	m.resettable = append(m.resettable, metric)
+}
+
+// swapRegistered unregisters old, registers fresh in its place and then
+// publishes fresh to instrumentation code via store
+func swapRegistered(reg *prometheus.Registry, old, fresh prometheus.Collector, store func()) error {
+	if !reg.Unregister(old) {
+		return fmt.Errorf("metric was not registered")
+	}
+	if err := reg.Register(fresh); err != nil {
+		// Put the old collector back so the metric doesn't disappear
+		reg.MustRegister(old)
+		return err
+	}
+	store()
+	return nil
+}
+
+// ResetMetrics replaces every resettable counter and gauge with a zero-value
+// instance and returns the names of the reset metrics
+func (m *Metrics) ResetMetrics() ([]string, error) {
+	m.resetMu.Lock()
+	defer m.resetMu.Unlock()
+
+	names := make([]string, 0, len(m.resettable))
+	for _, metric := range m.resettable {
+		if err := metric.reset(m.Registry); err != nil {
+			return names, fmt.Errorf("error resetting %s: %w", metric.name(), err)
+		}
+		names = append(names, metric.name())
+	}
+	return names, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// RingBuffer keeps the most recent entries up to a fixed capacity, evicting
+// the oldest one on overflow
+// Entries must be pushed in time order for Filter to work; it is not safe for
+// concurrent use
+type RingBuffer[T any] struct {
+	entries []T
+	next    int
+	count   int
+	timeOf  func(T) time.Time
+}
+
+// NewRingBuffer creates a buffer holding up to capacity entries whose
+// timestamps are read with timeOf
+func NewRingBuffer[T any](capacity int, timeOf func(T) time.Time) *RingBuffer[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &RingBuffer[T]{entries: make([]T, capacity), timeOf: timeOf}
+}
+
+// Len returns the number of entries in the buffer
+func (b *RingBuffer[T]) Len() int {
+	return b.count
+}
+
+// Push appends v, evicting the oldest entry once the buffer is full
+func (b *RingBuffer[T]) Push(v T) {
+	b.entries[b.next] = v
+	b.next = (b.next + 1) % len(b.entries)
+	if b.count < len(b.entries) {
+		b.count++
+	}
+}
+
+// at returns the i-th oldest entry
+func (b *RingBuffer[T]) at(i int) T {
+	start := (b.next - b.count + len(b.entries)) % len(b.entries)
+	return b.entries[(start+i)%len(b.entries)]
+}
+
+// Slice returns the n most recent entries, oldest first; n <= 0 returns all
+func (b *RingBuffer[T]) Slice(n int) []T {
+	if n <= 0 || n > b.count {
+		n = b.count
+	}
+	out := make([]T, n)
+	for i := range out {
+		out[i] = b.at(b.count - n + i)
+	}
+	return out
+}
+
+// Filter returns the entries at or after since, oldest first
+func (b *RingBuffer[T]) Filter(since time.Time) []T {
+	first := sort.Search(b.count, func(i int) bool {
+		return !b.timeOf(b.at(i)).Before(since)
+	})
+	out := make([]T, 0, b.count-first)
+	for i := first; i < b.count; i++ {
+		out = append(out, b.at(i))
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testEntry is a ring buffer entry stamped with its position in a sequence
+type testEntry struct {
+	n  int
+	ts time.Time
+}
+
+var ringBufferEpoch = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestRing pushes entries 0..pushed-1, one second apart, into a ring of capacity
+func newTestRing(capacity, pushed int) *RingBuffer[testEntry] {
+	b := NewRingBuffer(capacity, func(e testEntry) time.Time { return e.ts })
+	for i := 0; i < pushed; i++ {
+		b.Push(testEntry{n: i, ts: ringBufferEpoch.Add(time.Duration(i) * time.Second)})
+	}
+	return b
+}
+
+func entryNumbers(entries []testEntry) string {
+	nums := make([]int, len(entries))
+	for i, e := range entries {
+		nums[i] = e.n
+	}
+	return fmt.Sprint(nums)
+}
+
+func TestRingBuffer_Wraparound(t *testing.T) {
+	tests := []struct {
+		pushed int
+		n      int
+		want   string
+	}{
+		{0, 0, "[]"},
+		{3, 0, "[0 1 2]"},
+		{3, 2, "[1 2]"},
+		{5, 0, "[0 1 2 3 4]"},
+		{6, 0, "[1 2 3 4 5]"},
+		{12, 0, "[7 8 9 10 11]"},
+		{12, 3, "[9 10 11]"},
+		{12, 10, "[7 8 9 10 11]"},
+	}
+
+	for _, tt := range tests {
+		b := newTestRing(5, tt.pushed)
+		if got := entryNumbers(b.Slice(tt.n)); got != tt.want {
+			t.Errorf("%d pushed, Slice(%d) = %s, want %s", tt.pushed, tt.n, got, tt.want)
+		}
+		if want := min(tt.pushed, 5); b.Len() != want {
+			t.Errorf("%d pushed: expected length %d, got %d", tt.pushed, want, b.Len())
+		}
+	}
+}
+
+func TestRingBuffer_Filter(t *testing.T) {
+	at := func(i int) time.Time { return ringBufferEpoch.Add(time.Duration(i) * time.Second) }
+
+	// 8 entries in a ring of 5 leave 3..7, with the oldest in the middle of the slice
+	b := newTestRing(5, 8)
+	tests := []struct {
+		since time.Time
+		want  string
+	}{
+		{time.Time{}, "[3 4 5 6 7]"},
+		{at(3), "[3 4 5 6 7]"},
+		{at(4), "[4 5 6 7]"},
+		{at(5).Add(-time.Millisecond), "[5 6 7]"},
+		{at(6), "[6 7]"},
+		{at(7), "[7]"},
+		{at(8), "[]"},
+	}
+	for _, tt := range tests {
+		if got := entryNumbers(b.Filter(tt.since)); got != tt.want {
+			t.Errorf("Filter(%v) = %s, want %s", tt.since, got, tt.want)
+		}
+	}
+
+	if got := entryNumbers(newTestRing(5, 0).Filter(time.Time{})); got != "[]" {
+		t.Errorf("expected an empty buffer to filter to nothing, got %s", got)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Runtime metrics read by RuntimeMetricsCollector
+const (
+	mutexWaitMetric    = "/sync/mutex/wait/total:seconds"
+	schedLatencyMetric = "/sched/latencies:seconds"
+)
+
+// defaultRuntimeMetricsInterval is how often the runtime is re-read by default
+const defaultRuntimeMetricsInterval = 30 * time.Second
+
+// schedLatencyBuckets spans goroutine scheduling latencies from 1µs to 1s
+var schedLatencyBuckets = prometheus.ExponentialBucketsRange(1e-6, 1, 13)
+
+// RuntimeMetricsCollector exposes lock contention and scheduling latency from
+// runtime/metrics, re-reading the runtime at most once per Interval
+type RuntimeMetricsCollector struct {
+	mutexWaitDesc    *prometheus.Desc
+	schedLatencyDesc *prometheus.Desc
+
+	// Interval must be set before the collector is first used
+	Interval time.Duration
+
+	// now returns the current time; replaced in tests
+	now func() time.Time
+
+	mu        sync.Mutex
+	samples   []metrics.Sample
+	readAt    time.Time
+	mutexWait float64
+	count     uint64
+	sum       float64
+	buckets   map[float64]uint64
+}
+
+// NewRuntimeMetricsCollector creates a collector that reads the runtime every interval
+func NewRuntimeMetricsCollector(interval time.Duration) *RuntimeMetricsCollector {
+	return &RuntimeMetricsCollector{
+		mutexWaitDesc: prometheus.NewDesc(
+			"service_monitor_mutex_wait_seconds_total",
+			"Approximate cumulative time goroutines have spent blocked on a sync.Mutex or sync.RWMutex",
+			nil, nil,
+		),
+		schedLatencyDesc: prometheus.NewDesc(
+			"service_monitor_sched_latency_seconds",
+			"Distribution of the time goroutines spent runnable before running",
+			nil, nil,
+		),
+		Interval: interval,
+		now:      time.Now,
+		samples: []metrics.Sample{
+			{Name: mutexWaitMetric},
+			{Name: schedLatencyMetric},
+		},
+	}
+}
+
+func (c *RuntimeMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.mutexWaitDesc
+	ch <- c.schedLatencyDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *RuntimeMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now := c.now(); c.buckets == nil || now.Sub(c.readAt) >= c.Interval {
+		c.read()
+		c.readAt = now
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.mutexWaitDesc, prometheus.CounterValue, c.mutexWait)
+	ch <- prometheus.MustNewConstHistogram(c.schedLatencyDesc, c.count, c.sum, c.buckets)
+}
+
+// read samples the runtime and converts the results; c.mu must be held
+func (c *RuntimeMetricsCollector) read() {
+	metrics.Read(c.samples)
+
+	if c.samples[0].Value.Kind() == metrics.KindFloat64 {
+		c.mutexWait = c.samples[0].Value.Float64()
+	}
+
+	c.count, c.sum = 0, 0
+	c.buckets = make(map[float64]uint64, len(schedLatencyBuckets))
+	for _, bound := range schedLatencyBuckets {
+		c.buckets[bound] = 0
+	}
+	if c.samples[1].Value.Kind() != metrics.KindFloat64Histogram {
+		return
+	}
+
+	// Fold the fine-grained runtime buckets into the exported ones; runtime
+	// bucket i covers [Buckets[i], Buckets[i+1])
+	hist := c.samples[1].Value.Float64Histogram()
+	for i, n := range hist.Counts {
+		if n == 0 {
+			continue
+		}
+		lower, upper := hist.Buckets[i], hist.Buckets[i+1]
+		c.count += n
+		c.sum += float64(n) * bucketMidpoint(lower, upper)
+		for _, bound := range schedLatencyBuckets {
+			if upper <= bound {
+				c.buckets[bound] += n
+			}
+		}
+	}
+}
+
+// bucketMidpoint estimates the value of observations in [lower, upper),
+// falling back to the finite edge for unbounded buckets
+func bucketMidpoint(lower, upper float64) float64 {
+	switch {
+	case math.IsInf(lower, -1):
+		return upper
+	case math.IsInf(upper, 1):
+		return lower
+	default:
+		return (lower + upper) / 2
+	}
+}
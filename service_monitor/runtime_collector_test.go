@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRuntimeMetricsCollector_Collect(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewRuntimeMetricsCollector(defaultRuntimeMetricsInterval))
+
+	// Produce some contention and scheduling activity
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				mu.Lock()
+				time.Sleep(time.Microsecond)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	for _, name := range []string{"service_monitor_mutex_wait_seconds_total", "service_monitor_sched_latency_seconds"} {
+		if !names[name] {
+			t.Errorf("expected metric family %s, got %v", name, names)
+		}
+	}
+
+	for _, mf := range mfs {
+		if mf.GetName() != "service_monitor_sched_latency_seconds" {
+			continue
+		}
+		h := mf.GetMetric()[0].GetHistogram()
+		if len(h.GetBucket()) != len(schedLatencyBuckets) {
+			t.Errorf("expected %d buckets, got %d", len(schedLatencyBuckets), len(h.GetBucket()))
+		}
+		if h.GetSampleCount() == 0 {
+			t.Error("expected scheduling latency observations")
+		}
+	}
+}
+
+func TestRuntimeMetricsCollector_Interval(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := NewRuntimeMetricsCollector(30 * time.Second)
+	c.now = clock.Now
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	count := func() uint64 {
+		t.Helper()
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("gather failed: %v", err)
+		}
+		for _, mf := range mfs {
+			if mf.GetName() == "service_monitor_sched_latency_seconds" {
+				return mf.GetMetric()[0].GetHistogram().GetSampleCount()
+			}
+		}
+		t.Fatal("scheduling latency histogram missing")
+		return 0
+	}
+
+	first := count()
+	c.mu.Lock()
+	c.count = 0
+	c.mu.Unlock()
+
+	// Within the interval the previous read is served
+	clock.Advance(10 * time.Second)
+	if got := count(); got != 0 {
+		t.Errorf("expected cached value before the interval elapsed, got %d", got)
+	}
+
+	clock.Advance(20 * time.Second)
+	if got := count(); got < first {
+		t.Errorf("expected a fresh read after the interval, got %d (first %d)", got, first)
+	}
+}
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/time/rate"
+)
+
+// ServerConfig holds the settings the HTTP handlers need
+type ServerConfig struct {
+	// Path of the TOML file with service status
+	ConfigPath string
+
+	// File system the config is read from; nil uses the real one
+	FS FileSystem
+
+	// Where the service list comes from, one of the configSource constants;
+	// empty means the config file
+	ConfigSource string
+
+	// Config server reloads fetch from when ConfigSource is remote
+	RemoteConfig *RemoteConfigSource
+
+	// Runs the config reloads of every trigger one at a time; reloads run
+	// directly when nil
+	Reloads *configReloadQueue
+
+	// Addresses of the application and metrics listeners, reported by /info
+	AppAddr     string
+	MetricsAddr string
+
+	// Expose POST /metrics/reset; must never be enabled in production
+	EnableMetricsReset bool
+
+	// Serve /mock/{service}/up and /mock/{service}/down for integration tests
+	MockServer bool
+
+	// Directory /metrics/snapshot writes into, disabled when empty
+	SnapshotBaseDir string
+
+	// Number of snapshots kept for /metrics/diff; zero uses defaultMaxDiffSnapshots
+	MaxDiffSnapshots int
+
+	// Limit on request bodies; zero uses defaultMaxRequestBodyBytes
+	MaxRequestBodyBytes int64
+
+	// Log the bodies posted to the config endpoints, with the fields at
+	// RedactBodyFields redacted
+	LogRequestBodies bool
+	RedactBodyFields [][]string
+
+	// Credentials for the /admin endpoints, which are disabled when unset
+	AdminUsername string
+	AdminPassword string
+
+	// File the service state is exported to as JSON, disabled when empty
+	StateExportPath string
+
+	// Minimum time between state exports; zero exports on every change
+	StateExportInterval time.Duration
+
+	// Rolling availability windows behind /status, if probing is running
+	SLOTracker *SLOTracker
+
+	// Log every status change is appended to, disabled when nil
+	EventLog *eventLog
+
+	// Recently applied configs behind /config/history, disabled when nil
+	ConfigHistory *ConfigHistory
+
+	// Forwarder of service status to StatsD, disabled when nil
+	StatsD *StatsDBridge
+
+	// Holds back /metrics until the initial config is applied, disabled when nil
+	StartupGate *startupGate
+
+	// How long /health reuses [health_checks] results; zero uses defaultHealthCheckCacheTTL
+	HealthCheckCacheTTL time.Duration
+
+	// Default smoothing factor of the simulated load when the config sets none
+	EMAAlpha float64
+
+	limiter     *requestLimiter
+	limiterOnce sync.Once
+
+	smoothedLoad     *movingAverage
+	smoothedLoadOnce sync.Once
+
+	goroutines     *goroutineMonitor
+	goroutinesOnce sync.Once
+
+	healthChecks     *HealthCheckCache
+	healthChecksOnce sync.Once
+
+	exporter     *stateExporter
+	exporterOnce sync.Once
+
+	snapshots     *MetricsSnapshotStore
+	snapshotsOnce sync.Once
+
+	// Headers from the [http_headers] config section
+	responseHeaders atomic.Pointer[http.Header]
+}
+
+// fileSystem returns FS, falling back to the real file system
+func (c *ServerConfig) fileSystem() FileSystem {
+	if c.FS == nil {
+		return RealFileSystem{}
+	}
+	return c.FS
+}
+
+// requestLimiter returns the semaphore bounding concurrent root requests
+func (c *ServerConfig) requestLimiter() *requestLimiter {
+	c.limiterOnce.Do(func() {
+		c.limiter = newRequestLimiter(defaultMaxConcurrentRequests)
+	})
+	return c.limiter
+}
+
+// loadAverage returns the moving average smoothing the simulated load
+func (c *ServerConfig) loadAverage() *movingAverage {
+	c.smoothedLoadOnce.Do(func() {
+		c.smoothedLoad = newMovingAverage(c.defaultEMAAlpha())
+	})
+	return c.smoothedLoad
+}
+
+// goroutineMonitor returns the goroutine leak detector behind /healthz
+func (c *ServerConfig) goroutineMonitor() *goroutineMonitor {
+	c.goroutinesOnce.Do(func() {
+		c.goroutines = &goroutineMonitor{}
+	})
+	return c.goroutines
+}
+
+// healthCheckCache returns the cache of [health_checks] results used by /health
+func (c *ServerConfig) healthCheckCache() *HealthCheckCache {
+	c.healthChecksOnce.Do(func() {
+		ttl := c.HealthCheckCacheTTL
+		if ttl == 0 {
+			ttl = defaultHealthCheckCacheTTL
+		}
+		c.healthChecks = NewHealthCheckCache(ttl)
+	})
+	return c.healthChecks
+}
+
+// stateExporter returns the exporter of the service state file, or nil when disabled
+func (c *ServerConfig) stateExporter() *stateExporter {
+	c.exporterOnce.Do(func() {
+		if c.StateExportPath != "" {
+			c.exporter = newStateExporter(c.StateExportPath, c.StateExportInterval)
+		}
+	})
+	return c.exporter
+}
+
+// fromConfigFile reports whether the services come from the config file
+func (c *ServerConfig) fromConfigFile() bool {
+	return c.ConfigSource == "" || c.ConfigSource == configSourceFile
+}
+
+// serviceSource returns where the services come from, one of the
+// configSource constants
+func (c *ServerConfig) serviceSource() string {
+	if c.fromConfigFile() {
+		return configSourceFile
+	}
+	return c.ConfigSource
+}
+
+// metricsSnapshots returns the snapshots kept for /metrics/diff
+func (c *ServerConfig) metricsSnapshots() *MetricsSnapshotStore {
+	c.snapshotsOnce.Do(func() {
+		size := c.MaxDiffSnapshots
+		if size <= 0 {
+			size = defaultMaxDiffSnapshots
+		}
+		c.snapshots = NewMetricsSnapshotStore(size)
+	})
+	return c.snapshots
+}
+
+// setResponseHeaders replaces the headers added to every response
+func (c *ServerConfig) setResponseHeaders(headers map[string]string) {
+	h := make(http.Header, len(headers))
+	for name, value := range headers {
+		h.Set(name, value)
+	}
+	c.responseHeaders.Store(&h)
+}
+
+// defaultEMAAlpha returns EMAAlpha, falling back to the built-in default
+func (c *ServerConfig) defaultEMAAlpha() float64 {
+	if c.EMAAlpha <= 0 || c.EMAAlpha > 1 {
+		return defaultEMAAlpha
+	}
+	return c.EMAAlpha
+}
+
+// sleep is used to simulate processing time; replaced in tests
+var sleep = time.Sleep
+
+// shutdownTimeout bounds how long in-flight requests may take on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// wrapHandler applies the middleware shared by the app and metrics servers
+func wrapHandler(cfg *ServerConfig, h http.Handler) http.Handler {
+	maxBytes := cfg.MaxRequestBodyBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxRequestBodyBytes
+	}
+	return securityHeadersMiddleware(cfg, maxBodyMiddleware(maxBytes)(h))
+}
+
+// NewServeMux registers all service monitor routes on a fresh ServeMux
+// It serves application and metrics traffic from a single port
+func NewServeMux(metrics *Metrics, cfg *ServerConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	registerAppRoutes(mux, metrics, cfg)
+	registerMetricsRoutes(mux, metrics, cfg)
+	return mux
+}
+
+// NewAppServeMux registers only the public application routes
+func NewAppServeMux(metrics *Metrics, cfg *ServerConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	registerAppRoutes(mux, metrics, cfg)
+	return mux
+}
+
+// NewMetricsServeMux registers only the metrics and debugging routes, which
+// are meant for a port that is firewalled from external traffic
+func NewMetricsServeMux(metrics *Metrics, cfg *ServerConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	registerMetricsRoutes(mux, metrics, cfg)
+	return mux
+}
+
+func registerAppRoutes(mux *http.ServeMux, metrics *Metrics, cfg *ServerConfig) {
+	mux.Handle("/", headMiddleware(rootHandler(metrics, cfg.requestLimiter())))
+	mux.Handle("/health", headMiddleware(healthHandler(metrics, cfg)))
+	mux.Handle("/healthz", headMiddleware(livenessHandler(cfg.goroutineMonitor())))
+	mux.Handle("/readyz", headMiddleware(readyHandler(metrics)))
+	mux.Handle("/status", headMiddleware(statusHandler(cfg)))
+	mux.Handle("/info", headMiddleware(infoHandler(cfg)))
+	mux.Handle("/capabilities", headMiddleware(capabilitiesHandler(cfg)))
+	mux.Handle("/history/", headMiddleware(historyHandler(cfg)))
+	mux.Handle("/services/", headMiddleware(probeHistoryHandler(cfg)))
+	mux.HandleFunc("/config", configHandler(metrics, cfg))
+	bodyLog := requestBodyLogMiddleware(cfg.LogRequestBodies, cfg.RedactBodyFields)
+	mux.Handle("/config/diff", bodyLog(http.HandlerFunc(configDiffHandler)))
+	mux.Handle("/config/schema", headMiddleware(configSchemaHandler()))
+	mux.Handle("/config/validate", bodyLog(configValidateHandler(rate.NewLimiter(configValidateRate, configValidateRate))))
+	mux.Handle("/config/history", headMiddleware(configHistoryHandler(cfg)))
+	mux.HandleFunc("/reload", reloadHandler(metrics, cfg))
+
+	if cfg.MockServer {
+		mux.Handle("/mock/", headMiddleware(mockServiceHandler()))
+	}
+
+	if cfg.AdminUsername != "" && cfg.AdminPassword != "" {
+		mux.Handle("/admin/services", basicAuthMiddleware(cfg.AdminUsername, cfg.AdminPassword,
+			adminServicesHandler(cfg)))
+		mux.Handle("/probe", basicAuthMiddleware(cfg.AdminUsername, cfg.AdminPassword,
+			probeHandler(metrics)))
+	}
+}
+
+func registerMetricsRoutes(mux *http.ServeMux, metrics *Metrics, cfg *ServerConfig) {
+	// Metrics endpoint for Prometheus
+	mux.Handle("/metrics", headMiddleware(startupGateMiddleware(cfg.StartupGate)(
+		promhttp.HandlerFor(metrics.Exposition, promhttp.HandlerOpts{EnableOpenMetrics: true}))))
+
+	mux.Handle("/metrics/export", headMiddleware(metricsExportHandler(metrics)))
+	mux.Handle("/query", headMiddleware(queryHandler(metrics)))
+
+	registerPprofHandlers(mux)
+
+	if cfg.EnableMetricsReset {
+		mux.HandleFunc("/metrics/reset", metricsResetHandler(metrics))
+	}
+
+	if cfg.SnapshotBaseDir != "" {
+		mux.HandleFunc("/metrics/snapshot", metricsSnapshotHandler(metrics, cfg.SnapshotBaseDir, cfg.metricsSnapshots()))
+		mux.Handle("/metrics/diff", headMiddleware(metricsDiffHandler(metrics, cfg.metricsSnapshots())))
+	}
+}
+
+// runServers serves until ctx is cancelled or a server fails, then shuts
+// all servers down gracefully
+func runServers(ctx context.Context, servers ...*http.Server) error {
+	errCh := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			log.Printf("Listening on %s", srv.Addr)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errCh <- fmt.Errorf("server on %s failed: %w", srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	var serveErr error
+	select {
+	case serveErr = <-errCh:
+	case <-ctx.Done():
+		log.Println("Shutting down servers...")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down server on %s: %v", srv.Addr, err)
+		}
+	}
+
+	return serveErr
+}
+
+// rootHandler simulates request processing with random latency and errors
+// Requests beyond the limiter's capacity are rejected with 503
+func rootHandler(m *Metrics, limiter *requestLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		release, ok := limiter.tryAcquire()
+		if !ok {
+			m.RejectedRequests.Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many concurrent requests", http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+
+		m.ActiveRequests.Inc()
+		defer m.ActiveRequests.Dec()
+
+		start := time.Now()
+		defer func() {
+			duration := time.Since(start).Seconds()
+			m.RequestDuration.Observe(duration)
+			m.RequestsProcessed.Inc()
+		}()
+
+		// Simulate some processing time
+		processingTime := rand.Float64() * 0.5
+		sleep(time.Duration(processingTime * float64(time.Second)))
+
+		// Randomly generate errors (10% of the time)
+		if rand.Float64() < 0.1 {
+			m.ErrorRate.Set(0.1)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Internal Server Error"))
+			return
+		}
+
+		m.ErrorRate.Set(0.0)
+		w.Write([]byte("Service Monitor is running!"))
+	}
+}
+
+// livenessHandler fails while the goroutine count suggests a leak, so the
+// process is restarted before it exhausts system resources
+func livenessHandler(g *goroutineMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if leaking, count, baseline := g.status(); leaking {
+			http.Error(w, fmt.Sprintf("Goroutine leak suspected: %d goroutines, baseline %d", count, baseline),
+				http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("OK"))
+	}
+}
+
+// readyHandler reports whether the config has loaded and the metrics registry
+// can be gathered, so traffic is only routed to instances that can be scraped
+func readyHandler(m *Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if loadCurrentConfig() == nil {
+			http.Error(w, "Config not loaded", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Gather from the registry directly so cached results can't hide a failure
+		mfs, err := m.Registry.Gather()
+		if err != nil {
+			log.Printf("Readiness check failed to gather metrics: %v", err)
+			http.Error(w, fmt.Sprintf("Metrics registry unhealthy: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		found := false
+		for _, mf := range mfs {
+			if mf.GetName() == "service_monitor_up" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			http.Error(w, "Metric service_monitor_up missing", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("OK"))
+	}
+}
+
+// configHandler lists the services from the current config file, or from the
+// applied config when it comes from another source
+// The ETag is computed from the config served, so clients revalidating get 304
+// until its services change, even when the file is edited without a reload
+func configHandler(m *Metrics, cfg *ServerConfig) http.HandlerFunc {
+	// lastModTime is written by reloads while they hold configMutex
+	appliedLastMod := func() (string, time.Time) {
+		lockStart := time.Now()
+		configMutex.RLock()
+		m.ConfigHandlerLockWait.Observe(time.Since(lockStart).Seconds())
+		defer configMutex.RUnlock()
+		return loadConfigETag(), lastModTime
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		defer func() { m.ConfigHandlerDuration.Observe(time.Since(start).Seconds()) }()
+
+		var config *Config
+		var etag string
+		var lastMod time.Time
+		if cfg.fromConfigFile() {
+			appliedETag, appliedMod := appliedLastMod()
+			var err error
+			config, err = timedLoadConfig(m, cfg.fileSystem(), cfg.ConfigPath, loadTriggerManual)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "Error loading config: %v", err)
+				return
+			}
+			// lastModTime only dates the file as it was when it was applied
+			if etag = computeConfigETag(config); etag == appliedETag {
+				lastMod = appliedMod
+			}
+		} else {
+			if config = loadCurrentConfig(); config == nil {
+				http.Error(w, "No config applied yet", http.StatusServiceUnavailable)
+				return
+			}
+			etag = computeConfigETag(config)
+		}
+
+		render := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, renderConfigText(config))
+		})
+		countNotModified(m.ConfigCacheHits)(etagMiddleware(
+			func() string { return etag },
+			func() time.Time { return lastMod },
+		)(render)).ServeHTTP(w, r)
+	}
+}
+
+// renderConfigText renders the service lists shown by /config
+func renderConfigText(config *Config) string {
+	size := 40
+	for _, svc := range config.UpServices {
+		size += len(svc) + 3
+	}
+	for _, svc := range config.DownServices {
+		size += len(svc) + 3
+	}
+
+	var b strings.Builder
+	b.Grow(size)
+	writeServiceList(&b, "UP SERVICES (", config.UpServices)
+	b.WriteByte('\n')
+	writeServiceList(&b, "DOWN SERVICES (", config.DownServices)
+	return b.String()
+}
+
+// writeServiceList writes a heading with the service count followed by one line per service
+func writeServiceList(b *strings.Builder, heading string, services []string) {
+	b.WriteString(heading)
+	b.WriteString(strconv.Itoa(len(services)))
+	b.WriteString("):\n")
+	for _, svc := range services {
+		b.WriteString("- ")
+		b.WriteString(svc)
+		b.WriteByte('\n')
+	}
+}
+
+// reloadResponse is the JSON body returned by the /reload endpoint
+type reloadResponse struct {
+	Status       string `json:"status"`
+	UpServices   int    `json:"up_services"`
+	DownServices int    `json:"down_services"`
+	Error        string `json:"error,omitempty"`
+
+	// Only set by dry runs
+	DryRun      bool  `json:"dry_run,omitempty"`
+	WouldChange *bool `json:"would_change,omitempty"`
+}
+
+// reloadHandler reloads the config file immediately instead of waiting for the
+// watcher, or fetches it again from CONFIG_URL
+// With ?dry_run=true the config is only loaded and validated
+// Configs from the other sources are answered with 409
+func reloadHandler(m *Metrics, cfg *ServerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if value := r.URL.Query().Get("dry_run"); value != "" {
+			dryRun, err := strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "dry_run must be a boolean", http.StatusBadRequest)
+				return
+			}
+			if dryRun {
+				dryRunReload(w, r, cfg)
+				return
+			}
+		}
+
+		config, err := cfg.awaitReload(r.Context(), loadTriggerManual, func() (*Config, error) {
+			return reloadFromSource(r.Context(), m, cfg, loadTriggerManual)
+		})
+		if err != nil {
+			status := http.StatusInternalServerError
+			var notReloadable *notReloadableError
+			if errors.As(err, &notReloadable) {
+				status = http.StatusConflict
+			}
+			log.Printf("Error reloading config: %v", err)
+			writeJSON(w, status, reloadResponse{Status: "error", Error: err.Error()})
+			return
+		}
+
+		log.Printf("Reloaded config on request: %d up services and %d down services",
+			len(config.UpServices), len(config.DownServices))
+
+		writeJSON(w, http.StatusOK, reloadResponse{
+			Status:       "reloaded",
+			UpServices:   len(config.UpServices),
+			DownServices: len(config.DownServices),
+		})
+	}
+}
+
+// dryRunReload loads and validates the config file, or fetches the config
+// from CONFIG_URL, without applying it,
+// recording no metrics, and reports whether applying it would change any service
+// A config that can't be parsed or applied is answered with 422
+func dryRunReload(w http.ResponseWriter, r *http.Request, cfg *ServerConfig) {
+	var config *Config
+	var err error
+	switch {
+	case cfg.fromConfigFile():
+		config, err = loadConfig(cfg.fileSystem(), cfg.ConfigPath, nil)
+	case cfg.ConfigSource == configSourceRemote && cfg.RemoteConfig != nil:
+		config, err = cfg.RemoteConfig.fetch(r.Context())
+	default:
+		err := &notReloadableError{source: cfg.ConfigSource}
+		writeJSON(w, http.StatusConflict, reloadResponse{Status: "error", Error: err.Error(), DryRun: true})
+		return
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		var invalid *invalidConfigError
+		if errors.As(err, &invalid) {
+			status = http.StatusUnprocessableEntity
+		}
+		writeJSON(w, status, reloadResponse{Status: "error", Error: err.Error(), DryRun: true})
+		return
+	}
+
+	wouldChange := diffConfig(loadCurrentConfig(), config).changed()
+	writeJSON(w, http.StatusOK, reloadResponse{
+		Status:       "valid",
+		UpServices:   len(config.UpServices),
+		DownServices: len(config.DownServices),
+		DryRun:       true,
+		WouldChange:  &wouldChange,
+	})
+}
+
+// metricsResetHandler zeroes counters and gauges between load test runs
+func metricsResetHandler(m *Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		names, err := m.ResetMetrics()
+		if err != nil {
+			log.Printf("Error resetting metrics: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"reset": names, "error": err.Error()})
+			return
+		}
+
+		log.Printf("Reset metrics on request: %v", names)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"reset": names})
+	}
+}
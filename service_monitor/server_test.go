@@ -0,0 +1,917 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newTestMetrics creates metrics on a fresh registry so tests don't share state
+func newTestMetrics() *Metrics {
+	return NewMetrics(prometheus.NewRegistry())
+}
+
+// writeTestConfig writes a config file with the given services into a temp dir
+func writeTestConfig(t *testing.T, up, down []string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := fmt.Sprintf("up_services = [%s]\ndown_services = [%s]\n", quoteList(up), quoteList(down))
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func quoteList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = fmt.Sprintf("%q", item)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// noSleep disables the simulated processing time for the duration of a test
+func noSleep(t *testing.T) {
+	t.Helper()
+	origSleep := sleep
+	sleep = func(time.Duration) {}
+	t.Cleanup(func() { sleep = origSleep })
+}
+
+func TestHTTPHandler_ActiveRequestsGauge(t *testing.T) {
+	// Use a fixed processing time so requests overlap predictably
+	origSleep := sleep
+	sleep = func(time.Duration) { time.Sleep(100 * time.Millisecond) }
+	defer func() { sleep = origSleep }()
+
+	m := newTestMetrics()
+
+	server := httptest.NewServer(rootHandler(m, newRequestLimiter(defaultMaxConcurrentRequests)))
+	defer server.Close()
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Poll the gauge while requests are in flight to record the peak
+	peak := 0.0
+	polling := true
+	for polling {
+		if v := testutil.ToFloat64(m.ActiveRequests); v > peak {
+			peak = v
+		}
+		select {
+		case <-done:
+			polling = false
+		default:
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if peak < 5 {
+		t.Errorf("expected at least 5 active requests at peak, got %v", peak)
+	}
+	if v := testutil.ToFloat64(m.ActiveRequests); v != 0 {
+		t.Errorf("expected 0 active requests after completion, got %v", v)
+	}
+}
+
+func TestNewServeMux_Routes(t *testing.T) {
+	noSleep(t)
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{ConfigPath: writeTestConfig(t, []string{"api-gateway"}, []string{"user-service"})}
+	mux := NewServeMux(m, cfg)
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/health", http.StatusOK},
+		{http.MethodGet, "/config", http.StatusOK},
+		{http.MethodGet, "/metrics", http.StatusOK},
+		{http.MethodPost, "/reload", http.StatusOK},
+		{http.MethodGet, "/reload", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/debug/pprof/mutex", http.StatusOK},
+		{http.MethodGet, "/debug/pprof/block", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestNewServeMux_IndependentInstances(t *testing.T) {
+	noSleep(t)
+
+	metricsA := newTestMetrics()
+	cfgA := &ServerConfig{ConfigPath: writeTestConfig(t, []string{"service-a"}, nil)}
+	muxA := NewServeMux(metricsA, cfgA)
+
+	metricsB := newTestMetrics()
+	cfgB := &ServerConfig{ConfigPath: writeTestConfig(t, []string{"service-b"}, nil)}
+	muxB := NewServeMux(metricsB, cfgB)
+
+	// Requests on A must only be counted by A's registry
+	for i := 0; i < 3; i++ {
+		muxA.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if v := testutil.ToFloat64(metricsA.RequestsProcessed); v != 3 {
+		t.Errorf("expected 3 requests on A, got %v", v)
+	}
+	if v := testutil.ToFloat64(metricsB.RequestsProcessed); v != 0 {
+		t.Errorf("expected 0 requests on B, got %v", v)
+	}
+
+	// Reloading A must only populate A's service metrics
+	rec := httptest.NewRecorder()
+	muxA.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reload on A failed with status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	bodyA := scrape(t, muxA)
+	if !strings.Contains(bodyA, `service_monitor_up{service="service-a"} 1`) {
+		t.Errorf("expected service-a in A's metrics, got:\n%s", bodyA)
+	}
+
+	bodyB := scrape(t, muxB)
+	if strings.Contains(bodyB, "service-a") {
+		t.Errorf("service-a leaked into B's metrics:\n%s", bodyB)
+	}
+
+	// Each instance serves its own config file
+	rec = httptest.NewRecorder()
+	muxB.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if !strings.Contains(rec.Body.String(), "service-b") || strings.Contains(rec.Body.String(), "service-a") {
+		t.Errorf("unexpected config on B:\n%s", rec.Body.String())
+	}
+}
+
+// scrape returns the /metrics output of mux
+func scrape(t *testing.T, mux http.Handler) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestMetricsReset(t *testing.T) {
+	noSleep(t)
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{
+		ConfigPath:         writeTestConfig(t, []string{"api-gateway"}, nil),
+		EnableMetricsReset: true,
+	}
+	mux := NewServeMux(m, cfg)
+	updateServiceMetrics(m, &Config{UpServices: []string{"api-gateway"}})
+
+	for i := 0; i < 3; i++ {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	m.ErrorRate.Set(0.1)
+	m.ProbeErrors.Inc()
+	m.OnDemandProbes.WithLabelValues(probeTypeHTTP, "success").Inc()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics/reset", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Reset []string `json:"reset"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	reset := strings.Join(resp.Reset, ",")
+	for _, name := range []string{
+		"service_monitor_requests_total",
+		"service_monitor_active_requests",
+		"service_monitor_error_rate",
+	} {
+		if !strings.Contains(reset, name) {
+			t.Errorf("expected %s in reset metrics, got %v", name, resp.Reset)
+		}
+	}
+	if strings.Contains(reset, "service_monitor_up") {
+		t.Errorf("service status must not be reset, got %v", resp.Reset)
+	}
+
+	if v := testutil.ToFloat64(m.RequestsProcessed); v != 0 {
+		t.Errorf("expected requests counter to be 0 after reset, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.ErrorRate); v != 0 {
+		t.Errorf("expected error rate to be 0 after reset, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.ProbeErrors); v != 0 {
+		t.Errorf("expected probe errors to be 0 after reset, got %v", v)
+	}
+	if n := testutil.CollectAndCount(m.OnDemandProbes); n != 0 {
+		t.Errorf("expected the on-demand probe counters to be cleared, got %d series", n)
+	}
+
+	// The registry must expose the fresh instances, and service status is left alone
+	body := scrape(t, mux)
+	for _, line := range []string{
+		"service_monitor_requests_total 0",
+		"service_monitor_error_rate 0",
+		`service_monitor_up{service="api-gateway"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %q in metrics output:\n%s", line, body)
+		}
+	}
+
+	// Instrumentation keeps working against the new instances
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(scrape(t, mux), "service_monitor_requests_total 1") {
+		t.Error("expected requests counter to increment after reset")
+	}
+}
+
+func TestMetricsReset_CoversEveryCounterAndGauge(t *testing.T) {
+	// Gauges that describe the config or are set once at startup
+	notResettable := map[string]bool{
+		"service_monitor_up":                      true,
+		"service_monitor_effective_up":            true,
+		"service_monitor_availability_ratio":      true,
+		"service_monitor_feature_enabled":         true,
+		"service_monitor_config_file_size_bytes":  true,
+		"service_monitor_config_file_lines_total": true,
+		"service_monitor_config_services_total":   true,
+		"service_monitor_probe_worker_count":      true,
+		"service_monitor_memory_limit_bytes":      true,
+		"service_monitor_startup_wait_seconds":    true,
+	}
+
+	m := newTestMetrics()
+	resettable := map[string]bool{}
+	for _, metric := range m.resettable {
+		resettable[metric.name()] = true
+	}
+
+	counterOrGauge := []reflect.Type{
+		reflect.TypeOf((*prometheus.Counter)(nil)).Elem(),
+		reflect.TypeOf((*prometheus.Gauge)(nil)).Elem(),
+		reflect.TypeOf((*prometheus.CounterVec)(nil)),
+		reflect.TypeOf((*prometheus.GaugeVec)(nil)),
+	}
+	v := reflect.ValueOf(m).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !slices.Contains(counterOrGauge, field.Type) {
+			continue
+		}
+		name := describedName(t, v.Field(i).Interface().(prometheus.Collector))
+		if !resettable[name] && !notResettable[name] {
+			t.Errorf("%s (%s) is not reset by /metrics/reset; create it with newCounter, newGauge or newCounterVec", field.Name, name)
+		}
+	}
+}
+
+// describedName returns the name of the single metric c describes
+func describedName(t *testing.T, c prometheus.Collector) string {
+	t.Helper()
+	descs := make(chan *prometheus.Desc, 1)
+	c.Describe(descs)
+	match := regexp.MustCompile(`fqName: "([^"]+)"`).FindStringSubmatch((<-descs).String())
+	if match == nil {
+		t.Fatalf("no metric name in the description of %T", c)
+	}
+	return match[1]
+}
+
+func TestMetricsReset_DisabledByDefault(t *testing.T) {
+	noSleep(t)
+
+	m := newTestMetrics()
+	mux := NewServeMux(m, &ServerConfig{ConfigPath: writeTestConfig(t, nil, nil)})
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/metrics/reset", nil))
+
+	// Without the reset route the request falls through to the root handler
+	if v := testutil.ToFloat64(m.RequestsProcessed); v != 2 {
+		t.Errorf("expected counters to be untouched when reset is disabled, got %v", v)
+	}
+}
+
+func BenchmarkMetricsScrape(b *testing.B) {
+	m := newTestMetrics()
+	services := make([]string, 50)
+	for i := range services {
+		services[i] = fmt.Sprintf("service-%d", i)
+	}
+	updateServiceMetrics(m, &Config{UpServices: services})
+
+	server := httptest.NewServer(NewServeMux(m, &ServerConfig{}))
+	defer server.Close()
+
+	// Bound open connections so large b.N doesn't exhaust file descriptors
+	const maxInFlight = 64
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: maxInFlight}}
+	defer client.CloseIdleConnections()
+
+	scrapeOnce := func() (int64, error) {
+		resp, err := client.Get(server.URL + "/metrics")
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		return io.Copy(io.Discard, resp.Body)
+	}
+
+	size, err := scrapeOnce()
+	if err != nil {
+		b.Fatalf("scrape failed: %v", err)
+	}
+
+	procsList := []int{1}
+	if n := runtime.NumCPU(); n > 1 {
+		procsList = append(procsList, n)
+	}
+
+	for _, procs := range procsList {
+		b.Run(fmt.Sprintf("GOMAXPROCS=%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			sem := make(chan struct{}, maxInFlight)
+			var wg sync.WaitGroup
+			for i := 0; i < b.N; i++ {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func() {
+					defer wg.Done()
+					defer func() { <-sem }()
+					if _, err := scrapeOnce(); err != nil {
+						b.Errorf("scrape failed: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestSeparateAppAndMetricsServers(t *testing.T) {
+	noSleep(t)
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{ConfigPath: writeTestConfig(t, []string{"api-gateway"}, nil)}
+
+	app := httptest.NewServer(NewAppServeMux(m, cfg))
+	defer app.Close()
+	metricsSrv := httptest.NewServer(NewMetricsServeMux(m, cfg))
+	defer metricsSrv.Close()
+
+	status := func(url string) int {
+		t.Helper()
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", url, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, path := range []string{"/health", "/config"} {
+		if code := status(app.URL + path); code != http.StatusOK {
+			t.Errorf("app server %s: expected 200, got %d", path, code)
+		}
+	}
+	if code := status(metricsSrv.URL + "/metrics"); code != http.StatusOK {
+		t.Errorf("metrics server /metrics: expected 200, got %d", code)
+	}
+
+	// The app server's catch-all root handler must not serve metrics
+	resp, err := http.Get(app.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics on app server failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(body), "service_monitor_requests_total") {
+		t.Error("/metrics must not be served by the app server")
+	}
+
+	for _, path := range []string{"/", "/health", "/config"} {
+		if code := status(metricsSrv.URL + path); code != http.StatusNotFound {
+			t.Errorf("metrics server %s: expected 404, got %d", path, code)
+		}
+	}
+
+	// Both servers share the registry
+	status(app.URL + "/")
+	if !strings.Contains(scrape(t, NewMetricsServeMux(m, cfg)), "service_monitor_requests_total") {
+		t.Error("expected app traffic to be visible on the metrics server")
+	}
+	if v := testutil.ToFloat64(m.RequestsProcessed); v < 1 {
+		t.Errorf("expected app requests to be counted, got %v", v)
+	}
+}
+
+func TestRunServers_ShutsDownOnCancel(t *testing.T) {
+	freeAddr := func() string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to reserve a port: %v", err)
+		}
+		defer l.Close()
+		return l.Addr().String()
+	}
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{ConfigPath: writeTestConfig(t, nil, nil)}
+	appServer := &http.Server{Addr: freeAddr(), Handler: NewAppServeMux(m, cfg)}
+	metricsServer := &http.Server{Addr: freeAddr(), Handler: NewMetricsServeMux(m, cfg)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runServers(ctx, appServer, metricsServer) }()
+
+	// Wait for both servers to accept connections
+	for _, url := range []string{"http://" + appServer.Addr + "/health", "http://" + metricsServer.Addr + "/metrics"} {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			resp, err := http.Get(url)
+			if err == nil {
+				resp.Body.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("server at %s did not start: %v", url, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("servers did not shut down")
+	}
+}
+
+// failingCollector makes every Gather of its registry return an error
+type failingCollector struct{}
+
+func (failingCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (failingCollector) Collect(ch chan<- prometheus.Metric) {
+	desc := prometheus.NewDesc("failing_metric", "Always fails", nil, nil)
+	ch <- prometheus.NewInvalidMetric(desc, fmt.Errorf("collector broken"))
+}
+
+func TestReadyHandler(t *testing.T) {
+	origConfig := loadCurrentConfig()
+	defer currentConfig.Store(origConfig)
+
+	ready := func(m *Metrics) int {
+		rec := httptest.NewRecorder()
+		readyHandler(m)(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}
+
+	m := newTestMetrics()
+	currentConfig.Store(nil)
+	if code := ready(m); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before config is loaded, got %d", code)
+	}
+
+	// A config without services leaves service_monitor_up empty
+	updateServiceMetrics(m, &Config{})
+	if code := ready(m); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without service_monitor_up, got %d", code)
+	}
+
+	updateServiceMetrics(m, &Config{UpServices: []string{"api"}})
+	if code := ready(m); code != http.StatusOK {
+		t.Errorf("expected 200 once ready, got %d", code)
+	}
+
+	m.Registry.MustRegister(failingCollector{})
+	if code := ready(m); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with a broken registry, got %d", code)
+	}
+}
+
+// renderConfigTextFprintf is the previous fmt-based /config renderer, kept as
+// the reference output and benchmark baseline
+func renderConfigTextFprintf(w io.Writer, config *Config) {
+	fmt.Fprintf(w, "UP SERVICES (%d):\n", len(config.UpServices))
+	for _, svc := range config.UpServices {
+		fmt.Fprintf(w, "- %s\n", svc)
+	}
+
+	fmt.Fprintf(w, "\nDOWN SERVICES (%d):\n", len(config.DownServices))
+	for _, svc := range config.DownServices {
+		fmt.Fprintf(w, "- %s\n", svc)
+	}
+}
+
+// benchmarkConfig returns a config with n services, a fifth of them down
+func benchmarkConfig(n int) *Config {
+	config := &Config{}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("service-%04d", i)
+		if i%5 == 0 {
+			config.DownServices = append(config.DownServices, name)
+		} else {
+			config.UpServices = append(config.UpServices, name)
+		}
+	}
+	return config
+}
+
+func TestRenderConfigText(t *testing.T) {
+	for _, config := range []*Config{
+		{},
+		{UpServices: []string{"api-gateway"}},
+		{UpServices: []string{"api-gateway", "auth-service"}, DownServices: []string{"user-service"}},
+		benchmarkConfig(1000),
+	} {
+		var want strings.Builder
+		renderConfigTextFprintf(&want, config)
+		if got := renderConfigText(config); got != want.String() {
+			t.Errorf("unexpected output:\n%s\nwant:\n%s", got, want.String())
+		}
+	}
+}
+
+func BenchmarkRenderConfigText(b *testing.B) {
+	config := benchmarkConfig(1000)
+
+	b.Run("fprintf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			renderConfigTextFprintf(io.Discard, config)
+		}
+	})
+	b.Run("builder", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			io.WriteString(io.Discard, renderConfigText(config))
+		}
+	})
+}
+
+func TestConfigHandler_LockWait(t *testing.T) {
+	preserveConfigState(t)
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{ConfigPath: writeTestConfig(t, []string{"api"}, nil)}
+
+	if _, err := reloadConfig(m, cfg, loadTriggerManual); err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	configMutex.Lock()
+	origModTime := lastModTime
+	lastModTime = modTime
+	t.Cleanup(func() {
+		configMutex.Lock()
+		lastModTime = origModTime
+		configMutex.Unlock()
+	})
+
+	// Hold the write lock like a reload would while the request comes in
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		configHandler(m, cfg)(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	}()
+	const held = 20 * time.Millisecond
+	time.Sleep(held)
+	configMutex.Unlock()
+	<-done
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Last-Modified"); got != modTime.Format(http.TimeFormat) {
+		t.Errorf("expected Last-Modified %q, got %q", modTime.Format(http.TimeFormat), got)
+	}
+
+	var lockWait, duration dto.Metric
+	if err := m.ConfigHandlerLockWait.Write(&lockWait); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ConfigHandlerDuration.Write(&duration); err != nil {
+		t.Fatal(err)
+	}
+	if h := lockWait.GetHistogram(); h.GetSampleCount() != 1 || h.GetSampleSum() <= 0 {
+		t.Errorf("expected one non-zero lock wait, got %d samples summing to %v", h.GetSampleCount(), h.GetSampleSum())
+	}
+	if s := duration.GetSummary(); s.GetSampleCount() != 1 || s.GetSampleSum() < lockWait.GetHistogram().GetSampleSum() {
+		t.Errorf("expected the handler duration to include the lock wait, got %+v", s)
+	}
+}
+
+func TestConfigHandler_ConditionalGet(t *testing.T) {
+	preserveConfigState(t)
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{ConfigPath: writeTestConfig(t, []string{"api"}, nil)}
+	handler := configHandler(m, cfg)
+
+	configMutex.Lock()
+	applyConfig(m, cfg, &Config{UpServices: []string{"api"}}, loadTriggerManual)
+	configMutex.Unlock()
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d and %q", rec.Code, etag)
+	}
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/config", nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := get(etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("expected an empty 304 for the current ETag, got %d: %q", rec.Code, rec.Body.String())
+	}
+	if v := testutil.ToFloat64(m.ConfigCacheHits); v != 1 {
+		t.Errorf("expected 1 cache hit, got %v", v)
+	}
+
+	// Editing the file changes the ETag before the edit is reloaded, so it
+	// always describes the body served
+	writeConfigAt(t, cfg.ConfigPath, "up_services = [\"api\"]\ndown_services = [\"db\"]\n", time.Now().Add(time.Minute))
+
+	rec = get(etag)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for a stale ETag, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "- db") {
+		t.Errorf("expected the edited file to be served, got:\n%s", rec.Body.String())
+	}
+	want := computeConfigETag(&Config{UpServices: []string{"api"}, DownServices: []string{"db"}})
+	if got := rec.Header().Get("ETag"); got == etag || got != want {
+		t.Errorf("expected the ETag %s of the edited config, got %s", want, got)
+	}
+	if got := rec.Header().Get("Last-Modified"); got != "" {
+		t.Errorf("expected no Last-Modified for a config that wasn't applied, got %q", got)
+	}
+	if v := testutil.ToFloat64(m.ConfigCacheHits); v != 1 {
+		t.Errorf("expected cache hits to stay at 1, got %v", v)
+	}
+
+	if rec := get(want); rec.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for the ETag of the edited config, got %d", rec.Code)
+	}
+}
+
+// writeRandomConfig atomically replaces the config at path with a random
+// up/down split of a fixed set of services
+func writeRandomConfig(rng *rand.Rand, path string) error {
+	var up, down []string
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("service-%d", i)
+		switch rng.Intn(3) {
+		case 0:
+			up = append(up, name)
+		case 1:
+			down = append(down, name)
+		}
+	}
+	content := fmt.Sprintf("up_services = [%s]\ndown_services = [%s]\n", quoteList(up), quoteList(down))
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "config-*.toml")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// checkServiceStatusValues fails the test unless every service_monitor_up
+// series is either 0 or 1
+func checkServiceStatusValues(t *testing.T, m *Metrics) bool {
+	mfs, err := m.Registry.Gather()
+	if err != nil {
+		t.Errorf("gather failed: %v", err)
+		return false
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "service_monitor_up" {
+			continue
+		}
+		for _, metric := range mf.GetMetric() {
+			if v := metric.GetGauge().GetValue(); v != 0 && v != 1 {
+				t.Errorf("service_monitor_up%v = %v, expected 0 or 1", metric.GetLabel(), v)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestConcurrentAPIAndConfigReload(t *testing.T) {
+	duration := 10 * time.Second
+	if testing.Short() {
+		duration = time.Second
+	}
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	preserveConfigState(t)
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{ConfigPath: writeTestConfig(t, []string{"service-0"}, nil)}
+	mux := NewServeMux(m, cfg)
+
+	deadline := time.Now().Add(duration)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(worker)))
+			for time.Now().Before(deadline) {
+				if err := writeRandomConfig(rng, cfg.ConfigPath); err != nil {
+					t.Errorf("failed to write config: %v", err)
+					return
+				}
+
+				if worker%2 == 0 {
+					// Reload through the API
+					rec := httptest.NewRecorder()
+					mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+					if rec.Code != http.StatusOK {
+						t.Errorf("reload failed with status %d: %s", rec.Code, rec.Body.String())
+						return
+					}
+				} else {
+					// Reload like the watcher does on a file change
+					checkConfigFile(m, cfg)
+				}
+
+				if !checkServiceStatusValues(t, m) {
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if config := loadCurrentConfig(); config == nil || len(config.UpServices)+len(config.DownServices) > 8 {
+		t.Errorf("unexpected config after the stress test: %+v", config)
+	}
+}
+
+func TestReload_DryRun(t *testing.T) {
+	preserveConfigState(t)
+	m := newTestMetrics()
+	path := writeTestConfig(t, []string{"api-gateway"}, []string{"auth-service"})
+	cfg := &ServerConfig{ConfigPath: path}
+	mux := NewAppServeMux(m, cfg)
+
+	reload := func(query string) (*httptest.ResponseRecorder, map[string]any) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload"+query, nil))
+		var body map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec, body
+	}
+	if rec, _ := reload(""); rec.Code != http.StatusOK {
+		t.Fatalf("initial reload failed: %d", rec.Code)
+	}
+
+	configMutex.RLock()
+	generation, modTime := configGeneration, lastModTime
+	configMutex.RUnlock()
+	bytesRead := testutil.ToFloat64(m.ConfigBytesRead)
+	loads := histogramOf(t, m, loadTriggerManual, "success").GetSampleCount()
+
+	// An unchanged file is valid and would change nothing
+	rec, body := reload("?dry_run=true")
+	if rec.Code != http.StatusOK || body["status"] != "valid" || body["dry_run"] != true || body["would_change"] != false {
+		t.Errorf("unexpected dry run of the unchanged config: %d %v", rec.Code, body)
+	}
+
+	// A valid change is reported but not applied
+	later := time.Now().Add(time.Minute)
+	writeConfigAt(t, path, "up_services = [\"api-gateway\", \"auth-service\"]\n", later)
+	rec, body = reload("?dry_run=true")
+	if rec.Code != http.StatusOK || body["would_change"] != true || body["up_services"] != float64(2) {
+		t.Errorf("unexpected dry run of a changed config: %d %v", rec.Code, body)
+	}
+
+	// An invalid config is rejected with 422
+	writeConfigAt(t, path, "up_services = [\"api-gateway\"]\ndown_services = [\"api-gateway\"]\n", later)
+	rec, body = reload("?dry_run=true")
+	if rec.Code != http.StatusUnprocessableEntity || body["status"] != "error" || body["dry_run"] != true {
+		t.Errorf("expected 422 for an invalid config, got %d %v", rec.Code, body)
+	}
+	writeConfigAt(t, path, "up_services = [", later)
+	if rec, _ := reload("?dry_run=1"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for an unparseable config, got %d", rec.Code)
+	}
+
+	// None of the dry runs touched the metrics, counters or config state
+	if v := testutil.ToFloat64(m.ServiceStatus.WithLabelValues("auth-service")); v != 0 {
+		t.Errorf("dry runs changed service_monitor_up, auth-service is %v", v)
+	}
+	if v := testutil.ToFloat64(m.ConfigBytesRead); v != bytesRead {
+		t.Errorf("dry runs were counted in service_monitor_config_bytes_read_total: %v -> %v", bytesRead, v)
+	}
+	if n := histogramOf(t, m, loadTriggerManual, "success").GetSampleCount(); n != loads {
+		t.Errorf("dry runs were recorded as config loads: %d -> %d", loads, n)
+	}
+	if n := histogramOf(t, m, loadTriggerManual, "error").GetSampleCount(); n != 0 {
+		t.Errorf("failed dry runs were recorded as config loads: %d", n)
+	}
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	if configGeneration != generation || !lastModTime.Equal(modTime) {
+		t.Errorf("dry runs changed the config state: generation %d -> %d", generation, configGeneration)
+	}
+	if config := loadCurrentConfig(); len(config.DownServices) != 1 {
+		t.Errorf("dry run applied the config: %+v", config)
+	}
+
+	if rec, _ := reload("?dry_run=maybe"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid dry_run, got %d", rec.Code)
+	}
+}
+
+// writeConfigAt replaces the config file at path and sets its modification time
+func writeConfigAt(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serviceLabelProblems returns a description of every invalid custom label
+func serviceLabelProblems(labels map[string]map[string]string) []string {
+	var problems []string
+	for _, service := range sortedKeys(labels) {
+		for _, name := range sortedKeys(labels[service]) {
+			switch {
+			case name == "service":
+				problems = append(problems, fmt.Sprintf("labels of service %q can't override the service label", service))
+			case !isValidLabelName(name):
+				problems = append(problems, fmt.Sprintf("labels of service %q have invalid name %q", service, name))
+			}
+		}
+	}
+	return problems
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// serviceLabelNames returns the union of the custom label names of the
+// configured services, sorted, which is the label schema of the info metric
+func serviceLabelNames(config *Config) []string {
+	seen := make(map[string]bool)
+	for service, labels := range config.ServiceLabels {
+		if !isConfiguredService(config, service) {
+			continue
+		}
+		for name := range labels {
+			seen[name] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// isConfiguredService reports whether service is listed as up or down
+func isConfiguredService(config *Config, service string) bool {
+	return slices.Contains(config.UpServices, service) || slices.Contains(config.DownServices, service)
+}
+
+// newServiceLabelsInfo returns the info metric for the given custom label names
+func newServiceLabelsInfo(names []string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "service_monitor_service_labels_info",
+			Help: "Custom labels of monitored services from the [service_labels] config section (always 1)",
+		},
+		append([]string{"service"}, names...),
+	)
+}
+
+// serviceLabelsCollector exposes the current service labels info metric
+// The registry keeps the label names of a metric even after it is
+// unregistered, so the GaugeVec is swapped here instead and the collector is
+// unchecked, describing nothing
+type serviceLabelsCollector struct {
+	info atomic.Pointer[prometheus.GaugeVec]
+}
+
+func newServiceLabelsCollector() *serviceLabelsCollector {
+	c := &serviceLabelsCollector{}
+	c.info.Store(newServiceLabelsInfo(nil))
+	return c
+}
+
+// Describe implements prometheus.Collector
+func (c *serviceLabelsCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector
+func (c *serviceLabelsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.info.Load().Collect(ch)
+}
+
+// updateServiceLabels publishes the custom labels of every configured service
+// The info metric is recreated with the union of the label names on every
+// update, and services lacking one of the names get an empty value for it
+// The caller must hold configMutex for writing when other goroutines are running
+func updateServiceLabels(m *Metrics, config *Config) {
+	names := serviceLabelNames(config)
+	info := newServiceLabelsInfo(names)
+	for _, service := range sortedKeys(config.ServiceLabels) {
+		if !isConfiguredService(config, service) {
+			continue
+		}
+		values := make([]string, 0, len(names)+1)
+		values = append(values, service)
+		for _, name := range names {
+			values = append(values, config.ServiceLabels[service][name])
+		}
+		info.WithLabelValues(values...).Set(1)
+	}
+
+	m.ServiceLabels.info.Store(info)
+}
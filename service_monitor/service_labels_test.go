@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestServiceLabelProblems(t *testing.T) {
+	labels := map[string]map[string]string{
+		"api": {"team": "platform", "service": "other", "bad-name": "x"},
+		"db":  {"dc": "east"},
+	}
+	if got := serviceLabelProblems(labels); len(got) != 2 {
+		t.Errorf("expected 2 problems, got %v", got)
+	}
+}
+
+func TestUpdateServiceLabels(t *testing.T) {
+	preserveConfigState(t)
+	m := newTestMetrics()
+
+	config, err := parseConfig([]byte(`up_services = ["service-a"]
+down_services = ["service-b"]
+
+[service_labels.service-a]
+team = "a"
+
+[service_labels.service-b]
+dc = "east"
+
+[service_labels.removed-service]
+owner = "nobody"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateServiceMetrics(m, config)
+
+	want := `
+# HELP service_monitor_service_labels_info Custom labels of monitored services from the [service_labels] config section (always 1)
+# TYPE service_monitor_service_labels_info gauge
+service_monitor_service_labels_info{dc="",service="service-a",team="a"} 1
+service_monitor_service_labels_info{dc="east",service="service-b",team=""} 1
+`
+	if err := testutil.GatherAndCompare(m.Registry, strings.NewReader(want), "service_monitor_service_labels_info"); err != nil {
+		t.Error(err)
+	}
+
+	// A reload with other label names replaces the schema
+	config.ServiceLabels = map[string]map[string]string{"service-b": {"region": "eu"}}
+	updateServiceMetrics(m, config)
+
+	want = `
+# HELP service_monitor_service_labels_info Custom labels of monitored services from the [service_labels] config section (always 1)
+# TYPE service_monitor_service_labels_info gauge
+service_monitor_service_labels_info{region="eu",service="service-b"} 1
+`
+	if err := testutil.GatherAndCompare(m.Registry, strings.NewReader(want), "service_monitor_service_labels_info"); err != nil {
+		t.Error(err)
+	}
+}
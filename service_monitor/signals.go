@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals returns a context that is cancelled on SIGINT or SIGTERM
+// SIGHUP reloads the config file immediately instead, the Unix convention
+// for "reload everything"; calling stop stops listening for signals
+// Reloads go through the reload queue so SIGTERM is never held up by one
+func handleSignals(m *Metrics, cfg *ServerConfig) (ctx context.Context, stop context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(sigChan)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-sigChan:
+				if sig != syscall.SIGHUP {
+					log.Printf("Received %s, shutting down", sig)
+					cancel()
+					return
+				}
+				cfg.requestReload(loadTriggerSignal, func() (*Config, error) {
+					config, err := reloadFromSource(ctx, m, cfg, loadTriggerSignal)
+					if err != nil {
+						log.Printf("Error reloading config on SIGHUP: %v", err)
+						return nil, err
+					}
+					log.Printf("Reloaded config on SIGHUP: %d up services and %d down services",
+						len(config.UpServices), len(config.DownServices))
+					return config, nil
+				})
+			}
+		}
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHandleSignals_SIGHUPReloadsConfig(t *testing.T) {
+	preserveConfigState(t)
+	m := newTestMetrics()
+	cfg := &ServerConfig{ConfigPath: writeTestConfig(t, []string{"api-gateway"}, []string{"auth-service"})}
+
+	ctx, stop := handleSignals(m, cfg)
+	defer stop()
+
+	proc, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(1); i <= 2; i++ {
+		if err := proc.Signal(syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		reloaded := waitFor(t, 5*time.Second, func() bool {
+			return histogramOf(t, m, loadTriggerSignal, "success").GetSampleCount() == i
+		})
+		if !reloaded {
+			t.Fatalf("expected %d reloads on SIGHUP", i)
+		}
+	}
+
+	if v := testutil.ToFloat64(m.ServiceStatus.WithLabelValues("auth-service")); v != 0 {
+		t.Errorf("expected auth-service to be down after the reload, got %v", v)
+	}
+	if config := loadCurrentConfig(); config == nil || len(config.UpServices) != 1 {
+		t.Errorf("expected the reloaded config to be applied, got %+v", config)
+	}
+	if ctx.Err() != nil {
+		t.Error("expected SIGHUP not to cancel the context")
+	}
+
+	// SIGTERM is handled on the same channel and shuts down instead
+	if err := proc.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected SIGTERM to cancel the context")
+	}
+}
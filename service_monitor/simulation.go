@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxConcurrentRequests applies when the config doesn't set a limit
+	defaultMaxConcurrentRequests = 50
+
+	// defaultEMAAlpha is the smoothing factor when neither the config nor
+	// ACTIVE_REQUESTS_EMA_ALPHA sets one
+	defaultEMAAlpha = 0.2
+)
+
+// SimulationConfig controls the simulated request handler and load
+type SimulationConfig struct {
+	MaxConcurrentRequests int `toml:"max_concurrent_requests"`
+
+	// Smoothing factor of the simulated active requests gauge, in (0, 1]
+	EMAAlpha float64 `toml:"ema_alpha"`
+}
+
+// maxConcurrentRequests returns the configured limit or the default
+func (c SimulationConfig) maxConcurrentRequests() int {
+	if c.MaxConcurrentRequests <= 0 {
+		return defaultMaxConcurrentRequests
+	}
+	return c.MaxConcurrentRequests
+}
+
+// emaAlpha returns the configured smoothing factor, or fallback if unset or invalid
+func (c SimulationConfig) emaAlpha(fallback float64) float64 {
+	if c.EMAAlpha <= 0 || c.EMAAlpha > 1 {
+		return fallback
+	}
+	return c.EMAAlpha
+}
+
+// movingAverage is an exponential moving average whose smoothing factor
+// can be changed without losing its state
+type movingAverage struct {
+	mu          sync.Mutex
+	alpha       float64
+	value       float64
+	initialized bool
+}
+
+func newMovingAverage(alpha float64) *movingAverage {
+	return &movingAverage{alpha: alpha}
+}
+
+// Add folds sample into the average and returns the new value
+func (a *movingAverage) Add(sample float64) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Seed with the first sample so the average doesn't ramp up from zero
+	if !a.initialized {
+		a.value = sample
+		a.initialized = true
+		return a.value
+	}
+
+	a.value = a.alpha*sample + (1-a.alpha)*a.value
+	return a.value
+}
+
+// SetAlpha changes the smoothing factor for subsequent samples
+func (a *movingAverage) SetAlpha(alpha float64) {
+	a.mu.Lock()
+	a.alpha = alpha
+	a.mu.Unlock()
+}
+
+// simulateLoad periodically sets the active requests gauge to a smoothed
+// random load
+func simulateLoad(m *Metrics, average *movingAverage, interval time.Duration) {
+	for {
+		// Simulate fluctuating load
+		load := rand.Float64() * 10
+		m.ActiveRequests.Set(average.Add(load))
+		time.Sleep(interval)
+	}
+}
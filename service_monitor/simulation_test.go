@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMovingAverage_ConvergesToSteadyState(t *testing.T) {
+	average := newMovingAverage(0.2)
+
+	// Alternating samples converge to a band around their mean
+	var value float64
+	for i := 0; i < 100; i++ {
+		sample := 4.0
+		if i%2 == 1 {
+			sample = 6.0
+		}
+		value = average.Add(sample)
+	}
+	if math.Abs(value-5) > 0.25 {
+		t.Errorf("expected EMA to converge near 5, got %v", value)
+	}
+
+	// A step change is tracked within a tolerance after enough samples
+	for i := 0; i < 50; i++ {
+		value = average.Add(10)
+	}
+	if math.Abs(value-10) > 0.01 {
+		t.Errorf("expected EMA to converge to 10, got %v", value)
+	}
+}
+
+func TestMovingAverage_KnownSeries(t *testing.T) {
+	average := newMovingAverage(0.5)
+
+	want := []float64{8, 4, 6, 3}
+	for i, sample := range []float64{8, 0, 8, 0} {
+		if got := average.Add(sample); got != want[i] {
+			t.Errorf("sample %d: expected %v, got %v", i, want[i], got)
+		}
+	}
+}
+
+func TestMovingAverage_SetAlphaKeepsState(t *testing.T) {
+	average := newMovingAverage(0.2)
+	average.Add(10)
+
+	average.SetAlpha(1)
+	if got := average.Add(2); got != 2 {
+		t.Errorf("expected alpha=1 to follow the latest sample, got %v", got)
+	}
+
+	average.SetAlpha(0.5)
+	if got := average.Add(4); got != 3 {
+		t.Errorf("expected alpha change to keep the previous value, got %v", got)
+	}
+}
+
+func TestApplyConfig_UpdatesEMAAlpha(t *testing.T) {
+	m := newTestMetrics()
+	cfg := &ServerConfig{EMAAlpha: 0.5}
+
+	average := cfg.loadAverage()
+	average.Add(10)
+
+	applyConfig(m, cfg, &Config{Simulation: SimulationConfig{EMAAlpha: 1}}, loadTriggerManual)
+	if got := average.Add(0); got != 0 {
+		t.Errorf("expected configured alpha 1 to apply live, got %v", got)
+	}
+
+	// Without ema_alpha in the config, the environment default applies again
+	applyConfig(m, cfg, &Config{}, loadTriggerManual)
+	if got := average.Add(8); got != 4 {
+		t.Errorf("expected default alpha 0.5 after reload, got %v", got)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSLOWindowMinutes        = 60
+	defaultSLOProbeIntervalSeconds = 60
+	defaultSLOThreshold            = 0.95
+)
+
+// SLOConfig controls how per-service availability is tracked
+type SLOConfig struct {
+	WindowMinutes        int `toml:"window_minutes"`
+	ProbeIntervalSeconds int `toml:"probe_interval_seconds"`
+
+	// Fraction of services that must be up for /status to report the SLO as met
+	Threshold float64 `toml:"threshold"`
+
+	// Number of probe results kept per service for /history
+	HistoryDepth int `toml:"history_depth"`
+}
+
+// windowMinutes returns the length of the SLO window in minutes
+func (c SLOConfig) windowMinutes() int {
+	if c.WindowMinutes <= 0 {
+		return defaultSLOWindowMinutes
+	}
+	return c.WindowMinutes
+}
+
+// threshold returns the fraction of healthy services the SLO requires
+func (c SLOConfig) threshold() float64 {
+	if c.Threshold <= 0 || c.Threshold > 1 {
+		return defaultSLOThreshold
+	}
+	return c.Threshold
+}
+
+// historyDepth returns the number of probe results kept per service
+func (c SLOConfig) historyDepth() int {
+	if c.HistoryDepth <= 0 {
+		return defaultHistoryDepth
+	}
+	return c.HistoryDepth
+}
+
+// probeInterval returns the time between availability probes
+func (c SLOConfig) probeInterval() time.Duration {
+	if c.ProbeIntervalSeconds <= 0 {
+		return defaultSLOProbeIntervalSeconds * time.Second
+	}
+	return time.Duration(c.ProbeIntervalSeconds) * time.Second
+}
+
+// windowSize returns the number of probe outcomes that fit in the SLO window
+func (c SLOConfig) windowSize() int {
+	window := time.Duration(c.windowMinutes()) * time.Minute
+	n := int(window / c.probeInterval())
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// availabilityWindow is a circular buffer of the last N probe outcomes
+type availabilityWindow struct {
+	outcomes []bool
+	next     int
+	count    int
+	upCount  int
+}
+
+func newAvailabilityWindow(size int) *availabilityWindow {
+	return &availabilityWindow{outcomes: make([]bool, size)}
+}
+
+// push records a probe outcome, evicting the oldest one once the buffer is full
+func (w *availabilityWindow) push(up bool) {
+	if w.count == len(w.outcomes) {
+		if w.outcomes[w.next] {
+			w.upCount--
+		}
+	} else {
+		w.count++
+	}
+
+	w.outcomes[w.next] = up
+	if up {
+		w.upCount++
+	}
+	w.next = (w.next + 1) % len(w.outcomes)
+}
+
+// ratio returns the fraction of up outcomes, or -1 until the window is full
+func (w *availabilityWindow) ratio() float64 {
+	if w.count < len(w.outcomes) {
+		return -1
+	}
+	return float64(w.upCount) / float64(len(w.outcomes))
+}
+
+// SLOTracker keeps a rolling availability window and the recent probe
+// results of every known service
+type SLOTracker struct {
+	mu      sync.Mutex
+	size    int
+	windows map[string]*availabilityWindow
+
+	history *ProbeHistory
+
+	// Results of the health checks of services with a probe target
+	healthChecks *HealthCheckHistory
+}
+
+// NewSLOTracker creates a tracker whose windows hold size probe outcomes and
+// that keeps historyDepth probe results per service
+func NewSLOTracker(size, historyDepth int) *SLOTracker {
+	return &SLOTracker{
+		size:         size,
+		windows:      make(map[string]*availabilityWindow),
+		history:      NewProbeHistory(historyDepth),
+		healthChecks: NewHealthCheckHistory(defaultHealthCheckHistorySize),
+	}
+}
+
+// Record pushes a probe outcome for service and returns its updated ratio
+func (t *SLOTracker) Record(service string, up bool) float64 {
+	t.history.record(service, up, time.Now())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.windows[service]
+	if !ok {
+		w = newAvailabilityWindow(t.size)
+		t.windows[service] = w
+	}
+	w.push(up)
+	return w.ratio()
+}
+
+// Ratio returns the availability ratio of service and whether its window is full
+func (t *SLOTracker) Ratio(service string) (float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.windows[service]
+	if !ok {
+		return 0, false
+	}
+	ratio := w.ratio()
+	return ratio, ratio >= 0
+}
+
+// probeService reports whether service is up given its configured status;
+// replaced in tests
+var probeService = func(service string, configuredUp bool) bool {
+	return configuredUp
+}
+
+// probeServices records one probe outcome per configured service and
+// updates the availability gauges; services no longer in the config are dropped
+func probeServices(m *Metrics, tracker *SLOTracker, config *Config) {
+	seen := make(map[string]bool, len(config.UpServices)+len(config.DownServices))
+	for _, service := range config.UpServices {
+		seen[service] = true
+		probeOne(m, tracker, service, true)
+	}
+	for _, service := range config.DownServices {
+		seen[service] = true
+		probeOne(m, tracker, service, false)
+	}
+	pruneProbes(m, tracker, seen)
+}
+
+// probeOne records a probe outcome of service and updates its availability
+// gauge; a panicking probe is counted and skipped so other services are still probed
+func probeOne(m *Metrics, tracker *SLOTracker, service string, configuredUp bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Probe of service %s panicked: %v", service, r)
+			m.ProbeErrors.Inc()
+		}
+	}()
+	m.AvailabilityRatio.WithLabelValues(service).Set(tracker.Record(service, probeService(service, configuredUp)))
+}
+
+// pruneProbes drops the windows, history and gauges of services not in seen
+func pruneProbes(m *Metrics, tracker *SLOTracker, seen map[string]bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	for service := range tracker.windows {
+		if !seen[service] {
+			removeProbedService(m, tracker, service)
+		}
+	}
+}
+
+// removeProbedService drops the window, history and gauges of service
+// The caller must hold tracker.mu
+func removeProbedService(m *Metrics, tracker *SLOTracker, service string) {
+	delete(tracker.windows, service)
+	tracker.history.remove(service)
+	tracker.healthChecks.remove(m, service)
+	m.AvailabilityRatio.DeleteLabelValues(service)
+	m.ProbeBytesSent.DeleteLabelValues(service)
+	m.ProbeBytesReceived.DeleteLabelValues(service)
+}
+
+// scheduleProbes queues a probe of every configured service that is due at
+// now and drops the services no longer in the config
+// The probes of a service are cancelled, and its state dropped, as soon as
+// a config without it is applied; pruning here catches what that missed
+func scheduleProbes(ctx context.Context, m *Metrics, tracker *SLOTracker, queue *probeQueue, schedule *probeSchedule, config *Config, now time.Time) {
+	seen := make(map[string]bool, len(config.UpServices)+len(config.DownServices))
+	visit := func(service string, configuredUp bool) {
+		seen[service] = true
+		if !schedule.due(m, service, now) {
+			return
+		}
+		serviceCtx := probeContext(ctx, service, func() {
+			tracker.mu.Lock()
+			defer tracker.mu.Unlock()
+			removeProbedService(m, tracker, service)
+		})
+		job := probeJob{service: service, configuredUp: configuredUp, ctx: serviceCtx}
+		if target, ok := config.Probes[service]; ok {
+			job.target = &target
+		}
+		queue.schedule(job)
+	}
+	for _, service := range config.UpServices {
+		visit(service, true)
+	}
+	for _, service := range config.DownServices {
+		visit(service, false)
+	}
+	schedule.retain(seen)
+	pruneProbes(m, tracker, seen)
+}
+
+// runSLOProbes probes every service once per interval until ctx is
+// cancelled, restarting the scheduler whenever it panics; workers run the
+// queued probes concurrently
+// With more than one worker, two probes of the same service may run at once
+// and be recorded out of order
+func runSLOProbes(ctx context.Context, m *Metrics, tracker *SLOTracker, interval time.Duration, workers int) {
+	log.Printf("Starting SLO probes every %s with %d workers (window of %d probes)", interval, workers, tracker.size)
+
+	queue := newProbeQueue(probeQueueSize, m.ProbeCoalesced)
+	m.ProbeWorkerCount.Set(float64(workers))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runSLOProbeWorker(m, tracker, queue)
+		}()
+	}
+
+	schedule := newProbeSchedule(interval)
+	for !runSLOProbeScheduler(ctx, m, tracker, queue, schedule) {
+		log.Println("Restarting SLO probe scheduler")
+	}
+	queue.close()
+	wg.Wait()
+}
+
+// runSLOProbeWorker runs queued probes, and the health checks of services
+// with a probe target, until the queue is closed, restarting whenever it
+// panics
+// The wait before every probe but the first is recorded as idle time
+func runSLOProbeWorker(m *Metrics, tracker *SLOTracker, queue *probeQueue) {
+	var finished time.Time
+	for !runSLOProbeJobs(m, tracker, queue, &finished) {
+		log.Println("Restarting SLO probe worker")
+	}
+}
+
+// runSLOProbeJobs runs queued probes; it returns true once the queue is
+// closed and false after recovering from a panic
+func runSLOProbeJobs(m *Metrics, tracker *SLOTracker, queue *probeQueue, finished *time.Time) (stopped bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("SLO probe worker panicked: %v", r)
+			m.ProbeErrors.Inc()
+		}
+	}()
+
+	for {
+		job, ok := queue.next()
+		if !ok {
+			return true
+		}
+		if !finished.IsZero() {
+			m.ProbeWorkerIdle.Observe(time.Since(*finished).Seconds())
+		}
+		runProbeJob(m, tracker, job)
+		*finished = time.Now()
+	}
+}
+
+// runProbeJob records the SLO probe of a service and runs its health check
+// Jobs of services removed from the config since they were queued are
+// dropped, and a job that panics is logged and counted without stopping the
+// worker
+func runProbeJob(m *Metrics, tracker *SLOTracker, job probeJob) {
+	m.ActiveProbeGoroutines.Inc()
+	defer func() {
+		m.ActiveProbeGoroutines.Dec()
+		if r := recover(); r != nil {
+			log.Printf("Probe job of service %s panicked: %v", job.service, r)
+			m.ProbeErrors.Inc()
+		}
+	}()
+
+	if job.ctx.Err() != nil {
+		return
+	}
+	probeOne(m, tracker, job.service, job.configuredUp)
+	if job.target != nil {
+		resp := m.Prober.runProbe(job.ctx, job.service, *job.target)
+		if job.ctx.Err() == nil {
+			tracker.healthChecks.record(m, job.service, resp, time.Now())
+		}
+	}
+}
+
+// runSLOProbeScheduler queues probes as they become due; it returns true
+// once ctx is cancelled and false after recovering from a panic
+func runSLOProbeScheduler(ctx context.Context, m *Metrics, tracker *SLOTracker, queue *probeQueue, schedule *probeSchedule) (stopped bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("SLO probe scheduler panicked: %v", r)
+			m.ProbeErrors.Inc()
+		}
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case now := <-timer.C:
+			if config := loadCurrentConfig(); config != nil {
+				scheduleProbes(ctx, m, tracker, queue, schedule, config, now)
+			}
+			timer.Reset(schedule.until(time.Now()))
+		}
+	}
+}
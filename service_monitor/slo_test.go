@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSLOConfig_WindowSize(t *testing.T) {
+	tests := []struct {
+		cfg  SLOConfig
+		want int
+	}{
+		{SLOConfig{}, 60},
+		{SLOConfig{WindowMinutes: 60, ProbeIntervalSeconds: 30}, 120},
+		{SLOConfig{WindowMinutes: 5, ProbeIntervalSeconds: 60}, 5},
+		{SLOConfig{WindowMinutes: 1, ProbeIntervalSeconds: 600}, 1},
+	}
+
+	for _, tt := range tests {
+		if got := tt.cfg.windowSize(); got != tt.want {
+			t.Errorf("windowSize(%+v) = %d, want %d", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestSLOTracker_AvailabilityRatio(t *testing.T) {
+	m := newTestMetrics()
+	tracker := NewSLOTracker(SLOConfig{}.windowSize(), defaultHistoryDepth)
+
+	up := &Config{UpServices: []string{"api-gateway"}}
+	down := &Config{DownServices: []string{"api-gateway"}}
+
+	// 45 up probes followed by 15 down probes
+	for i := 0; i < 60; i++ {
+		config := up
+		if i >= 45 {
+			config = down
+		}
+		probeServices(m, tracker, config)
+
+		got := testutil.ToFloat64(m.AvailabilityRatio.WithLabelValues("api-gateway"))
+		if i < 59 && got != -1 {
+			t.Fatalf("expected -1 before window is full (probe %d), got %v", i+1, got)
+		}
+	}
+
+	if got := testutil.ToFloat64(m.AvailabilityRatio.WithLabelValues("api-gateway")); got != 0.75 {
+		t.Errorf("expected ratio 0.75 after 60 probes, got %v", got)
+	}
+
+	// 15 more up probes evict the oldest 15 up outcomes, leaving the ratio unchanged
+	for i := 0; i < 15; i++ {
+		probeServices(m, tracker, up)
+	}
+	if got := testutil.ToFloat64(m.AvailabilityRatio.WithLabelValues("api-gateway")); got != 0.75 {
+		t.Errorf("expected ratio 0.75 after wraparound, got %v", got)
+	}
+
+	// 45 more up probes push out every down outcome
+	for i := 0; i < 45; i++ {
+		probeServices(m, tracker, up)
+	}
+	if got := testutil.ToFloat64(m.AvailabilityRatio.WithLabelValues("api-gateway")); got != 1 {
+		t.Errorf("expected ratio 1 once down probes leave the window, got %v", got)
+	}
+}
+
+func TestProbeServices_DropsRemovedServices(t *testing.T) {
+	m := newTestMetrics()
+	tracker := NewSLOTracker(3, defaultHistoryDepth)
+
+	probeServices(m, tracker, &Config{UpServices: []string{"a", "b"}})
+	probeServices(m, tracker, &Config{UpServices: []string{"a"}})
+
+	if n := testutil.CollectAndCount(m.AvailabilityRatio); n != 1 {
+		t.Errorf("expected 1 availability series after removing a service, got %d", n)
+	}
+}
+
+// panicOnceContext panics the first time the scheduler waits on it,
+// simulating a crash of the probe loop outside any single probe
+type panicOnceContext struct {
+	context.Context
+	panicked atomic.Bool
+}
+
+func (c *panicOnceContext) Done() <-chan struct{} {
+	if c.panicked.CompareAndSwap(false, true) {
+		panic("probe loop crashed")
+	}
+	return c.Context.Done()
+}
+
+// probeCount returns the number of outcomes recorded for service
+func probeCount(tracker *SLOTracker, service string) int {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if w, ok := tracker.windows[service]; ok {
+		return w.count
+	}
+	return 0
+}
+
+// minProbeCount returns the fewest outcomes recorded for any of services
+func minProbeCount(tracker *SLOTracker, services ...string) int {
+	n := -1
+	for _, service := range services {
+		if c := probeCount(tracker, service); n < 0 || c < n {
+			n = c
+		}
+	}
+	return n
+}
+
+func TestProbePanic_Recovery(t *testing.T) {
+	origConfig := loadCurrentConfig()
+	currentConfig.Store(&Config{UpServices: []string{"api-gateway", "auth-service"}, DownServices: []string{"user-service"}})
+	t.Cleanup(func() { currentConfig.Store(origConfig) })
+
+	// The second probe panics
+	var calls atomic.Int64
+	origProbe := probeService
+	probeService = func(service string, configuredUp bool) bool {
+		if calls.Add(1) == 2 {
+			panic("nil probe target")
+		}
+		return configuredUp
+	}
+	t.Cleanup(func() { probeService = origProbe })
+
+	m := newTestMetrics()
+	tracker := NewSLOTracker(100, defaultHistoryDepth)
+	ctx, cancel := context.WithCancel(context.Background())
+	worker := &panicOnceContext{Context: ctx}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runSLOProbes(worker, m, tracker, 5*time.Millisecond, defaultProbeWorkers)
+	}()
+
+	// Wait for a few rounds after the restart
+	deadline := time.Now().Add(5 * time.Second)
+	for minProbeCount(tracker, "api-gateway", "auth-service", "user-service") < 3 {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("probes stopped after the panic: %d calls", calls.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("probe loop did not stop on cancel")
+	}
+
+	if !worker.panicked.Load() {
+		t.Fatal("expected the probe loop to have crashed once")
+	}
+	// One panicking probe and one crash of the loop
+	if v := testutil.ToFloat64(m.ProbeErrors); v != 2 {
+		t.Errorf("expected 2 probe errors, got %v", v)
+	}
+
+	// Every probe but the panicking one was recorded, and the restarted
+	// scheduler kept probing every service
+	total := 0
+	for _, service := range []string{"api-gateway", "auth-service", "user-service"} {
+		n := probeCount(tracker, service)
+		if n < 3 {
+			t.Errorf("expected %s to be probed at least 3 times, got %d", service, n)
+		}
+		total += n
+	}
+	if want := int(calls.Load()) - 1; total != want {
+		t.Errorf("expected %d recorded probes, got %d", want, total)
+	}
+}
+
+func TestProbePanic_WorkerJob(t *testing.T) {
+	preserveConfigState(t)
+	currentConfig.Store(&Config{
+		UpServices: []string{"api-gateway", "auth-service"},
+		Probes: map[string]ProbeTarget{
+			"api-gateway":  {URL: "http://api-gateway.test/healthz"},
+			"auth-service": {URL: "http://auth-service.test/healthz"},
+		},
+	})
+
+	// Every health check of auth-service panics inside the worker
+	m := newTestMetrics()
+	panickingProber(m, "auth-service")
+	tracker := NewSLOTracker(3, defaultHistoryDepth)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runSLOProbes(ctx, m, tracker, 5*time.Millisecond, defaultProbeWorkers)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for minProbeCount(tracker, "api-gateway", "auth-service") < 3 {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatal("the probe worker stopped after a job panicked")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if v := testutil.ToFloat64(m.ProbeErrors); v < 3 {
+		t.Errorf("expected every panicking job to be counted, got %v probe errors", v)
+	}
+	if v := testutil.ToFloat64(m.AvailabilityRatio.WithLabelValues("api-gateway")); v != 1 {
+		t.Errorf("expected the availability of api-gateway to keep updating, got %v", v)
+	}
+	if results, _ := tracker.healthChecks.results("api-gateway"); len(results) < 3 {
+		t.Errorf("expected the health checks of api-gateway to keep running, got %d", len(results))
+	}
+	if v := testutil.ToFloat64(m.ActiveProbeGoroutines); v != 0 {
+		t.Errorf("expected no active probes after the worker stopped, got %v", v)
+	}
+}
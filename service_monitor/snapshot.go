@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/common/expfmt"
+)
+
+// snapshotResponse is the JSON body returned by /metrics/snapshot
+type snapshotResponse struct {
+	SnapshotID   string `json:"snapshot_id,omitempty"`
+	SnapshotPath string `json:"snapshot_path,omitempty"`
+	BytesWritten int    `json:"bytes_written"`
+	SeriesCount  int    `json:"series_count"`
+	Error        string `json:"error,omitempty"`
+}
+
+// resolveSnapshotPath returns the file a snapshot is written to, which must be
+// inside baseDir; an empty path picks a timestamped name
+func resolveSnapshotPath(baseDir, path string, now time.Time) (string, error) {
+	if path == "" {
+		path = fmt.Sprintf("metrics-snapshot-%d.txt", now.Unix())
+	}
+	if strings.Contains(path, "..") {
+		return "", fmt.Errorf("path must not contain ..")
+	}
+
+	base, err := filepath.Abs(baseDir)
+	if err != nil {
+		return "", fmt.Errorf("invalid snapshot base dir: %w", err)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+	path = filepath.Clean(path)
+
+	rel, err := filepath.Rel(base, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("path must be a file under %s", base)
+	}
+	return path, nil
+}
+
+// metricsSnapshotHandler writes the current metrics in the text exposition
+// format to a file under baseDir for later analysis, and keeps them in store
+// under the returned snapshot ID for /metrics/diff
+func metricsSnapshotHandler(m *Metrics, baseDir string, store *MetricsSnapshotStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		path, err := resolveSnapshotPath(baseDir, r.URL.Query().Get("path"), time.Now())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, snapshotResponse{Error: err.Error()})
+			return
+		}
+
+		// Gather from the registry directly so the snapshot is never a cached result
+		mfs, err := m.Registry.Gather()
+		if err != nil {
+			log.Printf("Snapshot gathered with errors: %v", err)
+		}
+
+		var buf bytes.Buffer
+		enc := expfmt.NewEncoder(&buf, expfmt.FmtText)
+		series := 0
+		for _, mf := range mfs {
+			if err := enc.Encode(mf); err != nil {
+				writeJSON(w, http.StatusInternalServerError, snapshotResponse{Error: fmt.Sprintf("error encoding metrics: %v", err)})
+				return
+			}
+			series += len(mf.GetMetric())
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = os.WriteFile(path, buf.Bytes(), 0644)
+		}
+		if err != nil {
+			log.Printf("Error writing metrics snapshot: %v", err)
+			writeJSON(w, http.StatusInternalServerError, snapshotResponse{Error: fmt.Sprintf("error writing snapshot: %v", err)})
+			return
+		}
+
+		id := uuid.NewString()
+		store.add(newMetricsSnapshot(id, mfs))
+
+		m.Snapshots.Inc()
+		log.Printf("Wrote metrics snapshot %s with %d series to %s", id, series, path)
+		writeJSON(w, http.StatusOK, snapshotResponse{
+			SnapshotID:   id,
+			SnapshotPath: path,
+			BytesWritten: buf.Len(),
+			SeriesCount:  series,
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestMetricsSnapshot(t *testing.T) {
+	baseDir := t.TempDir()
+	m := newTestMetrics()
+	updateServiceMetrics(m, &Config{UpServices: []string{"api-gateway"}, DownServices: []string{"user-service"}})
+	mux := NewMetricsServeMux(m, &ServerConfig{SnapshotBaseDir: baseDir})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics/snapshot?path=incident.txt", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp snapshotResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if want := filepath.Join(baseDir, "incident.txt"); resp.SnapshotPath != want {
+		t.Errorf("expected snapshot path %s, got %s", want, resp.SnapshotPath)
+	}
+
+	data, err := os.ReadFile(resp.SnapshotPath)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %v", err)
+	}
+	if len(data) != resp.BytesWritten {
+		t.Errorf("expected %d bytes, file has %d", resp.BytesWritten, len(data))
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("snapshot is not valid text format: %v", err)
+	}
+	up, ok := families["service_monitor_up"]
+	if !ok || len(up.GetMetric()) != 2 {
+		t.Errorf("expected service_monitor_up with 2 series in the snapshot, got %v", up)
+	}
+
+	series := 0
+	for _, mf := range families {
+		series += len(mf.GetMetric())
+	}
+	if resp.SeriesCount != series {
+		t.Errorf("expected series count %d, got %d", series, resp.SeriesCount)
+	}
+	if got := testutil.ToFloat64(m.Snapshots); got != 1 {
+		t.Errorf("expected 1 snapshot, got %v", got)
+	}
+}
+
+func TestMetricsSnapshot_RejectsPathsOutsideBaseDir(t *testing.T) {
+	baseDir := t.TempDir()
+	mux := NewMetricsServeMux(newTestMetrics(), &ServerConfig{SnapshotBaseDir: baseDir})
+
+	for _, path := range []string{"../escape.txt", "a/../../escape.txt", "/etc/passwd", filepath.Dir(baseDir) + "/other.txt"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics/snapshot?path="+path, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/snapshot", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: expected 405, got %d", rec.Code)
+	}
+}
+
+func TestResolveSnapshotPath_Default(t *testing.T) {
+	baseDir := t.TempDir()
+	path, err := resolveSnapshotPath(baseDir, "", time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(baseDir, "metrics-snapshot-1700000000.txt"); path != want {
+		t.Errorf("expected %s, got %s", want, path)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// startupConfigPollInterval is how often a missing initial config is looked for
+var startupConfigPollInterval = 250 * time.Millisecond
+
+// startupGate holds back scrapes until the first config is applied, so
+// Prometheus never records the fallback services of a late config file
+type startupGate struct {
+	// Closed once a config is applied
+	ready chan struct{}
+	once  sync.Once
+	start time.Time
+}
+
+// newStartupGate returns a gate that blocks until it is opened
+func newStartupGate() *startupGate {
+	return &startupGate{ready: make(chan struct{}), start: time.Now()}
+}
+
+// open lets requests through and records how long startup was blocked
+func (g *startupGate) open(m *Metrics) {
+	g.once.Do(func() {
+		m.StartupWait.Set(time.Since(g.start).Seconds())
+		close(g.ready)
+	})
+}
+
+// isOpen reports whether a config has been applied
+func (g *startupGate) isOpen() bool {
+	select {
+	case <-g.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// startupGateMiddleware answers 503 until gate opens; a nil gate never blocks
+func startupGateMiddleware(gate *startupGate) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if gate == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !gate.isOpen() {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Waiting for the initial config", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// waitForInitialConfig loads the config file as soon as it appears and opens
+// the gate, applying fallback instead once timeout passes without a valid config
+func waitForInitialConfig(ctx context.Context, m *Metrics, cfg *ServerConfig, fallback *Config, timeout time.Duration) {
+	gate := cfg.StartupGate
+	deadline := time.Now().Add(timeout)
+
+	for {
+		// Only load once the file exists, so waiting doesn't count as failed loads
+		if _, err := cfg.fileSystem().Stat(cfg.ConfigPath); !os.IsNotExist(err) {
+			config, err := reloadConfig(m, cfg, loadTriggerStartup)
+			if err == nil {
+				log.Printf("Loaded initial config with %d up services and %d down services after %s",
+					len(config.UpServices), len(config.DownServices), time.Since(gate.start).Round(time.Millisecond))
+				gate.open(m)
+				return
+			}
+			log.Printf("Error loading initial config: %v", err)
+		}
+
+		if !time.Now().Before(deadline) {
+			log.Printf("No valid config at %s after %s, using the default config", cfg.ConfigPath, timeout)
+			configMutex.Lock()
+			applyConfig(m, cfg, fallback, loadTriggerStartup)
+			configMutex.Unlock()
+			gate.open(m)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(min(startupConfigPollInterval, time.Until(deadline))):
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fastStartupPolls shortens the poll interval for a missing initial config
+func fastStartupPolls(t *testing.T) {
+	orig := startupConfigPollInterval
+	startupConfigPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { startupConfigPollInterval = orig })
+}
+
+func metricsStatus(mux http.Handler) int {
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return rec.Code
+}
+
+func TestStartupGate_DelayedConfigFile(t *testing.T) {
+	preserveConfigState(t)
+	fastStartupPolls(t)
+	currentConfig.Store(nil)
+
+	m := newTestMetrics()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	cfg := &ServerConfig{ConfigPath: path, StartupGate: newStartupGate()}
+	mux := NewMetricsServeMux(m, cfg)
+
+	if code := metricsStatus(mux); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before the config exists, got %d", code)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		waitForInitialConfig(context.Background(), m, cfg, &Config{UpServices: []string{"default-service"}}, 5*time.Second)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if code := metricsStatus(mux); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while waiting, got %d", code)
+	}
+	if loadCurrentConfig() != nil {
+		t.Fatal("expected no config to be applied while waiting")
+	}
+
+	if err := os.WriteFile(path, []byte("up_services = [\"api-gateway\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return metricsStatus(mux) == http.StatusOK }) {
+		t.Fatal("/metrics still unavailable after the config file appeared")
+	}
+	<-done
+
+	if got := loadCurrentConfig().UpServices; len(got) != 1 || got[0] != "api-gateway" {
+		t.Errorf("expected the config file to be applied, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.StartupWait); got < 0.05 {
+		t.Errorf("expected the startup wait to cover the delay, got %v", got)
+	}
+}
+
+func TestStartupGate_Timeout(t *testing.T) {
+	preserveConfigState(t)
+	fastStartupPolls(t)
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{ConfigPath: filepath.Join(t.TempDir(), "config.toml"), StartupGate: newStartupGate()}
+	mux := NewMetricsServeMux(m, cfg)
+
+	waitForInitialConfig(context.Background(), m, cfg, &Config{UpServices: []string{"default-service"}}, 50*time.Millisecond)
+
+	if code := metricsStatus(mux); code != http.StatusOK {
+		t.Errorf("expected 200 after the wait timed out, got %d", code)
+	}
+	if got := loadCurrentConfig().UpServices; len(got) != 1 || got[0] != "default-service" {
+		t.Errorf("expected the fallback config, got %v", got)
+	}
+}
+
+func TestStartupGate_Disabled(t *testing.T) {
+	mux := NewMetricsServeMux(newTestMetrics(), &ServerConfig{})
+	if code := metricsStatus(mux); code != http.StatusOK {
+		t.Errorf("expected 200 without a startup gate, got %d", code)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// exportedService is the state of one service in the exported state file
+type exportedService struct {
+	Status        string    `json:"status"`
+	Source        string    `json:"source"`
+	LastChangedAt time.Time `json:"last_changed_at"`
+}
+
+// exportedState is the JSON document written to STATE_EXPORT_PATH
+type exportedState struct {
+	GeneratedAt time.Time                  `json:"generated_at"`
+	Services    map[string]exportedService `json:"services"`
+}
+
+// buildExportedState captures the service state of config, which came from
+// source
+// The caller must hold configMutex when other goroutines are running
+func buildExportedState(config *Config, source string, now time.Time) exportedState {
+	state := exportedState{GeneratedAt: now, Services: make(map[string]exportedService)}
+	for name, up := range serviceStatuses(config) {
+		status := "down"
+		if up {
+			status = "up"
+		}
+		state.Services[name] = exportedService{
+			Status:        status,
+			Source:        source,
+			LastChangedAt: serviceChangedAt[name],
+		}
+	}
+	return state
+}
+
+// stateExporter writes the service state to a file for consumers that don't
+// scrape Prometheus, either on every change or at most once per interval
+type stateExporter struct {
+	path     string
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending *exportedState
+}
+
+func newStateExporter(path string, interval time.Duration) *stateExporter {
+	return &stateExporter{path: path, interval: interval}
+}
+
+// update exports state immediately, or queues it for the next tick when throttled
+func (e *stateExporter) update(m *Metrics, state exportedState) {
+	if e.interval <= 0 {
+		e.write(m, state)
+		return
+	}
+
+	e.mu.Lock()
+	e.pending = &state
+	e.mu.Unlock()
+}
+
+// run writes the most recent queued state every interval
+func (e *stateExporter) run(m *Metrics) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		e.flush(m)
+	}
+}
+
+// flush writes the queued state, if any
+func (e *stateExporter) flush(m *Metrics) {
+	e.mu.Lock()
+	state := e.pending
+	e.pending = nil
+	e.mu.Unlock()
+
+	if state != nil {
+		e.write(m, *state)
+	}
+}
+
+// write atomically replaces the state file so readers never see a partial write
+func (e *stateExporter) write(m *Metrics, state exportedState) {
+	if err := writeFileAtomic(e.path, state); err != nil {
+		m.StateExportWriteErrors.Inc()
+		log.Printf("Error exporting service state: %v", err)
+		return
+	}
+	m.StateExportWrites.Inc()
+}
+
+// writeFileAtomic encodes v as JSON into a temp file next to path and renames it over path
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error renaming temp file: %w", err)
+	}
+	return nil
+}
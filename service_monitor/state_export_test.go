@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// readExportedState parses the state file at path
+func readExportedState(t *testing.T, path string) exportedState {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read state file: %v", err)
+	}
+	var state exportedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("state file is not valid JSON: %v\n%s", err, data)
+	}
+	return state
+}
+
+func TestStateExport_AfterStatusChanges(t *testing.T) {
+	setTestServices(t, nil, nil)
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := newTestMetrics()
+	cfg := &ServerConfig{StateExportPath: path}
+
+	changes := []*Config{
+		{UpServices: []string{"api", "db"}},
+		{UpServices: []string{"api"}, DownServices: []string{"db"}},
+		{UpServices: []string{"api", "cache"}, DownServices: []string{"db"}},
+	}
+	for _, config := range changes {
+		applyConfig(m, cfg, config, loadTriggerManual)
+	}
+
+	state := readExportedState(t, path)
+	want := map[string]string{"api": "up", "cache": "up", "db": "down"}
+	if len(state.Services) != len(want) {
+		t.Fatalf("expected %d services, got %v", len(want), state.Services)
+	}
+	for name, status := range want {
+		svc := state.Services[name]
+		if svc.Status != status || svc.Source != configSourceFile || svc.LastChangedAt.IsZero() {
+			t.Errorf("unexpected state for %s: %+v", name, svc)
+		}
+	}
+
+	if got := testutil.ToFloat64(m.StateExportWrites); got != 3 {
+		t.Errorf("expected 3 writes, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.StateExportWriteErrors); got != 0 {
+		t.Errorf("expected no write errors, got %v", got)
+	}
+
+	// No temp files are left behind
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected only the state file, found %d entries", len(entries))
+	}
+}
+
+func TestStateExport_ReportsConfigSource(t *testing.T) {
+	setTestServices(t, nil, nil)
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	cfg := &ServerConfig{StateExportPath: path, ConfigSource: configSourceRemote}
+	applyConfig(newTestMetrics(), cfg, &Config{UpServices: []string{"api"}}, loadTriggerRemote)
+
+	if svc := readExportedState(t, path).Services["api"]; svc.Source != configSourceRemote {
+		t.Errorf("expected the remote source, got %+v", svc)
+	}
+}
+
+func TestStateExport_Throttled(t *testing.T) {
+	setTestServices(t, nil, nil)
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := newTestMetrics()
+	cfg := &ServerConfig{StateExportPath: path, StateExportInterval: time.Hour}
+
+	applyConfig(m, cfg, &Config{UpServices: []string{"api"}}, loadTriggerManual)
+	applyConfig(m, cfg, &Config{DownServices: []string{"api"}}, loadTriggerManual)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no export before the interval elapsed, got %v", err)
+	}
+
+	// Only the latest state is written
+	cfg.stateExporter().flush(m)
+	if state := readExportedState(t, path); state.Services["api"].Status != "down" {
+		t.Errorf("expected the latest state, got %+v", state.Services)
+	}
+	if got := testutil.ToFloat64(m.StateExportWrites); got != 1 {
+		t.Errorf("expected 1 write, got %v", got)
+	}
+}
+
+func TestStateExport_WriteError(t *testing.T) {
+	setTestServices(t, nil, nil)
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{StateExportPath: filepath.Join(t.TempDir(), "missing", "state.json")}
+	applyConfig(m, cfg, &Config{UpServices: []string{"api"}}, loadTriggerManual)
+
+	if got := testutil.ToFloat64(m.StateExportWriteErrors); got != 1 {
+		t.Errorf("expected 1 write error, got %v", got)
+	}
+}
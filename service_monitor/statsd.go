@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	defaultStatsDPrefix = "service_monitor"
+
+	// statsdFlushInterval is how often request counts are forwarded between status updates
+	statsdFlushInterval = 10 * time.Second
+
+	// statsdMaxDatagramSize keeps datagrams below the typical Ethernet MTU
+	statsdMaxDatagramSize = 1432
+)
+
+// statsdRandom decides which sampled counter updates are sent; replaced in tests
+var statsdRandom = rand.Float64
+
+// statsdNameReplacer replaces characters that have a meaning in the StatsD wire format
+var statsdNameReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "/", "_", " ", "_", "\n", "_")
+
+// StatsDBridge forwards service status and request counts to a StatsD
+// aggregator as UDP datagrams
+type StatsDBridge struct {
+	mu         sync.Mutex
+	conn       net.Conn
+	w          *bufio.Writer
+	prefix     string
+	sampleRate float64
+
+	// Request count already forwarded
+	sentRequests float64
+}
+
+// NewStatsDBridge connects to the StatsD server at addr
+// Metric names start with prefix and counters are sent with probability sampleRate
+func NewStatsDBridge(addr, prefix string, sampleRate float64) (*StatsDBridge, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to StatsD: %w", err)
+	}
+	if prefix == "" {
+		prefix = defaultStatsDPrefix
+	}
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+	return &StatsDBridge{
+		conn:       conn,
+		w:          bufio.NewWriterSize(conn, statsdMaxDatagramSize),
+		prefix:     prefix,
+		sampleRate: sampleRate,
+	}, nil
+}
+
+// sendStatus sends a gauge per service of config together with the requests
+// processed since the last batch
+func (b *StatsDBridge) sendStatus(m *Metrics, config *Config) {
+	statuses := serviceStatuses(config)
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, name := range names {
+		value := 0
+		if statuses[name] {
+			value = 1
+		}
+		b.writeLocked(m, b.prefix+".up."+statsdNameReplacer.Replace(name)+":"+strconv.Itoa(value)+"|g")
+	}
+	b.writeRequestsLocked(m)
+	b.flushLocked(m)
+}
+
+// run forwards the request count every interval
+func (b *StatsDBridge) run(m *Metrics, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.mu.Lock()
+		b.writeRequestsLocked(m)
+		b.flushLocked(m)
+		b.mu.Unlock()
+	}
+}
+
+// writeRequestsLocked writes the increment of the request counter since the
+// last batch, sampled at the configured rate; b.mu must be held
+func (b *StatsDBridge) writeRequestsLocked(m *Metrics) {
+	total := counterValue(m.RequestsProcessed)
+	delta := total - b.sentRequests
+	if delta < 0 {
+		// The counter was reset through /metrics/reset
+		delta = total
+	}
+	b.sentRequests = total
+	if delta == 0 {
+		return
+	}
+
+	line := b.prefix + ".requests:" + strconv.FormatFloat(delta, 'f', -1, 64) + "|c"
+	if b.sampleRate < 1 {
+		if statsdRandom() >= b.sampleRate {
+			return
+		}
+		line += "|@" + strconv.FormatFloat(b.sampleRate, 'f', -1, 64)
+	}
+	b.writeLocked(m, line)
+}
+
+// writeLocked buffers one metric line, sending the pending ones first if the
+// line would not fit in the current datagram; b.mu must be held
+func (b *StatsDBridge) writeLocked(m *Metrics, line string) {
+	if b.w.Buffered() > 0 && b.w.Available() < len(line)+1 {
+		b.flushLocked(m)
+	}
+	if b.w.Buffered() > 0 {
+		b.w.WriteByte('\n')
+	}
+	b.w.WriteString(line)
+}
+
+// flushLocked sends the buffered lines as one datagram; b.mu must be held
+func (b *StatsDBridge) flushLocked(m *Metrics) {
+	if b.w.Buffered() == 0 {
+		return
+	}
+	if err := b.w.Flush(); err != nil {
+		m.StatsDSendErrors.Inc()
+		log.Printf("Error sending StatsD datagram: %v", err)
+		// Drop the batch rather than retrying it forever
+		b.w.Reset(b.conn)
+	}
+}
+
+// counterValue returns the current value of c
+func counterValue(c prometheus.Counter) float64 {
+	var metric dto.Metric
+	if err := c.Write(&metric); err != nil {
+		return 0
+	}
+	return metric.GetCounter().GetValue()
+}
+
+// Close closes the UDP socket
+func (b *StatsDBridge) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.conn.Close()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenStatsD binds a UDP listener standing in for a StatsD server
+func listenStatsD(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readStatsD reads one datagram and returns its metric lines by name
+func readStatsD(t *testing.T, conn *net.UDPConn) map[string]string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no datagram received: %v", err)
+	}
+
+	lines := make(map[string]string)
+	for _, line := range strings.Split(string(buf[:n]), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.Contains(value, "|") {
+			t.Fatalf("malformed StatsD line %q", line)
+		}
+		lines[name] = value
+	}
+	return lines
+}
+
+func TestStatsDBridge_SendsStatusAndRequests(t *testing.T) {
+	server := listenStatsD(t)
+	bridge, err := NewStatsDBridge(server.LocalAddr().String(), "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.Close()
+
+	m := newTestMetrics()
+	cfg := &ServerConfig{StatsD: bridge}
+	m.RequestsProcessed.Add(3)
+
+	origConfig := loadCurrentConfig()
+	t.Cleanup(func() { currentConfig.Store(origConfig) })
+	applyConfig(m, cfg, &Config{
+		UpServices:   []string{"api-gateway", "default/auth"},
+		DownServices: []string{"user-service"},
+	}, loadTriggerManual)
+
+	got := readStatsD(t, server)
+	want := map[string]string{
+		"service_monitor.up.api-gateway":  "1|g",
+		"service_monitor.up.default_auth": "1|g",
+		"service_monitor.up.user-service": "0|g",
+		"service_monitor.requests":        "3|c",
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("expected %s:%s, got %q", name, value, got[name])
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d lines, got %v", len(want), got)
+	}
+
+	// Only the increment since the last batch is sent
+	m.RequestsProcessed.Inc()
+	applyConfig(m, cfg, &Config{UpServices: []string{"api-gateway"}}, loadTriggerManual)
+	got = readStatsD(t, server)
+	if got["service_monitor.requests"] != "1|c" || got["service_monitor.up.api-gateway"] != "1|g" {
+		t.Errorf("unexpected second batch: %v", got)
+	}
+}
+
+func TestStatsDBridge_PrefixAndSampling(t *testing.T) {
+	origRandom := statsdRandom
+	t.Cleanup(func() { statsdRandom = origRandom })
+
+	server := listenStatsD(t)
+	bridge, err := NewStatsDBridge(server.LocalAddr().String(), "edge", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.Close()
+	m := newTestMetrics()
+
+	// A sampled-in increment carries the rate so the aggregator can scale it
+	statsdRandom = func() float64 { return 0.1 }
+	m.RequestsProcessed.Add(2)
+	bridge.sendStatus(m, &Config{UpServices: []string{"api"}})
+	got := readStatsD(t, server)
+	if got["edge.requests"] != "2|c|@0.5" || got["edge.up.api"] != "1|g" {
+		t.Errorf("unexpected sampled batch: %v", got)
+	}
+
+	// Gauges are always sent, sampled-out increments are not
+	statsdRandom = func() float64 { return 0.9 }
+	m.RequestsProcessed.Add(2)
+	bridge.sendStatus(m, &Config{UpServices: []string{"api"}})
+	got = readStatsD(t, server)
+	if _, ok := got["edge.requests"]; ok || got["edge.up.api"] != "1|g" {
+		t.Errorf("unexpected batch with a sampled-out increment: %v", got)
+	}
+}
+
+func TestStatsDBridge_SplitsLargeBatches(t *testing.T) {
+	server := listenStatsD(t)
+	bridge, err := NewStatsDBridge(server.LocalAddr().String(), "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.Close()
+
+	config := benchmarkConfig(200)
+	bridge.sendStatus(newTestMetrics(), config)
+
+	seen := 0
+	for seen < 200 {
+		seen += len(readStatsD(t, server))
+	}
+	if seen != 200 {
+		t.Errorf("expected 200 gauges, got %d", seen)
+	}
+}
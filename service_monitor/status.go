@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// statusResponse is the JSON body returned by /status
+type statusResponse struct {
+	HealthyServices  int       `json:"healthy_services"`
+	TotalServices    int       `json:"total_services"`
+	AvailabilityPct  float64   `json:"availability_pct"`
+	SLOMet           bool      `json:"slo_met"`
+	SLOThreshold     float64   `json:"slo_threshold"`
+	SLOWindowMinutes int       `json:"slo_window_minutes"`
+	DegradedServices []string  `json:"degraded_services"`
+	EvaluatedAt      time.Time `json:"evaluated_at"`
+}
+
+// writeStatusJSON encodes status into w
+// Service names are written as they are instead of escaping HTML characters,
+// which the response is never embedded in
+func writeStatusJSON(w io.Writer, status *statusResponse) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(status)
+}
+
+// buildStatus summarises the health of the services in config
+// Availability comes from the rolling SLO windows once every service has a
+// full window, and from the current up/down snapshot before that
+func buildStatus(config *Config, tracker *SLOTracker, now time.Time) statusResponse {
+	statuses := serviceStatuses(config)
+	status := statusResponse{
+		TotalServices:    len(statuses),
+		SLOThreshold:     config.SLO.threshold(),
+		SLOWindowMinutes: config.SLO.windowMinutes(),
+		DegradedServices: []string{},
+		EvaluatedAt:      now,
+	}
+
+	windowed := tracker != nil && len(statuses) > 0
+	var ratioSum float64
+	for name, up := range statuses {
+		if up {
+			status.HealthyServices++
+		} else {
+			status.DegradedServices = append(status.DegradedServices, name)
+		}
+
+		if windowed {
+			ratio, full := tracker.Ratio(name)
+			windowed = full
+			ratioSum += ratio
+		}
+	}
+	sort.Strings(status.DegradedServices)
+
+	healthyRatio := 1.0
+	if status.TotalServices > 0 {
+		healthyRatio = float64(status.HealthyServices) / float64(status.TotalServices)
+	}
+	status.SLOMet = healthyRatio >= status.SLOThreshold
+
+	if windowed {
+		status.AvailabilityPct = 100 * ratioSum / float64(status.TotalServices)
+	} else {
+		status.AvailabilityPct = 100 * healthyRatio
+	}
+	return status
+}
+
+// statusHandler reports overall fleet health, answering 503 while the SLO is breached
+func statusHandler(cfg *ServerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		config := loadCurrentConfig()
+		if config == nil {
+			config = &Config{}
+		}
+
+		status := buildStatus(config, cfg.SLOTracker, time.Now())
+		code := http.StatusOK
+		if !status.SLOMet {
+			code = http.StatusServiceUnavailable
+		}
+		writeEncoded(w, code, func(buf *bytes.Buffer) error {
+			return writeStatusJSON(buf, &status)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+func TestStatusHandler_SLOBreached(t *testing.T) {
+	// 9 of 10 services up is below a 95% threshold
+	up := []string{"s1", "s2", "s3", "s4", "s5", "s6", "s7", "s8", "s9"}
+	setTestServices(t, up, []string{"notification-service"})
+	config := *loadCurrentConfig()
+	config.SLO.Threshold = 0.95
+	currentConfig.Store(&config)
+
+	rec := httptest.NewRecorder()
+	statusHandler(&ServerConfig{})(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp statusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if resp.HealthyServices != 9 || resp.TotalServices != 10 || resp.SLOMet {
+		t.Errorf("expected 9 of 10 healthy with the SLO breached, got %+v", resp)
+	}
+	if resp.AvailabilityPct != 90 || resp.SLOThreshold != 0.95 {
+		t.Errorf("expected 90%% availability against 0.95, got %+v", resp)
+	}
+	if len(resp.DegradedServices) != 1 || resp.DegradedServices[0] != "notification-service" {
+		t.Errorf("expected notification-service to be degraded, got %v", resp.DegradedServices)
+	}
+}
+
+func TestStatusHandler_SLOMet(t *testing.T) {
+	setTestServices(t, []string{"api", "db"}, nil)
+
+	rec := httptest.NewRecorder()
+	statusHandler(&ServerConfig{})(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp statusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if !resp.SLOMet || resp.SLOThreshold != defaultSLOThreshold || resp.SLOWindowMinutes != defaultSLOWindowMinutes {
+		t.Errorf("expected the SLO met with the defaults, got %+v", resp)
+	}
+	if resp.DegradedServices == nil || len(resp.DegradedServices) != 0 {
+		t.Errorf("expected an empty degraded list, got %v", resp.DegradedServices)
+	}
+}
+
+func TestBuildStatus_Availability(t *testing.T) {
+	config := &Config{UpServices: []string{"api"}, DownServices: []string{"db"}}
+	tracker := NewSLOTracker(2, defaultHistoryDepth)
+	now := time.Unix(1000, 0)
+
+	// Before the windows fill up availability comes from the snapshot
+	tracker.Record("api", true)
+	tracker.Record("db", false)
+	if got := buildStatus(config, tracker, now).AvailabilityPct; got != 50 {
+		t.Errorf("expected snapshot availability 50, got %v", got)
+	}
+
+	// Once they are full the rolling ratios are averaged
+	tracker.Record("api", true)
+	tracker.Record("db", true)
+	if got := buildStatus(config, tracker, now).AvailabilityPct; got != 75 {
+		t.Errorf("expected windowed availability 75, got %v", got)
+	}
+}
+
+func TestStatusEndpointResponseSchema(t *testing.T) {
+	setTestServices(t, []string{"api"}, []string{"db"})
+
+	rec := httptest.NewRecorder()
+	statusHandler(&ServerConfig{})(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	keys := make([]string, 0, len(resp))
+	for key := range resp {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	golden := filepath.Join("testdata", "status_schema.json")
+	if *update {
+		data, err := json.MarshalIndent(keys, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("error reading golden file (run with -update to create it): %v", err)
+	}
+	var want []string
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatalf("invalid golden file: %v", err)
+	}
+
+	if len(keys) != len(want) {
+		t.Fatalf("/status keys changed, run with -update if intended\ngot:  %v\nwant: %v", keys, want)
+	}
+	for i := range keys {
+		if keys[i] != want[i] {
+			t.Fatalf("/status keys changed, run with -update if intended\ngot:  %v\nwant: %v", keys, want)
+		}
+	}
+}
+
+func TestWriteStatusJSON(t *testing.T) {
+	status := statusResponse{
+		TotalServices:    2,
+		HealthyServices:  1,
+		DegradedServices: []string{"payments<eu>&co"},
+		EvaluatedAt:      time.Unix(1700000000, 0).UTC(),
+	}
+
+	var buf strings.Builder
+	if err := writeStatusJSON(&buf, &status); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"degraded_services":["payments<eu>&co"]`) {
+		t.Errorf("expected the service name without HTML escaping, got %s", buf.String())
+	}
+
+	var got statusResponse
+	if err := json.Unmarshal([]byte(buf.String()), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if got.TotalServices != 2 || got.HealthyServices != 1 || !got.EvaluatedAt.Equal(status.EvaluatedAt) {
+		t.Errorf("status changed by the round trip: %+v", got)
+	}
+}
+
+// Run with go test -run=TestStatus -bench=Status -benchmem
+func BenchmarkStatusJSON(b *testing.B) {
+	status := statusResponse{TotalServices: 100, HealthyServices: 90, EvaluatedAt: time.Unix(1700000000, 0)}
+	for i := 0; i < 10; i++ {
+		status.DegradedServices = append(status.DegradedServices, fmt.Sprintf("service-%03d", i))
+	}
+
+	for _, impl := range []struct {
+		name  string
+		write func(http.ResponseWriter)
+	}{
+		{"writeJSON", func(w http.ResponseWriter) { writeJSON(w, http.StatusOK, status) }},
+		{"writeStatusJSON", func(w http.ResponseWriter) {
+			writeEncoded(w, http.StatusOK, func(buf *bytes.Buffer) error {
+				return writeStatusJSON(buf, &status)
+			})
+		}},
+	} {
+		b.Run(impl.name, func(b *testing.B) {
+			w := newDiscardResponseWriter()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				impl.write(w)
+			}
+		})
+	}
+}